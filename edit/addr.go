@@ -6,9 +6,11 @@ import (
 	"errors"
 	"io"
 	"regexp"
+	"regexp/syntax"
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 var (
@@ -289,7 +291,29 @@ func (a line) where(from int64, text Text) (Span, error) {
 	return lineForward(a.n, from, text)
 }
 
+// A lineIndex is a Text that can find lines without reading the text.
+// Its unit of measurement is runes.
+type lineIndex interface {
+	// Newlines returns the number of newlines before an offset.
+	newlines(int64) (int64, error)
+	// Newline returns the offset of the nth newline,
+	// where the first newline is n=0.
+	newline(int64) (int64, error)
+}
+
+// GetLineIndex returns the lineIndex of the Text, if any.
+func getLineIndex(text Text) (lineIndex, bool) {
+	if wd, ok := text.(withDot); ok {
+		text = wd.Text
+	}
+	li, ok := text.(lineIndex)
+	return li, ok
+}
+
 func lineForward(n int, from int64, text Text) (Span, error) {
+	if li, ok := getLineIndex(text); ok && 0 <= from && from <= text.Size() {
+		return lineForwardIndex(int64(n), from, text.Size(), li)
+	}
 	s := Span{from, from}
 	if from > 0 {
 		// Position s1 at the beginning of the next full line.
@@ -344,7 +368,72 @@ func lineForward(n int, from int64, text Text) (Span, error) {
 	return s, nil
 }
 
+// LineForwardIndex is lineForward using a lineIndex.
+func lineForwardIndex(n, from, size int64, li lineIndex) (Span, error) {
+	total, err := li.newlines(size)
+	if err != nil {
+		return Span{}, err
+	}
+	// lineEnd returns the end of the line containing newline i.
+	lineEnd := func(i int64) (int64, error) {
+		if i >= total {
+			return size, nil
+		}
+		nl, err := li.newline(i)
+		return nl + 1, err
+	}
+
+	s := Span{from, from}
+	// c is the number of the first newline at or after s[1].
+	var c int64
+	if from > 0 {
+		// Position s[1] at the beginning of the next full line.
+		if c, err = li.newlines(from - 1); err != nil {
+			return Span{}, err
+		}
+		if s[1], err = lineEnd(c); err != nil {
+			return Span{}, err
+		}
+		if c < total {
+			c++
+		}
+		if n > 0 {
+			s[0] = s[1]
+		}
+	}
+	if n == 0 || s[1] == size {
+		if n > 1 {
+			return Span{}, RangeError(size)
+		}
+		return s, nil
+	}
+	if m := total - c; m < n {
+		// There are not enough newlines; the line ends at the end of the text.
+		if m > 0 {
+			if s[0], err = lineEnd(total - 1); err != nil {
+				return Span{}, err
+			}
+		}
+		if n-m > 1 {
+			return Span{}, RangeError(size)
+		}
+		return Span{s[0], size}, nil
+	}
+	if n > 1 {
+		if s[0], err = lineEnd(c + n - 2); err != nil {
+			return Span{}, err
+		}
+	}
+	if s[1], err = lineEnd(c + n - 1); err != nil {
+		return Span{}, err
+	}
+	return s, nil
+}
+
 func lineBackward(n int, from int64, text Text) (Span, error) {
+	if li, ok := getLineIndex(text); ok && 0 <= from && from <= text.Size() {
+		return lineBackwardIndex(int64(n), from, text.Size(), li)
+	}
 	s := Span{from, from}
 	if s[0] < text.Size() {
 		rr := text.RuneReader(Span{from, 0})
@@ -395,6 +484,52 @@ func lineBackward(n int, from int64, text Text) (Span, error) {
 	return s, nil
 }
 
+// LineBackwardIndex is lineBackward using a lineIndex.
+func lineBackwardIndex(n, from, size int64, li lineIndex) (Span, error) {
+	// lineStart returns the start of the line containing the offset.
+	lineStart := func(at int64) (int64, error) {
+		c, err := li.newlines(at)
+		if err != nil || c == 0 {
+			return 0, err
+		}
+		nl, err := li.newline(c - 1)
+		return nl + 1, err
+	}
+
+	s := Span{from, from}
+	var err error
+	if from < size {
+		if s[0], err = lineStart(from); err != nil {
+			return Span{}, err
+		}
+	}
+	if n == 0 {
+		if s[0], err = lineStart(s[0]); err != nil {
+			return Span{}, err
+		}
+		return s, nil
+	}
+	c, err := li.newlines(s[0])
+	if err != nil {
+		return Span{}, err
+	}
+	if c < n {
+		// There are not enough newlines; the line starts at the beginning of the text.
+		if n-c > 1 {
+			return Span{}, RangeError(0)
+		}
+		return Span{}, nil
+	}
+	nl, err := li.newline(c - n)
+	if err != nil {
+		return Span{}, err
+	}
+	if s[0], err = lineStart(nl); err != nil {
+		return Span{}, err
+	}
+	return Span{s[0], nl + 1}, nil
+}
+
 type mark rune
 
 // Mark returns the Address of the named mark rune.
@@ -436,6 +571,9 @@ type regexpAddr struct {
 // it wraps to the beginning of the Text.
 // If a reverse search reaches the beginning of the Text without finding a match,
 // it wraps to the end of the Text.
+// A reverse search identifies the match ending closest before the search start,
+// at the earliest start of any match with that end;
+// the extent of the match is that of a forward, leftmost-first match from that start.
 //
 // The regular expression syntax is that of the standard library regexp package.
 // The syntax is documented here: https://github.com/google/re2/wiki/Syntax.
@@ -450,6 +588,11 @@ type regexpAddr struct {
 // the second "abc" in the first line.
 // Likewise, in a reverse search, the relative start location
 // is considered to be the end of text.
+//
+// A reverse search matches the reversed regular expression
+// against the text read backward from the relative start location.
+// It finds the match ending closest before the start location,
+// and it reads only as much of the text as needed to find it.
 func Regexp(regexp string) SimpleAddress               { return regexpAddr{regexp: regexp} }
 func (a regexpAddr) String() string                    { return "/" + Escape(a.regexp, '/') + "/" }
 func (a regexpAddr) To(b AdditiveAddress) Address      { return to{left: a, right: b} }
@@ -473,7 +616,11 @@ func (a regexpAddr) where(from int64, text Text) (Span, error) {
 	}
 	var m []int
 	if a.rev {
-		m = prevMatch(re, from, text, true)
+		rev, err := reverseRegexpCompile(a.regexp)
+		if err != nil {
+			return Span{}, err
+		}
+		m = prevMatch(re, rev, from, text, true)
 	} else {
 		m = nextMatch(re, from, text, true)
 	}
@@ -502,41 +649,260 @@ func nextMatch(re *regexp.Regexp, from int64, text Text, wrap bool) []int {
 	return nil
 }
 
-func prevMatch(re *regexp.Regexp, from int64, text Text, wrap bool) []int {
-	var prev []int
-	for {
-		span := Span{0, from}
-		if len(prev) >= 2 {
-			if prev[0] == prev[1] {
-				span[0] = int64(prev[1]) + 1
-			} else {
-				span[0] = int64(prev[1])
-			}
-		}
-		if span[0] > span[1] {
-			break
-		}
-		cur := match(re, span, text)
-		if len(cur) < 2 || len(prev) >= 2 && prev[1] == cur[1] && int64(cur[1]) == from {
-			break
-		}
-		prev = cur
-	}
-	if prev != nil {
-		return prev
+// PrevMatch returns the submatch indices of the match of re
+// ending closest before from, or nil if there is no match.
+// Rev must be the reverse of re, compiled by reverseRegexpCompile.
+//
+// The start of the match is found by reading the text in reverse from from,
+// so the cost is proportional to the distance scanned,
+// not to the size of the text.
+// The match is then found by matching re forward from that start,
+// so its extent and submatches are those of a forward, leftmost-first match.
+func prevMatch(re, rev *regexp.Regexp, from int64, text Text, wrap bool) []int {
+	if m := rev.FindReaderIndex(text.RuneReader(Span{from, 0})); len(m) >= 2 {
+		// m[1] is the distance back from from to the start of the match.
+		return match(re, Span{from - int64(m[1]), from}, text)
 	}
 	if size := text.Size(); from < size && wrap {
-		return prevMatch(re, size, text, false)
+		return prevMatch(re, rev, size, text, false)
 	}
 	return nil
 }
 
 func regexpCompile(re string) (*regexp.Regexp, error) {
+	return regexp.Compile(multiLine(re))
+}
+
+// ReverseRegexpCompile compiles a regular expression
+// that matches the reverse of the strings matched by re.
+// Matching it against the reversed text
+// finds the matches of re from the end of the text.
+// It prefers leftmost-longest matches,
+// so a match in the reversed text extends to
+// the earliest start of a match of re with the same end.
+func reverseRegexpCompile(re string) (*regexp.Regexp, error) {
+	// Compile first, for the error message of a bad regexp.
+	if _, err := regexpCompile(re); err != nil {
+		return nil, err
+	}
+	syn, err := syntax.Parse(multiLine(re), syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	reverseSyntax(syn)
+	rev, err := regexp.Compile(syn.String())
+	if err != nil {
+		return nil, err
+	}
+	rev.Longest()
+	return rev, nil
+}
+
+// MultiLine returns re wrapped in (?m:<re>).
+func multiLine(re string) string {
 	if re == "\\" || len(re) > 2 && re[len(re)-1] == '\\' && re[len(re)-2] != '\\' {
 		// Escape a trailing, unescaped \.
 		re = re + "\\"
 	}
-	return regexp.Compile("(?m:" + re + ")")
+	return "(?m:" + re + ")"
+}
+
+// ReverseSyntax reverses a regular expression syntax tree in place.
+// Concatenations and literals are reversed,
+// and the beginning and end of lines and text are swapped.
+func reverseSyntax(re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for i, j := 0, len(re.Rune)-1; i < j; i, j = i+1, j-1 {
+			re.Rune[i], re.Rune[j] = re.Rune[j], re.Rune[i]
+		}
+	case syntax.OpConcat:
+		for i, j := 0, len(re.Sub)-1; i < j; i, j = i+1, j-1 {
+			re.Sub[i], re.Sub[j] = re.Sub[j], re.Sub[i]
+		}
+	case syntax.OpBeginLine:
+		re.Op = syntax.OpEndLine
+	case syntax.OpEndLine:
+		re.Op = syntax.OpBeginLine
+	case syntax.OpBeginText:
+		re.Op = syntax.OpEndText
+		re.Flags &^= syntax.WasDollar
+	case syntax.OpEndText:
+		re.Op = syntax.OpBeginText
+		re.Flags &^= syntax.WasDollar
+	}
+	for _, sub := range re.Sub {
+		reverseSyntax(sub)
+	}
+}
+
+type balanced struct{ rev bool }
+
+// Balanced returns an Address identifying the string
+// from a bracket or quote to its matching partner, inclusive.
+// The brackets are (), [], and {};
+// the quotes are ", ', and `.
+//
+// If Balanced is the right-hand operand of -,
+// the address is found by searching in reverse
+// from the start of the left-hand operand to the first closing delimiter,
+// and then in reverse to its matching opening delimiter.
+// If Balanced is the right-hand operand of +,
+// the address is found by searching forward
+// from the end of the left-hand operand to the first opening delimiter,
+// and then forward to its matching closing delimiter.
+// Otherwise, if the first rune of the . mark is a closing bracket,
+// the search is in reverse from the end of that bracket.
+// Otherwise, the search is forward from the start of the . mark.
+//
+// Brackets nest with brackets of the same kind,
+// and other kinds of brackets are ignored.
+// Within " and ' quotes, a quote preceded by an odd number of \ is escaped.
+// Within ` quotes, there are no escapes.
+//
+// If there is no delimiter or it has no matching partner,
+// ErrNoMatch is returned.
+func Balanced() SimpleAddress                        { return balanced{} }
+func (a balanced) String() string                    { return "%" }
+func (a balanced) To(b AdditiveAddress) Address      { return to{left: a, right: b} }
+func (a balanced) Then(b AdditiveAddress) Address    { return then{left: a, right: b} }
+func (a balanced) Between(b AdditiveAddress) Address { return between{left: a, right: b} }
+
+func (a balanced) Plus(b SimpleAddress) AdditiveAddress  { return plus{left: a, right: b} }
+func (a balanced) Minus(b SimpleAddress) AdditiveAddress { return minus{left: a, right: b} }
+func (a balanced) reverse() SimpleAddress                { return balanced{rev: !a.rev} }
+
+func (a balanced) Where(text Text) (Span, error) {
+	dot := text.Mark('.')
+	if !a.rev {
+		r, w, err := text.RuneReader(Span{dot[0], text.Size()}).ReadRune()
+		if err != nil && err != io.EOF {
+			return Span{}, err
+		}
+		if err == nil && strings.ContainsRune(")]}", r) {
+			return a.reverse().where(dot[0]+int64(w), text)
+		}
+	}
+	return a.where(dot[0], text)
+}
+
+func (a balanced) where(from int64, text Text) (Span, error) {
+	if a.rev {
+		return balancedReverse(from, text)
+	}
+	return balancedForward(from, text)
+}
+
+// Partners maps each opening delimiter to its closing delimiter
+// and each closing delimiter to its opening delimiter.
+var partners = map[rune]rune{
+	'(': ')', ')': '(',
+	'[': ']', ']': '[',
+	'{': '}', '}': '{',
+	'"': '"', '\'': '\'', '`': '`',
+}
+
+func balancedForward(from int64, text Text) (Span, error) {
+	rr := text.RuneReader(Span{from, text.Size()})
+	start, at := from, from
+	var open rune
+	for open == 0 {
+		r, w, err := rr.ReadRune()
+		switch {
+		case err == io.EOF:
+			return Span{}, ErrNoMatch
+		case err != nil:
+			return Span{}, err
+		case strings.ContainsRune("([{\"'`", r):
+			open = r
+		}
+		start = at
+		at += int64(w)
+	}
+	close := partners[open]
+	var depth int
+	var esc bool
+	for {
+		r, w, err := rr.ReadRune()
+		switch {
+		case err == io.EOF:
+			return Span{}, ErrNoMatch
+		case err != nil:
+			return Span{}, err
+		}
+		at += int64(w)
+		switch {
+		case esc:
+			esc = false
+		case open == close && r == '\\' && open != '`':
+			esc = true
+		case r == close && depth == 0:
+			return Span{start, at}, nil
+		case r == close:
+			depth--
+		case r == open:
+			depth++
+		}
+	}
+}
+
+func balancedReverse(from int64, text Text) (Span, error) {
+	rr := text.RuneReader(Span{from, 0})
+	end, at := from, from
+	var close rune
+	for close == 0 {
+		r, w, err := rr.ReadRune()
+		switch {
+		case err == io.EOF:
+			return Span{}, ErrNoMatch
+		case err != nil:
+			return Span{}, err
+		case strings.ContainsRune(")]}\"'`", r):
+			close = r
+		}
+		end = at
+		at -= int64(w)
+	}
+	open := partners[close]
+	var depth int
+	for {
+		r, w, err := rr.ReadRune()
+		switch {
+		case err == io.EOF:
+			return Span{}, ErrNoMatch
+		case err != nil:
+			return Span{}, err
+		}
+		at -= int64(w)
+		if open == close {
+			if r != open || open != '`' && escaped(at, text) {
+				continue
+			}
+			return Span{at, end}, nil
+		}
+		switch {
+		case r == open && depth == 0:
+			return Span{at, end}, nil
+		case r == open:
+			depth--
+		case r == close:
+			depth++
+		}
+	}
+}
+
+// Escaped returns whether the rune at the given position
+// is preceded by an odd number of \ runes.
+func escaped(at int64, text Text) bool {
+	rr := text.RuneReader(Span{at, 0})
+	var n int
+	for {
+		r, _, err := rr.ReadRune()
+		if err != nil || r != '\\' {
+			return n%2 == 1
+		}
+		n++
+	}
 }
 
 type runeAddr int64
@@ -587,9 +953,356 @@ func (a runeAddr) where(from int64, text Text) (Span, error) {
 	return Span{from, from}, nil
 }
 
+type byteAddr int64
+
+// Byte returns the Address of the empty Span after byte n
+// of the UTF-8 encoding of the Text.
+// A negative n is interpreted as n=0.
+// Evaluating the Address returns ErrSplitRune
+// if byte n is within the encoding of a rune.
+func Byte(n int64) SimpleAddress {
+	if n < 0 {
+		return byteAddr(0)
+	}
+	return byteAddr(n)
+}
+
+func (a byteAddr) String() string                    { return "#b" + strconv.FormatInt(int64(a), 10) }
+func (a byteAddr) To(b AdditiveAddress) Address      { return to{left: a, right: b} }
+func (a byteAddr) Then(b AdditiveAddress) Address    { return then{left: a, right: b} }
+func (a byteAddr) Between(b AdditiveAddress) Address { return between{left: a, right: b} }
+
+func (a byteAddr) Plus(b SimpleAddress) AdditiveAddress  { return plus{left: a, right: b} }
+func (a byteAddr) reverse() SimpleAddress                { return byteAddr(-a) }
+func (a byteAddr) Minus(b SimpleAddress) AdditiveAddress { return minus{left: a, right: b} }
+func (a byteAddr) Where(text Text) (Span, error)         { return a.where(0, text) }
+
+func (a byteAddr) where(from int64, text Text) (Span, error) {
+	if bi, ok := getByteIndex(text); ok && 0 <= from && from <= text.Size() {
+		return byteAddrIndex(int64(a), from, text.Size(), bi)
+	}
+	delta := 1
+	s := Span{from, text.Size()}
+	if a < 0 {
+		a = -a
+		delta = -1
+		s[1] = 0
+	}
+	rr := text.RuneReader(s)
+	for a > 0 {
+		switch r, w, err := rr.ReadRune(); {
+		case err == io.EOF:
+			var err RangeError
+			if delta > 0 {
+				err = RangeError(text.Size())
+			}
+			return Span{}, err
+		case err != nil:
+			return Span{}, err
+		default:
+			from += int64(w * delta)
+			a -= byteAddr(runeLen(r))
+		}
+	}
+	if a < 0 {
+		return Span{}, ErrSplitRune
+	}
+	return Span{from, from}, nil
+}
+
+func byteAddrIndex(n, from, size int64, bi byteIndex) (Span, error) {
+	b, err := bi.utf8Bytes(from)
+	if err != nil {
+		return Span{}, err
+	}
+	total, err := bi.utf8Bytes(size)
+	if err != nil {
+		return Span{}, err
+	}
+	switch b += n; {
+	case b < 0:
+		return Span{}, RangeError(0)
+	case b > total:
+		return Span{}, RangeError(size)
+	}
+	at, err := runeOffset(b, size, bi)
+	if err != nil {
+		return Span{}, err
+	}
+	return Span{at, at}, nil
+}
+
+// A byteIndex is a Text that can find the UTF-8 encoding of runes
+// without reading the text.
+// Its unit of measurement is runes.
+type byteIndex interface {
+	// Utf8Bytes returns the number of bytes
+	// in the UTF-8 encoding of the text before an offset.
+	utf8Bytes(int64) (int64, error)
+	// Utf8Byte returns the offset of the rune
+	// whose UTF-8 encoding contains the nth byte,
+	// where the first byte is n=0.
+	utf8Byte(int64) (int64, error)
+}
+
+// GetByteIndex returns the byteIndex of the Text, if any.
+func getByteIndex(text Text) (byteIndex, bool) {
+	if wd, ok := text.(withDot); ok {
+		text = wd.Text
+	}
+	bi, ok := text.(byteIndex)
+	return bi, ok
+}
+
+// RuneOffset returns the offset of the rune
+// whose UTF-8 encoding begins at byte b,
+// or ErrSplitRune if b is within the encoding of a rune.
+func runeOffset(b, size int64, bi byteIndex) (int64, error) {
+	if total, err := bi.utf8Bytes(size); err != nil || b == total {
+		return size, err
+	}
+	at, err := bi.utf8Byte(b)
+	if err != nil {
+		return 0, err
+	}
+	if start, err := bi.utf8Bytes(at); err != nil || start != b {
+		if err == nil {
+			err = ErrSplitRune
+		}
+		return 0, err
+	}
+	return at, nil
+}
+
+// RuneLen returns the number of bytes in the UTF-8 encoding of r.
+// An invalid rune is encoded as utf8.RuneError.
+func runeLen(r rune) int {
+	if n := utf8.RuneLen(r); n > 0 {
+		return n
+	}
+	return utf8.RuneLen(utf8.RuneError)
+}
+
+// ByteSpan returns the Span of byte offsets
+// into the UTF-8 encoding of a Text
+// corresponding to a Span of the Text.
+// The unit of measurement of the Text must be runes.
+//
+// ErrInvalidArgument is returned if
+// either endpoint of the Span is negative or greater than the Size of the Text,
+// or if the Size of the Span is negative.
+func ByteSpan(text Text, s Span) (Span, error) {
+	if s[0] < 0 || s[0] > s[1] || s[1] > text.Size() {
+		return Span{}, ErrInvalidArgument
+	}
+	var b Span
+	if bi, ok := getByteIndex(text); ok {
+		var err error
+		for i := range s {
+			if b[i], err = bi.utf8Bytes(s[i]); err != nil {
+				return Span{}, err
+			}
+		}
+		return b, nil
+	}
+	var at, n int64
+	rr := text.RuneReader(Span{0, s[1]})
+	for i := 0; i < len(s); {
+		if at == s[i] {
+			b[i] = n
+			i++
+			continue
+		}
+		r, w, err := rr.ReadRune()
+		if err != nil {
+			return Span{}, err
+		}
+		at += int64(w)
+		n += int64(runeLen(r))
+	}
+	return b, nil
+}
+
+// RuneSpan returns the Span of a Text
+// corresponding to a Span of byte offsets
+// into the UTF-8 encoding of the Text.
+// It is the inverse of ByteSpan.
+// The unit of measurement of the Text must be runes.
+//
+// ErrInvalidArgument is returned if
+// either endpoint of the Span is negative
+// or greater than the size of the encoding,
+// or if the Size of the Span is negative.
+// ErrSplitRune is returned if either endpoint of the Span
+// is within the encoding of a rune.
+func RuneSpan(text Text, s Span) (Span, error) {
+	if s[0] < 0 || s[0] > s[1] {
+		return Span{}, ErrInvalidArgument
+	}
+	size := text.Size()
+	var rs Span
+	if bi, ok := getByteIndex(text); ok {
+		if total, err := bi.utf8Bytes(size); err != nil || s[1] > total {
+			if err == nil {
+				err = ErrInvalidArgument
+			}
+			return Span{}, err
+		}
+		var err error
+		for i := range s {
+			if rs[i], err = runeOffset(s[i], size, bi); err != nil {
+				return Span{}, err
+			}
+		}
+		return rs, nil
+	}
+	var b, at int64
+	var split bool
+	rr := text.RuneReader(Span{0, size})
+	for i := 0; i < len(s); {
+		if b >= s[i] {
+			split = split || b > s[i]
+			rs[i] = at
+			i++
+			continue
+		}
+		r, w, err := rr.ReadRune()
+		if err == io.EOF {
+			return Span{}, ErrInvalidArgument
+		}
+		if err != nil {
+			return Span{}, err
+		}
+		at += int64(w)
+		b += int64(runeLen(r))
+	}
+	if split {
+		return Span{}, ErrSplitRune
+	}
+	return rs, nil
+}
+
+// ErrUnknownCommand indicates an unknown edit command.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// ErrAddressNotAllowed indicates an address given to an edit command
+// that does not accept an address.
+var ErrAddressNotAllowed = errors.New("address not allowed")
+
+// A ParseError is an error that occurred while parsing an Address or an Edit.
+type ParseError struct {
+	// Offset is the offset, in runes from the beginning of the input,
+	// of the offending rune.
+	Offset int64 `json:"offset"`
+
+	// Rune is the offending rune.
+	// If the error occurred at the end of input, Rune is -1.
+	Rune rune `json:"rune"`
+
+	// Expected describes what was expected at Offset, if known.
+	Expected string `json:"expected,omitempty"`
+
+	// Err is the underlying error, if any.
+	Err error `json:"-"`
+}
+
+func (err *ParseError) Error() string {
+	r := "EOF"
+	if err.Rune >= 0 {
+		r = strconv.QuoteRune(err.Rune)
+	}
+	msg := "unexpected " + r
+	if err.Err != nil {
+		msg = err.Err.Error()
+	}
+	s := "rune " + strconv.FormatInt(err.Offset, 10) + " (" + r + "): " + msg
+	if err.Expected != "" {
+		s += ", expected " + err.Expected
+	}
+	return s
+}
+
+// A scanner is an io.RuneScanner
+// that tracks the rune offset into its input.
+type scanner struct {
+	io.RuneScanner
+	offs int64
+}
+
+// NewScanner returns rs if it is a *scanner,
+// otherwise it returns a new *scanner reading from rs.
+func newScanner(rs io.RuneScanner) *scanner {
+	if sc, ok := rs.(*scanner); ok {
+		return sc
+	}
+	return &scanner{RuneScanner: rs}
+}
+
+func (sc *scanner) ReadRune() (rune, int, error) {
+	r, w, err := sc.RuneScanner.ReadRune()
+	if err == nil {
+		sc.offs++
+	}
+	return r, w, err
+}
+
+func (sc *scanner) UnreadRune() error {
+	err := sc.RuneScanner.UnreadRune()
+	if err == nil {
+		sc.offs--
+	}
+	return err
+}
+
+// Offset returns the rune offset of the next rune read from rs.
+func offset(rs io.RuneScanner) int64 {
+	if sc, ok := rs.(*scanner); ok {
+		return sc.offs
+	}
+	return 0
+}
+
+// ParseErrorAt returns a *ParseError
+// for the rune at the given offset.
+func parseErrorAt(offs int64, r rune, expected string, err error) error {
+	return &ParseError{Offset: offs, Rune: r, Expected: expected, Err: err}
+}
+
+// PeekRune returns the next rune without consuming it.
+// At the end of input, it returns -1.
+func peekRune(rs io.RuneScanner) (rune, error) {
+	switch r, _, err := rs.ReadRune(); {
+	case err == io.EOF:
+		return -1, nil
+	case err != nil:
+		return 0, err
+	default:
+		return r, rs.UnreadRune()
+	}
+}
+
+// ParseRegexp parses a delimited regular expression,
+// returning a *ParseError if it fails to compile.
+// The delimiter must already be consumed.
+func parseRegexp(delim rune, rs io.RuneScanner) (string, error) {
+	offs := offset(rs)
+	r, err := peekRune(rs)
+	if err != nil {
+		return "", err
+	}
+	re, err := parseDelimited(delim, rs)
+	if err != nil {
+		return "", err
+	}
+	if _, err := regexpCompile(re); err != nil {
+		return "", parseErrorAt(offs, r, "a valid regular expression", err)
+	}
+	return re, nil
+}
+
 const (
 	digits      = "0123456789"
-	simpleFirst = "!#/$.'" + digits
+	simpleFirst = "!#/$.'%" + digits
 )
 
 // Addr parses and returns an address.
@@ -597,7 +1310,7 @@ const (
 // The address syntax for address a is:
 // 	a: {a} , {aa} | {a} ; {aa} | {aa}
 // 	aa: {aa} + {sa} | {aa} - {sa} | {aa} {sa} | {!} {sa}
-// 	sa: $ | . | 'r | #{n} | n | / regexp {/}
+// 	sa: $ | . | 'r | #{n} | #b{n} | n | / regexp {/} | %
 // 	n: [0-9]+
 // 	r: any non-space rune
 // 	regexp: any valid re1 regular expression
@@ -608,11 +1321,17 @@ const (
 //	. is the current address of the editor, called dot.
 //	'{r} is the address of the non-space rune, r. If r is missing, . is used.
 //	#{n} is the empty string after rune number n. If n is missing then 1 is used.
+//	#b{n} is the empty string after byte number n of the UTF-8 encoding of the text.
+//		If n is missing then 1 is used.
+//		It is an error if byte n is within the encoding of a rune.
 //	n is the nth line in the buffer. 0 is the string before the first full line.
 //	'/' regexp {'/'} is the first match of the regular expression.
 // 		The regexp uses the syntax of the standard library regexp package,
 // 		except that \, raw newlines, and / must be escaped with \.
 // 		The regexp is wrapped in (?m:<regexp>), making it multi-line by default.
+//	% is the string from a bracket or quote to its matching partner.
+// 		The brackets are (), [], and {}; the quotes are ", ', and `.
+// 		Brackets of the same kind nest.
 //
 // Simple addresses may be prefixed with !.
 // Such an address is clamped
@@ -662,7 +1381,10 @@ const (
 //
 // 	1,5dabc
 // 		Is terminated at 5, the end of the address.
+//
+// Errors in the syntax of the address are reported as a *ParseError.
 func Addr(rs io.RuneScanner) (Address, error) {
+	rs = newScanner(rs)
 	aa, err := parseAdditiveAddress(rs)
 	if err != nil {
 		return nil, err
@@ -786,18 +1508,17 @@ func parseSimpleAddress(rs io.RuneScanner) (SimpleAddress, error) {
 	case strings.ContainsRune(digits, r):
 		return parseLineAddr(r, rs)
 	case r == '/':
-		re, err := parseDelimited(r, rs)
+		re, err := parseRegexp(r, rs)
 		if err != nil {
 			return nil, err
 		}
-		if _, err := regexpCompile(re); err != nil {
-			return nil, err
-		}
 		return Regexp(re), nil
 	case r == '$':
 		return End, nil
 	case r == '.':
 		return Dot, nil
+	case r == '%':
+		return Balanced(), nil
 	case r == '!':
 		a, err := parseSimpleAddress(rs)
 		if err != nil {
@@ -826,6 +1547,18 @@ func parseMarkAddr(rs io.RuneScanner) (SimpleAddress, error) {
 }
 
 func parseRuneAddr(rs io.RuneScanner) (SimpleAddress, error) {
+	var byteUnits bool
+	switch r, _, err := rs.ReadRune(); {
+	case err == nil && r == 'b':
+		byteUnits = true
+	case err == nil:
+		if err := rs.UnreadRune(); err != nil {
+			return nil, err
+		}
+	case err != io.EOF:
+		return nil, err
+	}
+	offs := offset(rs)
 	s, err := scanDigits(rs)
 	if err != nil {
 		return nil, err
@@ -835,16 +1568,26 @@ func parseRuneAddr(rs io.RuneScanner) (SimpleAddress, error) {
 	}
 	const base, bits = 10, 64
 	r, err := strconv.ParseInt(s, base, bits)
-	return Rune(r), err
+	if err != nil {
+		return nil, parseErrorAt(offs, []rune(s)[0], "a number", err)
+	}
+	if byteUnits {
+		return Byte(r), nil
+	}
+	return Rune(r), nil
 }
 
 func parseLineAddr(r rune, rs io.RuneScanner) (SimpleAddress, error) {
+	offs := offset(rs) - 1
 	s, err := scanDigits(rs)
 	if err != nil {
 		return nil, err
 	}
 	l, err := strconv.Atoi(string(r) + s)
-	return Line(l), err
+	if err != nil {
+		return nil, parseErrorAt(offs, r, "a number", err)
+	}
+	return Line(l), nil
 }
 
 func scanDigits(rs io.RuneScanner) (string, error) {
@@ -113,3 +113,61 @@ func BenchmarkRegexpHardx32(b *testing.B)    { benchmarkRegexp(b, hard, 32<<0) }
 func BenchmarkRegexpHardx1K(b *testing.B)    { benchmarkRegexp(b, hard, 1<<10) }
 func BenchmarkRegexpHardx1M(b *testing.B)    { benchmarkRegexp(b, hard, 1<<20) }
 func BenchmarkRegexpHardx32M(b *testing.B)   { benchmarkRegexp(b, hard, 32<<20) }
+
+func benchmarkRegexpReverse(b *testing.B, re string, n int) {
+	buf, _, _ := makeEditor(n)
+	defer buf.Close()
+	b.ResetTimer()
+	b.SetBytes(int64(n))
+	for i := 0; i < b.N; i++ {
+		switch _, err := End.Minus(Regexp(re)).Where(buf); {
+		case err == nil:
+			panic("unexpected match")
+		case err != ErrNoMatch:
+			panic(err)
+		}
+	}
+}
+
+const (
+	reverseEasy0 = "^ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	reverseHard  = "^ABCDEFGHIJKLMNOPQRSTUVWXYZ[ -~]*"
+)
+
+func BenchmarkRegexpReverseEasy0x32(b *testing.B)  { benchmarkRegexpReverse(b, reverseEasy0, 32<<0) }
+func BenchmarkRegexpReverseEasy0x1K(b *testing.B)  { benchmarkRegexpReverse(b, reverseEasy0, 1<<10) }
+func BenchmarkRegexpReverseEasy0x1M(b *testing.B)  { benchmarkRegexpReverse(b, reverseEasy0, 1<<20) }
+func BenchmarkRegexpReverseEasy0x32M(b *testing.B) { benchmarkRegexpReverse(b, reverseEasy0, 32<<20) }
+func BenchmarkRegexpReverseHardx32(b *testing.B)   { benchmarkRegexpReverse(b, reverseHard, 32<<0) }
+func BenchmarkRegexpReverseHardx1K(b *testing.B)   { benchmarkRegexpReverse(b, reverseHard, 1<<10) }
+func BenchmarkRegexpReverseHardx1M(b *testing.B)   { benchmarkRegexpReverse(b, reverseHard, 1<<20) }
+func BenchmarkRegexpReverseHardx32M(b *testing.B)  { benchmarkRegexpReverse(b, reverseHard, 32<<20) }
+
+// benchmarkRegexpPrev benchmarks finding the previous match
+// from the end of a text with many matches.
+// The cost should depend on the distance to the previous match,
+// not on the size of the text.
+func benchmarkRegexpPrev(b *testing.B, re string, n int) {
+	buf, _, _ := makeEditor(n)
+	defer buf.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := End.Minus(Regexp(re)).Where(buf); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+const (
+	prevLine = "\n"
+	prevWord = `\b[a-z]+\b`
+)
+
+func BenchmarkRegexpPrevLinex32(b *testing.B)  { benchmarkRegexpPrev(b, prevLine, 32<<0) }
+func BenchmarkRegexpPrevLinex1K(b *testing.B)  { benchmarkRegexpPrev(b, prevLine, 1<<10) }
+func BenchmarkRegexpPrevLinex1M(b *testing.B)  { benchmarkRegexpPrev(b, prevLine, 1<<20) }
+func BenchmarkRegexpPrevLinex32M(b *testing.B) { benchmarkRegexpPrev(b, prevLine, 32<<20) }
+func BenchmarkRegexpPrevWordx32(b *testing.B)  { benchmarkRegexpPrev(b, prevWord, 32<<0) }
+func BenchmarkRegexpPrevWordx1K(b *testing.B)  { benchmarkRegexpPrev(b, prevWord, 1<<10) }
+func BenchmarkRegexpPrevWordx1M(b *testing.B)  { benchmarkRegexpPrev(b, prevWord, 1<<20) }
+func BenchmarkRegexpPrevWordx32M(b *testing.B) { benchmarkRegexpPrev(b, prevWord, 32<<20) }
@@ -41,6 +41,11 @@ func TestAddr(t *testing.T) {
 		{a: " #1\t\n\txyz", left: "\n\txyz", want: Rune(1)},
 		{a: "#" + strconv.FormatInt(math.MaxInt64, 10) + "0", err: "out of range"},
 
+		{a: "#b0", want: Byte(0)},
+		{a: "#b", want: Byte(1)},
+		{a: "#b12345xyz", left: "xyz", want: Byte(12345)},
+		{a: "#b" + strconv.FormatInt(math.MaxInt64, 10) + "0", err: "out of range"},
+
 		{a: "0", want: Line(0)},
 		{a: "1", want: Line(1)},
 		{a: "12345", want: Line(12345)},
@@ -56,6 +61,11 @@ func TestAddr(t *testing.T) {
 		{a: "/abc def", want: Regexp("abc def")},
 		{a: "/abc def\nxyz", left: "\nxyz", want: Regexp("abc def")},
 
+		{a: "%", want: Balanced()},
+		{a: " %xyz", left: "xyz", want: Balanced()},
+		{a: "/abc/+%", want: Regexp("abc").Plus(Balanced())},
+		{a: "$-%", want: End.Minus(Balanced())},
+
 		{a: "$", want: End},
 		{a: " $", want: End},
 		{a: " $\t", want: End},
@@ -217,6 +227,9 @@ func TestAddressString(t *testing.T) {
 		{addr: Rune(0)},
 		{addr: Rune(100)},
 		{addr: Rune(-100), want: Rune(0)},
+		{addr: Byte(0)},
+		{addr: Byte(100)},
+		{addr: Byte(-100), want: Byte(0)},
 		{addr: Line(0)},
 		{addr: Line(100)},
 		{addr: Line(-100), want: Line(0)},
@@ -224,6 +237,8 @@ func TestAddressString(t *testing.T) {
 		{addr: Mark('z')},
 		{addr: Mark(' ')},
 		{addr: Regexp("☺☹")},
+		{addr: Balanced()},
+		{addr: Regexp("func").Plus(Balanced())},
 		{addr: Dot.Plus(Line(1))},
 		{addr: Dot.Minus(Line(1))},
 		{addr: Dot.Minus(Line(1)).Plus(Line(1))},
@@ -244,6 +259,183 @@ func TestAddressString(t *testing.T) {
 	}
 }
 
+func TestAddrParseError(t *testing.T) {
+	tests := []struct {
+		str, error string
+		want       ParseError
+	}{
+		{str: "#99999999999999999999", error: "out of range", want: ParseError{Offset: 1, Rune: '9', Expected: "a number"}},
+		{str: "1,99999999999999999999", error: "out of range", want: ParseError{Offset: 2, Rune: '9', Expected: "a number"}},
+		{str: "1,/(/", error: "missing closing", want: ParseError{Offset: 3, Rune: '(', Expected: "a valid regular expression"}},
+		{str: "/abc/+/[", error: "missing closing", want: ParseError{Offset: 7, Rune: '[', Expected: "a valid regular expression"}},
+	}
+	for _, test := range tests {
+		_, err := Addr(strings.NewReader(test.str))
+		perr, ok := err.(*ParseError)
+		if !ok || !matchesError(test.error, err) {
+			t.Errorf("Addr(%q)=_,%v, want _,*ParseError matching %q", test.str, err, test.error)
+			continue
+		}
+		test.want.Err = perr.Err
+		if *perr != test.want {
+			t.Errorf("Addr(%q)=_,%#v, want _,%#v", test.str, *perr, test.want)
+		}
+	}
+}
+
+// noLineIndex hides the lineIndex of an Editor.
+type noLineIndex struct{ Editor }
+
+// TestLineIndex tests that line addresses and line numbers
+// are the same with and without a lineIndex.
+func TestLineIndex(t *testing.T) {
+	tests := []string{
+		"",
+		"\n",
+		"\n\n",
+		"a",
+		"a\n",
+		"abc\ndef",
+		"abc\ndef\n",
+		"\nabc\n\ndef\n\n",
+		"☺\n世界\n\nxyz",
+	}
+	for _, test := range tests {
+		buf := NewBuffer()
+		defer buf.Close()
+		if err := Change(All, test).Do(buf, ioutil.Discard); err != nil {
+			t.Fatalf("Change(All, %q).Do(…)=%v", test, err)
+		}
+		if _, ok := getLineIndex(buf); !ok {
+			t.Fatalf("getLineIndex(buf)=_,false, want true")
+		}
+		size := buf.Size()
+		for from := int64(0); from <= size; from++ {
+			for n := 0; n < 5; n++ {
+				a := line{n: n}
+				for _, a := range []line{a, a.reverse().(line)} {
+					want, wantErr := a.where(from, noLineIndex{buf})
+					got, err := a.where(from, buf)
+					if got != want || !reflect.DeepEqual(err, wantErr) {
+						t.Errorf("%q: line{n: %d, rev: %v}.where(%d)=%v,%v, want %v,%v",
+							test, n, a.rev, from, got, err, want, wantErr)
+					}
+				}
+			}
+			for to := from; to <= size; to++ {
+				s := Span{from, to}
+				w0, w1, wantErr := lines(noLineIndex{buf}, s)
+				l0, l1, err := lines(buf, s)
+				if l0 != w0 || l1 != w1 || !reflect.DeepEqual(err, wantErr) {
+					t.Errorf("%q: lines(%v)=%d,%d,%v, want %d,%d,%v", test, s, l0, l1, err, w0, w1, wantErr)
+				}
+			}
+		}
+	}
+}
+
+// noByteIndex hides the byteIndex of an Editor.
+type noByteIndex struct{ Editor }
+
+// TestByteIndex tests that byte addresses and byte spans
+// are the same with and without a byteIndex.
+func TestByteIndex(t *testing.T) {
+	tests := []string{
+		"",
+		"a",
+		"abc",
+		"α",
+		"☺\n世界\n\nxyz",
+		"😀a😀",
+	}
+	for _, test := range tests {
+		buf := NewBuffer()
+		defer buf.Close()
+		if err := Change(All, test).Do(buf, ioutil.Discard); err != nil {
+			t.Fatalf("Change(All, %q).Do(…)=%v", test, err)
+		}
+		if _, ok := getByteIndex(buf); !ok {
+			t.Fatalf("getByteIndex(buf)=_,false, want true")
+		}
+		size := buf.Size()
+		for from := int64(0); from <= size; from++ {
+			for n := byteAddr(0); n < 10; n++ {
+				for _, a := range []byteAddr{n, -n} {
+					want, wantErr := a.where(from, noByteIndex{buf})
+					got, err := a.where(from, buf)
+					if got != want || !reflect.DeepEqual(err, wantErr) {
+						t.Errorf("%q: byteAddr(%d).where(%d)=%v,%v, want %v,%v",
+							test, a, from, got, err, want, wantErr)
+					}
+				}
+			}
+			for to := from; to <= size; to++ {
+				s := Span{from, to}
+				want, wantErr := ByteSpan(noByteIndex{buf}, s)
+				got, err := ByteSpan(buf, s)
+				if got != want || err != wantErr {
+					t.Errorf("%q: ByteSpan(%v)=%v,%v, want %v,%v", test, s, got, err, want, wantErr)
+				}
+				if rs, err := RuneSpan(buf, got); rs != s || err != nil {
+					t.Errorf("%q: RuneSpan(%v)=%v,%v, want %v,nil", test, got, rs, err, s)
+				}
+			}
+		}
+		nbytes := int64(len(test))
+		for from := int64(-1); from <= nbytes+1; from++ {
+			for to := from - 1; to <= nbytes+1; to++ {
+				s := Span{from, to}
+				want, wantErr := RuneSpan(noByteIndex{buf}, s)
+				got, err := RuneSpan(buf, s)
+				if got != want || err != wantErr {
+					t.Errorf("%q: RuneSpan(%v)=%v,%v, want %v,%v", test, s, got, err, want, wantErr)
+				}
+			}
+		}
+	}
+}
+
+func TestByteSpan(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	if err := Change(All, "aα世😀").Do(buf, ioutil.Discard); err != nil {
+		t.Fatalf("Change(All, …).Do(…)=%v", err)
+	}
+	for _, text := range []Text{buf, noByteIndex{buf}} {
+		tests := []struct {
+			runes, bytes Span
+			error        error
+		}{
+			{runes: Span{0, 0}, bytes: Span{0, 0}},
+			{runes: Span{0, 4}, bytes: Span{0, 10}},
+			{runes: Span{1, 3}, bytes: Span{1, 6}},
+			{runes: Span{3, 4}, bytes: Span{6, 10}},
+			{runes: Span{4, 4}, bytes: Span{10, 10}},
+			{runes: Span{-1, 0}, error: ErrInvalidArgument},
+			{runes: Span{0, 5}, error: ErrInvalidArgument},
+			{runes: Span{2, 1}, error: ErrInvalidArgument},
+		}
+		for _, test := range tests {
+			if s, err := ByteSpan(text, test.runes); s != test.bytes || err != test.error {
+				t.Errorf("ByteSpan(%T, %v)=%v,%v, want %v,%v", text, test.runes, s, err, test.bytes, test.error)
+			}
+		}
+		for _, test := range []struct {
+			bytes Span
+			error error
+		}{
+			{bytes: Span{2, 3}, error: ErrSplitRune},
+			{bytes: Span{1, 7}, error: ErrSplitRune},
+			{bytes: Span{0, 11}, error: ErrInvalidArgument},
+			{bytes: Span{3, 1}, error: ErrInvalidArgument},
+		} {
+			if s, err := RuneSpan(text, test.bytes); err != test.error {
+				t.Errorf("RuneSpan(%T, %v)=%v,%v, want _,%v", text, test.bytes, s, err, test.error)
+			}
+		}
+	}
+}
+
 type errReaderAt struct{ error }
 
 func (e *errReaderAt) ReadAt([]byte, int64) (int, error)      { return 0, e.error }
@@ -292,7 +484,7 @@ func TestIOErrors(t *testing.T) {
 		}
 		f := &errReaderAt{nil}
 		r := runes.NewBufferReaderWriterAt(1, f)
-		buf := newBuffer(r)
+		buf := newBuffer(r, runes.FileStorage)
 		defer buf.Close()
 
 		if _, err := buf.Change(Span{}, strings.NewReader(helloWorld)); err != nil {
@@ -560,6 +752,77 @@ func TestAddressRuneFromString(t *testing.T) {
 	}
 }
 
+var byteTests = []editTest{
+	{
+		name:  "out of range",
+		given: "{..}",
+		do:    address(Byte(1)),
+		error: "out of range",
+	},
+	{
+		name:  "empty buffer",
+		given: "{..}",
+		do:    address(Byte(0)),
+		want:  "{..}{aa}",
+	},
+	{
+		name:  "multi-byte runes",
+		given: "{..}α世b",
+		do:    address(Byte(5)),
+		want:  "{..}α世{aa}b",
+	},
+	{
+		name:  "end",
+		given: "{..}α世b",
+		do:    address(Byte(6)),
+		want:  "{..}α世b{aa}",
+	},
+	{
+		name:  "split rune",
+		given: "{..}α世b",
+		do:    address(Byte(3)),
+		want:  "{..}α世b",
+		error: "splits a rune",
+	},
+	{
+		name:  "plus",
+		given: "{..}α世b",
+		do:    address(Rune(1).Plus(Byte(3))),
+		want:  "{..}α世{aa}b",
+	},
+	{
+		name:  "minus",
+		given: "{..}α世b",
+		do:    address(End.Minus(Byte(4))),
+		want:  "{..}α{aa}世b",
+	},
+	{
+		name:  "minus out of range",
+		given: "{..}α世b",
+		do:    address(Rune(1).Minus(Byte(3))),
+		want:  "{..}α世b",
+		error: "out of range",
+	},
+	{
+		name:  "to",
+		given: "{..}α世b",
+		do:    address(Byte(2).To(Byte(5))),
+		want:  "{..}α{a}世{a}b",
+	},
+}
+
+func TestAddressByte(t *testing.T) {
+	for _, test := range byteTests {
+		test.run(t)
+	}
+}
+
+func TestAddressByteFromString(t *testing.T) {
+	for _, test := range byteTests {
+		test.runFromString(t)
+	}
+}
+
 var lineTests = []editTest{
 	{
 		name:  "out of range",
@@ -970,6 +1233,60 @@ var regexpTests = []editTest{
 		do:    address(Regexp(`abc\`)),
 		want:  `{..a}abc\{a}`,
 	},
+	{
+		name:  "reverse case-insensitive",
+		given: "ABC abc{..}",
+		do:    address(Dot.Minus(Regexp("(?i)Abc"))),
+		want:  "ABC {a}abc{..a}",
+	},
+	{
+		name:  "reverse word boundary",
+		given: "foo foobar barfoo{..}",
+		do:    address(Dot.Minus(Regexp(`\bfoo\b`))),
+		want:  "{a}foo{a} foobar barfoo{..}",
+	},
+	{
+		name:  "reverse repetition and groups",
+		given: "xabababy{..}",
+		do:    address(Dot.Minus(Regexp("x(ab)+"))),
+		want:  "{a}xababab{a}y{..}",
+	},
+	{
+		name:  "reverse character class",
+		given: "a1b22c{..}",
+		do:    address(Dot.Minus(Regexp("[0-9]+"))),
+		want:  "a1b{a}22{a}c{..}",
+	},
+	{
+		name:  "reverse non-ASCII",
+		given: "☺世界☹世界{..}",
+		do:    address(Dot.Minus(Regexp("☺世"))),
+		want:  "{a}☺世{a}界☹世界{..}",
+	},
+	{
+		name:  "reverse far from start",
+		given: "abc" + strings.Repeat("\n", 10000) + "{..}",
+		do:    address(Dot.Minus(Regexp("b"))),
+		want:  "a{a}b{a}c" + strings.Repeat("\n", 10000) + "{..}",
+	},
+	{
+		name:  "reverse lazy quantifier",
+		given: "aab{..}",
+		do:    address(Dot.Minus(Regexp("a*?b"))),
+		want:  "{a}aab{..a}",
+	},
+	{
+		name:  "reverse alternation",
+		given: "ab{..}",
+		do:    address(Dot.Minus(Regexp("a|ab"))),
+		want:  "{a}a{a}b{..}",
+	},
+	{
+		name:  "reverse alternation and optional group",
+		given: "xab{..}",
+		do:    address(Dot.Minus(Regexp("(a|ab)(c|bcd)?"))),
+		want:  "x{a}a{a}b{..}",
+	},
 	{
 		name:  "non-capturing group",
 		given: "{..}abc",
@@ -990,12 +1307,12 @@ var regexpTests = []editTest{
 		do:    address(Dot.Plus(Regexp(`$`))),
 		want:  "a{..}bc{aa}\nxyz",
 	},
-	//	{
-	//		name:  "previous ^",
-	//		given: "abc\nxy{..}z",
-	//		do:    address(Dot.Minus(Regexp(`^`))),
-	//		want:  "abc\n{aa}xy{..}z",
-	//	},
+	{
+		name:  "previous ^",
+		given: "abc\nxy{..}z",
+		do:    address(Dot.Minus(Regexp(`^`))),
+		want:  "abc\n{aa}xy{..}z",
+	},
 	//	{
 	//		name:  "previous $",
 	//		given: "abc\nxy{..}z",
@@ -1035,6 +1352,203 @@ func TestRegexpString(t *testing.T) {
 	}
 }
 
+var balancedTests = []editTest{
+	{
+		name:  "empty",
+		given: "{..}",
+		do:    address(Balanced()),
+		want:  "{..}",
+		error: "no match",
+	},
+	{
+		name:  "no delimiter",
+		given: "{..}abc",
+		do:    address(Balanced()),
+		want:  "{..}abc",
+		error: "no match",
+	},
+	{
+		name:  "no partner",
+		given: "{..}(abc",
+		do:    address(Balanced()),
+		want:  "{..}(abc",
+		error: "no match",
+	},
+	{
+		name:  "parens at dot",
+		given: "{..}(abc)",
+		do:    address(Balanced()),
+		want:  "{..a}(abc){a}",
+	},
+	{
+		name:  "empty parens",
+		given: "x{..}()y",
+		do:    address(Balanced()),
+		want:  "x{..a}(){a}y",
+	},
+	{
+		name:  "search forward from dot",
+		given: "{..}f(x) + g(y)",
+		do:    address(Balanced()),
+		want:  "{..}f{a}(x){a} + g(y)",
+	},
+	{
+		name:  "nested",
+		given: "{..}(a(b)(c(d))e)f",
+		do:    address(Balanced()),
+		want:  "{..a}(a(b)(c(d))e){a}f",
+	},
+	{
+		name:  "other brackets ignored",
+		given: "{..}[a(b]c)",
+		do:    address(Balanced()),
+		want:  "{..a}[a(b]{a}c)",
+	},
+	{
+		name:  "multi-line",
+		given: "{..}[\n\t1,\n\t[2],\n]\n",
+		do:    address(Balanced()),
+		want:  "{..a}[\n\t1,\n\t[2],\n]{a}\n",
+	},
+	{
+		name:  "closing bracket at dot",
+		given: "(a(b)c{..})d",
+		do:    address(Balanced()),
+		want:  "{a}(a(b)c{..}){a}d",
+	},
+	{
+		name:  "closing bracket at dot with nesting",
+		given: "x(a(b)c{.}){.}d",
+		do:    address(Balanced()),
+		want:  "x{a}(a(b)c{.}){.a}d",
+	},
+	{
+		name:  "double quotes",
+		given: `{..}"a\"b"c`,
+		do:    address(Balanced()),
+		want:  `{..a}"a\"b"{a}c`,
+	},
+	{
+		name:  "double quotes escaped backslash",
+		given: `{..}"a\\"b"`,
+		do:    address(Balanced()),
+		want:  `{..a}"a\\"{a}b"`,
+	},
+	{
+		name:  "single quotes",
+		given: `{..}'\''x`,
+		do:    address(Balanced()),
+		want:  `{..a}'\''{a}x`,
+	},
+	{
+		name:  "back quotes no escapes",
+		given: "{..}`a\\`b`",
+		do:    address(Balanced()),
+		want:  "{..a}`a\\`{a}b`",
+	},
+	{
+		name:  "Unicode",
+		given: "{..}☺(αβ[ξ])世界",
+		do:    address(Balanced()),
+		want:  "{..}☺{a}(αβ[ξ]){a}世界",
+	},
+	{
+		name:  "plus",
+		given: "{..}f(x) g(y)",
+		do:    address(Regexp("g").Plus(Balanced())),
+		want:  "{..}f(x) g{a}(y){a}",
+	},
+	{
+		name:  "plus from inside",
+		given: "{..}f(a, (b))",
+		do:    address(Regexp("a").Plus(Balanced())),
+		want:  "{..}f(a, {a}(b){a})",
+	},
+	{
+		name:  "minus",
+		given: "f(x) g(y){..}",
+		do:    address(Dot.Minus(Balanced())),
+		want:  "f(x) g{a}(y){..a}",
+	},
+	{
+		name:  "minus nested",
+		given: "[a[b]c[d]]{..}",
+		do:    address(Dot.Minus(Balanced())),
+		want:  "{a}[a[b]c[d]]{..a}",
+	},
+	{
+		name:  "minus search back",
+		given: "f(x) g(y){..}z",
+		do:    address(Regexp("z").Minus(Balanced())),
+		want:  "f(x) g{a}(y){..a}z",
+	},
+	{
+		name:  "minus quotes",
+		given: `x "a\"b" y{..}`,
+		do:    address(Dot.Minus(Balanced())),
+		want:  `x {a}"a\"b"{a} y{..}`,
+	},
+	{
+		name:  "minus quotes escaped backslash",
+		given: `"a\\"{..}`,
+		do:    address(Dot.Minus(Balanced())),
+		want:  `{a}"a\\"{..a}`,
+	},
+	{
+		name:  "minus no partner",
+		given: "a)b{..}",
+		do:    address(Dot.Minus(Balanced())),
+		want:  "a)b{..}",
+		error: "no match",
+	},
+	{
+		name:  "minus no delimiter",
+		given: "abc{..}",
+		do:    address(Dot.Minus(Balanced())),
+		want:  "abc{..}",
+		error: "no match",
+	},
+}
+
+func TestAddressBalanced(t *testing.T) {
+	for _, test := range balancedTests {
+		test.run(t)
+	}
+}
+
+func TestAddressBalancedFromString(t *testing.T) {
+	for _, test := range balancedTests {
+		test.runFromString(t)
+	}
+}
+
+// Tests braces, which cannot appear in editTest state descriptions.
+func TestAddressBalancedBraces(t *testing.T) {
+	tests := []struct {
+		text string
+		addr Address
+		want Span
+	}{
+		{text: "func f() { if x { y() } }", addr: Balanced(), want: Span{6, 8}},
+		{text: "func f() { if x { y() } }", addr: Regexp(`\)`).Plus(Balanced()), want: Span{9, 25}},
+		{text: "func f() { if x { y() } }", addr: End.Minus(Balanced()), want: Span{9, 25}},
+		{text: "{ '}' }", addr: Balanced(), want: Span{0, 4}}, // Quotes within brackets are not special.
+	}
+	for _, test := range tests {
+		buf := NewBuffer()
+		defer buf.Close()
+		if err := Change(All, test.text).Do(buf, ioutil.Discard); err != nil {
+			t.Fatalf("Change(All, %q).Do(…)=%v", test.text, err)
+		}
+		if err := buf.SetMark('.', Span{}); err != nil {
+			t.Fatalf("SetMark('.', Span{})=%v", err)
+		}
+		if got, err := test.addr.Where(buf); err != nil || got != test.want {
+			t.Errorf("%q: %q.Where(…)=%v,%v, want %v,nil", test.text, test.addr, got, err, test.want)
+		}
+	}
+}
+
 var plusTests = []editTest{
 	{
 		name:  "out of range",
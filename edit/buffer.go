@@ -5,6 +5,7 @@ package edit
 import (
 	"bufio"
 	"io"
+	"time"
 
 	"github.com/eaburns/T/edit/runes"
 )
@@ -12,22 +13,81 @@ import (
 // A Buffer implements the Editor interface,
 // editing an unbounded sequence of runes.
 type Buffer struct {
-	runes               *runes.Buffer
-	pending, undo, redo *log
-	seq                 int32
-	marks               map[rune]Span
+	runes            *runes.Buffer
+	pending, history *log
+	seq              int32
+	marks            map[rune]Span
+	fs               FileSystem
+	fileName         string
+	encoding         Encoding
+	lineEnding       LineEnding
+	// Storage creates the backing stores
+	// of the text and the logs.
+	storage runes.Storage
+
+	// States are the states of the undo tree, ordered by ID,
+	// state is the current state,
+	// and nextID is the ID of the next new state.
+	states []*state
+	state  *state
+	nextID int
+
+	// UndoLimits are the limits of the undo tree,
+	// and undoRunes is the number of runes in the history log
+	// used by the entries of the states of the tree.
+	undoLimits UndoLimits
+	undoRunes  int64
+
+	// Checkpoints maps checkpoint names to their states.
+	checkpoints map[string]*state
+
+	// Seqs records the current state after each sequence number
+	// at which the current state changed, in increasing order.
+	seqs []seqState
+
+	// Own are the IDs of the states created by Apply,
+	// to be reverted by SelectiveUndo,
+	// and reverting is whether SelectiveUndo is applying a revert,
+	// which is not added to own.
+	own       []int
+	reverting bool
 }
 
+// MemoryThreshold is the size in bytes
+// beyond which the backing stores of a Buffer returned by NewBuffer
+// move from memory to temporary files.
+const memoryThreshold = 1 << 20
+
 // NewBuffer returns a new, empty Buffer.
-func NewBuffer() *Buffer { return newBuffer(runes.NewBuffer(1 << 12)) }
+// The text and the undo log of the Buffer are kept in memory
+// until they grow large, and are then moved to temporary files.
+func NewBuffer() *Buffer {
+	return NewBufferStorage(runes.AutoStorage(memoryThreshold, runes.FileStorage))
+}
 
-func newBuffer(rs *runes.Buffer) *Buffer {
+// NewBufferStorage returns a new, empty Buffer
+// with its text and undo log stored in backing stores
+// created by the given runes.Storage,
+// such as runes.MemoryStorage for a small Buffer,
+// or runes.MmapStorage for a very large Buffer.
+func NewBufferStorage(s runes.Storage) *Buffer {
+	return newBuffer(runes.NewBufferStorage(1<<12, s), s)
+}
+
+func newBuffer(rs *runes.Buffer, s runes.Storage) *Buffer {
+	root := &state{time: time.Now()}
 	return &Buffer{
 		runes:   rs,
-		undo:    newLog(),
-		redo:    newLog(),
-		pending: newLog(),
+		storage: s,
+		history: newLog(s),
+		pending: newLog(s),
 		marks:   make(map[rune]Span),
+		states:  []*state{root},
+		state:   root,
+		nextID:  1,
+
+		checkpoints: make(map[string]*state),
+		seqs:        []seqState{{state: root}},
 	}
 }
 
@@ -36,8 +96,7 @@ func (buf *Buffer) Close() error {
 	errs := []error{
 		buf.runes.Close(),
 		buf.pending.close(),
-		buf.undo.close(),
-		buf.redo.close(),
+		buf.history.close(),
 	}
 	for _, e := range errs {
 		if e != nil {
@@ -47,6 +106,38 @@ func (buf *Buffer) Close() error {
 	return nil
 }
 
+// FileSystem implements the FileSystem method of the Editor interface.
+//
+// It returns the FileSystem set by SetFileSystem,
+// or OSFileSystem if none was set.
+func (buf *Buffer) FileSystem() FileSystem {
+	if buf.fs == nil {
+		return OSFileSystem
+	}
+	return buf.fs
+}
+
+// SetFileSystem sets the FileSystem used to read and write files.
+func (buf *Buffer) SetFileSystem(fs FileSystem) { buf.fs = fs }
+
+// FileName implements the FileName method of the Editor interface.
+func (buf *Buffer) FileName() string { return buf.fileName }
+
+// SetFileName implements the SetFileName method of the Editor interface.
+func (buf *Buffer) SetFileName(name string) { buf.fileName = name }
+
+// Encoding implements the Encoding method of the Editor interface.
+func (buf *Buffer) Encoding() Encoding { return buf.encoding }
+
+// SetEncoding implements the SetEncoding method of the Editor interface.
+func (buf *Buffer) SetEncoding(e Encoding) { buf.encoding = e }
+
+// LineEnding implements the LineEnding method of the Editor interface.
+func (buf *Buffer) LineEnding() LineEnding { return buf.lineEnding }
+
+// SetLineEnding implements the SetLineEnding method of the Editor interface.
+func (buf *Buffer) SetLineEnding(l LineEnding) { buf.lineEnding = l }
+
 // Change changes the string identified by at
 // to contain the runes from the Reader.
 //
@@ -70,6 +161,18 @@ func (buf *Buffer) change(s Span, src runes.Reader) error {
 // It returns the number of Runes in the Buffer.
 func (buf *Buffer) Size() int64 { return buf.runes.Size() }
 
+// Newlines returns the number of newlines before an offset.
+func (buf *Buffer) newlines(at int64) (int64, error) { return buf.runes.Newlines(at) }
+
+// Newline returns the offset of the nth newline.
+func (buf *Buffer) newline(n int64) (int64, error) { return buf.runes.Newline(n) }
+
+// Utf8Bytes returns the number of UTF-8 bytes before an offset.
+func (buf *Buffer) utf8Bytes(at int64) (int64, error) { return buf.runes.Bytes(at) }
+
+// Utf8Byte returns the offset of the rune containing the nth UTF-8 byte.
+func (buf *Buffer) utf8Byte(n int64) (int64, error) { return buf.runes.Byte(n) }
+
 func (buf *Buffer) Mark(m rune) Span { return buf.marks[m] }
 
 func (buf *Buffer) SetMark(m rune, s Span) error {
@@ -81,8 +184,10 @@ func (buf *Buffer) SetMark(m rune, s Span) error {
 }
 
 type runeReader struct {
-	span   Span
-	buffer *Buffer
+	span  Span
+	runes interface {
+		Rune(int64) (rune, error)
+	}
 }
 
 func (rr *runeReader) ReadRune() (r rune, w int, err error) {
@@ -91,9 +196,9 @@ func (rr *runeReader) ReadRune() (r rune, w int, err error) {
 		return 0, 0, io.EOF
 	case size < 0:
 		rr.span[0]--
-		r, err = rr.buffer.runes.Rune(rr.span[0])
+		r, err = rr.runes.Rune(rr.span[0])
 	default:
-		r, err = rr.buffer.runes.Rune(rr.span[0])
+		r, err = rr.runes.Rune(rr.span[0])
 		rr.span[0]++
 	}
 	return r, 1, err
@@ -111,7 +216,7 @@ func (buf *Buffer) RuneReader(s Span) io.RuneReader {
 	if size := buf.Size(); s[0] < 0 || s[1] < 0 || s[0] > size || s[1] > size {
 		return badRange{}
 	}
-	return &runeReader{span: s, buffer: buf}
+	return &runeReader{span: s, runes: buf.runes}
 }
 
 func (buf *Buffer) Reader(s Span) io.Reader {
@@ -136,13 +241,23 @@ func (buf *Buffer) Change(s Span, r io.Reader) (n int64, err error) {
 }
 
 func (buf *Buffer) Apply() error {
+	var first, last int64
+	var n int
 	for e := logFirst(buf.pending); !e.end(); e = e.next() {
 		undoSpan := Span{e.span[0], e.span[0] + e.size}
 		undoSrc := buf.runes.Reader(e.span[0])
 		undoSrc = runes.LimitReader(undoSrc, e.span.Size())
-		if _, err := buf.undo.append(buf.seq, undoSpan, undoSrc); err != nil {
+		if _, err := buf.history.append(buf.seq, undoSpan, undoSrc); err != nil {
 			return err
 		}
+		if n == 0 {
+			first = buf.history.last
+		}
+		if _, err := buf.history.append(buf.seq, e.span, e.data()); err != nil {
+			return err
+		}
+		last = buf.history.last
+		n++
 	}
 	dot := buf.marks['.']
 	for e := logFirst(buf.pending); !e.end(); e = e.next() {
@@ -166,83 +281,18 @@ func (buf *Buffer) Apply() error {
 		}
 	}
 	buf.pending.reset()
-	buf.redo.reset()
-	buf.marks['.'] = dot
-	buf.seq++
-	return nil
-}
-
-func (buf *Buffer) Undo() error {
-	marks0 := make(map[rune]Span, len(buf.marks))
-	for r, s := range buf.marks {
-		marks0[r] = s
-	}
-	defer func() { buf.marks = marks0 }()
-
-	all := Span{-1, 0}
-	start := logLastFrame(buf.undo)
-	if start.end() {
-		return nil
-	}
-	for e := start; !e.end(); e = e.next() {
-		redoSpan := Span{e.span[0], e.span[0] + e.size}
-		redoSrc := buf.runes.Reader(e.span[0])
-		redoSrc = runes.LimitReader(redoSrc, e.span.Size())
-		if _, err := buf.redo.append(buf.seq, redoSpan, redoSrc); err != nil {
-			return err
-		}
-
-		if all[0] < 0 {
-			all[0] = e.span[0]
-		}
-		all[1] = e.span[0] + e.size
-		if err := buf.change(e.span, e.data()); err != nil {
-			return err
+	if n > 0 {
+		buf.newState(first, last, n)
+		if !buf.reverting {
+			buf.own = append(buf.own, buf.state.id)
 		}
 	}
-	buf.marks['.'] = all
-	marks0 = buf.marks
+	buf.marks['.'] = dot
 	buf.seq++
-	return start.pop()
-}
-
-func (buf *Buffer) Redo() error {
-	marks0 := make(map[rune]Span, len(buf.marks))
-	for r, s := range buf.marks {
-		marks0[r] = s
-	}
-	defer func() { buf.marks = marks0 }()
-
-	start := logLastFrame(buf.redo)
-	if start.end() {
-		return nil
-	}
-	for e := start; !e.end(); e = e.next() {
-		undoSpan := Span{e.span[0], e.span[0] + e.size}
-		undoSrc := buf.runes.Reader(e.span[0])
-		undoSrc = runes.LimitReader(undoSrc, e.span.Size())
-		if _, err := buf.undo.append(buf.seq, undoSpan, undoSrc); err != nil {
-			return err
-		}
-	}
-
-	all := Span{0, -1}
-	for e := logLast(buf.redo); e != start.prev(); e = e.prev() {
-		all[0] = e.span[0]
-		if all[1] < 0 {
-			all[1] = e.span[0] + e.size
-		} else {
-			all[1] += e.size - e.span.Size()
-		}
-		if err := buf.change(e.span, e.data()); err != nil {
-			return err
-		}
+	if n > 0 {
+		buf.seqs = append(buf.seqs, seqState{seq: buf.seq, state: buf.state})
 	}
-
-	buf.marks['.'] = all
-	marks0 = buf.marks
-	buf.seq++
-	return start.pop()
+	return buf.prune()
 }
 
 // A log holds a record of changes made to a buffer.
@@ -261,7 +311,7 @@ type log struct {
 	last int64
 }
 
-func newLog() *log { return &log{buf: runes.NewBuffer(1 << 12)} }
+func newLog(s runes.Storage) *log { return &log{buf: runes.NewBufferStorage(1<<12, s)} }
 
 func (l *log) close() error { return l.buf.Close() }
 
@@ -356,21 +406,6 @@ func logAt(l *log, offs int64) entry {
 	return it
 }
 
-// LogLastFrame returns the start of the last frame on the log.
-// A frame is a sequence of log entries with the same seq.
-// If the log is empty, logLastFrame returns the dummy end entry.
-func logLastFrame(l *log) entry {
-	e := logLast(l)
-	for !e.end() {
-		prev := e.prev()
-		if prev.end() || prev.seq != e.seq {
-			break
-		}
-		e = prev
-	}
-	return e
-}
-
 // End returns whether the entry is the dummy end entry.
 func (e entry) end() bool { return e.offs < 0 }
 
@@ -108,8 +108,52 @@ func TestBufferChangeSize(t *testing.T) {
 	}
 }
 
+func TestBufferStorage(t *testing.T) {
+	storages := []struct {
+		name    string
+		storage runes.Storage
+	}{
+		{"file", runes.FileStorage},
+		{"memory", runes.MemoryStorage},
+		{"mmap", runes.MmapStorage},
+		{"auto", runes.AutoStorage(64, runes.FileStorage)},
+	}
+	for _, test := range storages {
+		buf := NewBufferStorage(test.storage)
+		for _, str := range []string{"Hello", ", ", "世界", strings.Repeat("!", 100)} {
+			if _, err := buf.Change(Span{buf.Size(), buf.Size()}, strings.NewReader(str)); err != nil {
+				t.Fatalf("%s: buf.Change(…, %q)=_,%v, want _,nil", test.name, str, err)
+			}
+			if err := buf.Apply(); err != nil {
+				t.Fatalf("%s: buf.Apply()=%v, want nil", test.name, err)
+			}
+		}
+		want := "Hello, 世界" + strings.Repeat("!", 100)
+		if s := buf.String(); s != want {
+			t.Errorf("%s: buf.String()=%q, want %q", test.name, s, want)
+		}
+		for i := 0; i < 2; i++ {
+			if err := buf.Undo(); err != nil {
+				t.Fatalf("%s: buf.Undo()=%v, want nil", test.name, err)
+			}
+		}
+		if s := buf.String(); s != "Hello, " {
+			t.Errorf("%s: after Undo, buf.String()=%q, want %q", test.name, s, "Hello, ")
+		}
+		if err := buf.Redo(); err != nil {
+			t.Fatalf("%s: buf.Redo()=%v, want nil", test.name, err)
+		}
+		if s := buf.String(); s != "Hello, 世界" {
+			t.Errorf("%s: after Redo, buf.String()=%q, want %q", test.name, s, "Hello, 世界")
+		}
+		if err := buf.Close(); err != nil {
+			t.Errorf("%s: buf.Close()=%v, want nil", test.name, err)
+		}
+	}
+}
+
 func TestLogEntryEmpty(t *testing.T) {
-	l := newLog()
+	l := newLog(runes.MemoryStorage)
 	defer l.close()
 	if !logFirst(l).end() {
 		t.Errorf("empty logFirst(l).end()=false, want true")
@@ -314,7 +358,7 @@ func checkEntry(t *testing.T, i int, entries []testEntry, e entry) {
 }
 
 func initTestLog(t *testing.T, entries []testEntry) *log {
-	l := newLog()
+	l := newLog(runes.MemoryStorage)
 	for _, e := range entries {
 		r := runes.StringReader(e.str)
 		if _, err := l.append(e.seq, e.span, r); err != nil {
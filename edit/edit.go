@@ -83,6 +83,12 @@
 // or a combination of the above of the above.
 // It all depends on the Edit being performed.
 //
+// Addresses and Edits can also be encoded as JSON abstract syntax trees,
+// using the encoding/json package.
+// The JSON encoding is decoded using the UnmarshalAddress and UnmarshalEdit functions.
+// This is intended for programs that construct Edits
+// without the need to escape strings of the Edit language.
+//
 // Buffer
 //
 // The Buffer type provides an implementation of the Editor interface.
@@ -95,8 +101,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -295,7 +303,7 @@ func (e print) Do(ed Editor, print io.Writer) error {
 
 type where struct {
 	Address
-	line bool
+	line, bytes bool
 }
 
 // Where returns an Edit
@@ -310,9 +318,19 @@ func Where(a Address) Edit { return where{Address: a} }
 // and sets dot to the a.
 func WhereLine(a Address) Edit { return where{Address: a, line: true} }
 
+// WhereBytes returns an Edit
+// that prints the byte location of a
+// in the UTF-8 encoding of the text
+// to an io.Writer
+// and sets dot to the a.
+func WhereBytes(a Address) Edit { return where{Address: a, bytes: true} }
+
 func (e where) String() string {
-	if e.line {
+	switch {
+	case e.line:
 		return e.Address.String() + "="
+	case e.bytes:
+		return e.Address.String() + "=#b"
 	}
 	return e.Address.String() + "=#"
 }
@@ -334,16 +352,36 @@ func (e where) Do(ed Editor, print io.Writer) error {
 			_, err = fmt.Fprintf(print, "%d,%d\n", l0, l1)
 		}
 	} else {
+		prefix := "#"
+		if e.bytes {
+			prefix = "#b"
+			if s, err = ByteSpan(ed, s); err != nil {
+				return err
+			}
+		}
 		if s.Size() == 0 {
-			_, err = fmt.Fprintf(print, "#%d\n", s[0])
+			_, err = fmt.Fprintf(print, "%s%d\n", prefix, s[0])
 		} else {
-			_, err = fmt.Fprintf(print, "#%d,#%d\n", s[0], s[1])
+			_, err = fmt.Fprintf(print, "%s%d,%s%d\n", prefix, s[0], prefix, s[1])
 		}
 	}
 	return err
 }
 
 func lines(ed Editor, s Span) (l0, l1 int64, err error) {
+	if li, ok := getLineIndex(ed); ok && 0 <= s[0] && s[0] <= s[1] && s[1] <= ed.Size() {
+		if l0, err = li.newlines(s[0]); err != nil {
+			return 0, 0, err
+		}
+		l1 = l0
+		if s[1]-1 > s[0] {
+			if l1, err = li.newlines(s[1] - 1); err != nil {
+				return 0, 0, err
+			}
+		}
+		// line numbers are 1 based.
+		return l0 + 1, l1 + 1, nil
+	}
 	var i int64
 	l0 = int64(1) // line numbers are 1 based.
 	rr := ed.RuneReader(Span{0, ed.Size()})
@@ -388,7 +426,15 @@ type Substitute struct {
 
 	// With is the template with which to replace each match of Regexp.
 	// The syntax is that of the standard regexp package's Regexp.Expand method
-	// described here: https://golang.org/pkg/regexp/#Regexp.Expand.
+	// described here: https://golang.org/pkg/regexp/#Regexp.Expand,
+	// extended with the following escapes:
+	// 	\U converts the following text to upper case.
+	// 	\L converts the following text to lower case.
+	// 	\E ends a preceding \U or \L.
+	// 	\# is replaced with the number of the substitution:
+	// 	    1 for the first match substituted, 2 for the second, and so on.
+	// An escape preceded by \ is literal; for example, \\U is the literal text \U.
+	// A \ followed by any other rune is literal.
 	With string
 
 	// Global is whether to replace all matches, or just one.
@@ -434,31 +480,254 @@ func (e Substitute) String() string {
 	if e.Global {
 		g = "g"
 	}
-	return e.Address.String() + "s" + n + "/" + Escape(e.Regexp, '/') + "/" + Escape(e.With, '/') + "/" + g
+	return e.Address.String() + "s" + n + "/" + Escape(e.Regexp, '/') + "/" + escapeWith(e.With, '/') + "/" + g
 }
 
 func (e Substitute) Do(ed Editor, _ io.Writer) error {
-	re, err := regexpCompile(e.Regexp)
+	tmpl := parseTemplate(e.With)
+	var n int
+	return substitute(ed, e.Address, e.Regexp, e.Global, e.From,
+		func(re *regexp.Regexp, src []byte, match []int) []byte {
+			n++
+			return tmpl.expand(re, src, match, n)
+		})
+}
+
+// A template is a parsed Substitute.With template.
+type template []templatePart
+
+type templatePart struct {
+	// op is one of 'U', 'L', 'E', '#' for the corresponding escape,
+	// or 0 for text to be expanded with Regexp.Expand.
+	op   rune
+	text string
+}
+
+func parseTemplate(with string) template {
+	var t template
+	var text []rune
+	rs := []rune(with)
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case isWithEscape(rs, i):
+			if len(text) > 0 {
+				t = append(t, templatePart{text: string(text)})
+				text = nil
+			}
+			t = append(t, templatePart{op: rs[i+1]})
+			i++
+		case rs[i] == '\\' && isWithEscape(rs, i+1):
+			// \\U is a literal \U.
+			text = append(text, rs[i+1:i+3]...)
+			i += 2
+		default:
+			text = append(text, rs[i])
+		}
+	}
+	if len(text) > 0 {
+		t = append(t, templatePart{text: string(text)})
+	}
+	return t
+}
+
+// IsWithEscape returns whether rs[i:] begins with
+// one of the escapes of a Substitute.With template.
+func isWithEscape(rs []rune, i int) bool {
+	if i+1 >= len(rs) || rs[i] != '\\' {
+		return false
+	}
+	switch rs[i+1] {
+	case 'U', 'L', 'E', '#':
+		return true
+	}
+	return false
+}
+
+// Expand returns the template expanded for the nth substitution
+// of a match of re in src.
+func (t template) expand(re *regexp.Regexp, src []byte, match []int, n int) []byte {
+	var dst []byte
+	var c rune
+	for _, p := range t {
+		var b []byte
+		switch p.op {
+		case 'U', 'L':
+			c = p.op
+			continue
+		case 'E':
+			c = 0
+			continue
+		case '#':
+			b = []byte(strconv.Itoa(n))
+		default:
+			b = re.Expand(nil, []byte(p.text), src, match)
+		}
+		switch c {
+		case 'U':
+			b = bytes.ToUpper(b)
+		case 'L':
+			b = bytes.ToLower(b)
+		}
+		dst = append(dst, b...)
+	}
+	return dst
+}
+
+// EscapeWith returns the string like Escape,
+// but the escapes of a Substitute.With template are not escaped.
+func escapeWith(with string, esc ...rune) string {
+	var s []rune
+	rs := []rune(with)
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case isWithEscape(rs, i):
+			s = append(s, rs[i:i+2]...)
+			i++
+		case rs[i] == '\\' && isWithEscape(rs, i+1):
+			s = append(s, '\\', '\\', '\\', '\\', rs[i+2])
+			i += 2
+		default:
+			s = append(s, []rune(Escape(string(rs[i]), esc...))...)
+		}
+	}
+	return string(s)
+}
+
+// UnescapeWith returns the string like Unescape,
+// but the escapes of a Substitute.With template are left escaped.
+func unescapeWith(str string) string {
+	var s []rune
+	var esc bool
+	for _, r := range str {
+		switch {
+		case !esc && r == '\\':
+			esc = true
+			continue
+		case esc && r == 'n':
+			s = append(s, '\n')
+		case esc && (r == 'U' || r == 'L' || r == 'E' || r == '#'):
+			s = append(s, '\\', r)
+		default:
+			s = append(s, r)
+		}
+		esc = false
+	}
+	if esc {
+		s = append(s, '\\')
+	}
+	return string(s)
+}
+
+// SubstituteFunc is an Edit like Substitute,
+// but each match is replaced by the result of calling a function.
+//
+// SubstituteFunc is intended for use from Go programs.
+// The function has no representation in the edit language or in JSON,
+// so a SubstituteFunc cannot be sent to an editor server.
+// The String method writes the function as the text "func",
+// preceded by @, which is not a command,
+// so the string representation of a SubstituteFunc does not parse.
+// The MarshalText and MarshalJSON methods return an error.
+type SubstituteFunc struct {
+	// Address is the address in which to search for matches.
+	// After performing the edit, Dot is set the modified Address A.
+	Address Address
+
+	// Regexp is the regular expression to match.
+	// See Substitute.Regexp.
+	Regexp string
+
+	// With returns the replacement for each match of Regexp.
+	// It is called with the text of the match
+	// followed by the text of each submatch.
+	// Submatches that did not participate in the match
+	// are the empty string.
+	With func(submatches []string) string
+
+	// Global is whether to replace all matches, or just one.
+	// See Substitute.Global.
+	Global bool
+
+	// From is the number of the first match to begin substituting.
+	// See Substitute.From.
+	From int
+}
+
+// SubFunc returns a SubstituteFunc Edit
+// that substitutes the first occurrence
+// of the regular expression within a
+// with the result of calling with,
+// and sets dot to the modified Address a.
+func SubFunc(a Address, re string, with func([]string) string) Edit {
+	return SubstituteFunc{Address: a, Regexp: re, With: with, From: 1}
+}
+
+// SubFuncGlobal returns a SubstituteFunc Edit
+// that substitutes the all occurrences
+// of the regular expression within a
+// with the result of calling with,
+// and sets dot to the modified Address a.
+func SubFuncGlobal(a Address, re string, with func([]string) string) Edit {
+	return SubstituteFunc{Address: a, Regexp: re, With: with, Global: true, From: 1}
+}
+
+func (e SubstituteFunc) String() string {
+	a := e.Address.String()
+	return a + "@" + Substitute{
+		Address: e.Address,
+		Regexp:  e.Regexp,
+		With:    "func",
+		Global:  e.Global,
+		From:    e.From,
+	}.String()[len(a):]
+}
+
+// ErrSubstituteFunc indicates an attempt to marshal a SubstituteFunc.
+var ErrSubstituteFunc = errors.New("cannot marshal SubstituteFunc")
+
+// MarshalText returns ErrSubstituteFunc,
+// since the function of a SubstituteFunc has no text representation.
+func (e SubstituteFunc) MarshalText() ([]byte, error) { return nil, ErrSubstituteFunc }
+
+func (e SubstituteFunc) Do(ed Editor, _ io.Writer) error {
+	return substitute(ed, e.Address, e.Regexp, e.Global, e.From,
+		func(_ *regexp.Regexp, src []byte, match []int) []byte {
+			subs := make([]string, len(match)/2)
+			for i := range subs {
+				if match[2*i] >= 0 {
+					subs[i] = string(src[match[2*i]:match[2*i+1]])
+				}
+			}
+			return []byte(e.With(subs))
+		})
+}
+
+// Substitute replaces matches of a regular expression within an Address
+// with the result of calling repl.
+// Repl is called with the bytes of the match
+// and the submatch indices into the bytes.
+func substitute(ed Editor, a Address, reStr string, global bool, from int, repl func(*regexp.Regexp, []byte, []int) []byte) error {
+	re, err := regexpCompile(reStr)
 	if err != nil {
 		return err
 	}
-	s, err := e.Address.Where(ed)
+	s, err := a.Where(ed)
 	if err != nil {
 		return err
 	}
 	setDot(ed, s)
 
 	var prev []int
-	from := s[0]
-	for from <= s[1] { // Allow one run on an empty input.
-		m := match(re, Span{from, s[1]}, ed)
+	at := s[0]
+	for at <= s[1] { // Allow one run on an empty input.
+		m := match(re, Span{at, s[1]}, ed)
 		if len(m) < 2 {
 			break
 		}
 		if m[0] == m[1] {
-			from++
+			at++
 		} else {
-			from = int64(m[1])
+			at = int64(m[1])
 		}
 		if len(prev) >= 2 && m[0] == m[1] && m[1] == prev[1] {
 			// Skip an empty match immediately following the previous match.
@@ -466,12 +735,12 @@ func (e Substitute) Do(ed Editor, _ io.Writer) error {
 			continue
 		}
 		prev = m
-		e.From--
-		if e.From <= 0 {
-			if err := regexpSub(re, m, e.With, ed); err != nil {
-				return nil
+		from--
+		if from <= 0 {
+			if err := regexpSub(re, m, repl, ed); err != nil {
+				return err
 			}
-			if !e.Global {
+			if !global {
 				break
 			}
 		}
@@ -479,7 +748,7 @@ func (e Substitute) Do(ed Editor, _ io.Writer) error {
 	return ed.Apply()
 }
 
-func regexpSub(re *regexp.Regexp, match []int, with string, ed Editor) error {
+func regexpSub(re *regexp.Regexp, match []int, repl func(*regexp.Regexp, []byte, []int) []byte, ed Editor) error {
 	dst := Span{int64(match[0]), int64(match[1])}
 	src, err := ioutil.ReadAll(ed.Reader(dst))
 	if err != nil {
@@ -487,10 +756,13 @@ func regexpSub(re *regexp.Regexp, match []int, with string, ed Editor) error {
 	}
 
 	matchSrc := make([]int, len(match))
+	for i := range matchSrc {
+		matchSrc[i] = -1
+	}
 	var bi, ri int
 	for {
 		for i := range match {
-			if match[i]-match[0] == ri {
+			if match[i] >= 0 && match[i]-match[0] == ri {
 				matchSrc[i] = bi
 			}
 		}
@@ -502,15 +774,15 @@ func regexpSub(re *regexp.Regexp, match []int, with string, ed Editor) error {
 		ri++
 	}
 
-	repl := re.Expand(nil, []byte(with), src, matchSrc)
-	_, err = ed.Change(dst, bytes.NewReader(repl))
+	_, err = ed.Change(dst, bytes.NewReader(repl(re, src, matchSrc)))
 	return err
 }
 
 type loop struct {
 	Address
-	regexp string
-	body   Edit
+	regexp  string
+	body    Edit
+	between bool
 }
 
 // Loop returns an Edit that performs another Edit, body,
@@ -527,8 +799,31 @@ func Loop(a Address, re string, body Edit) Edit {
 	return loop{Address: a, regexp: re, body: body}
 }
 
+// LoopBetween returns an Edit like Loop,
+// but the body edit is executed for each string between matches
+// of the regular expression within an Address.
+// This includes the string from the start of the Address
+// to the first match,
+// and the string from the last match
+// to the end of the Address.
+// If there are no matches, the body is executed once
+// with dot set to the Address.
+// After all strings, dot is set to the last string.
+//
+// If the regexp is empty, "\n" is used.
+func LoopBetween(a Address, re string, body Edit) Edit {
+	if re == "" {
+		re = "\n"
+	}
+	return loop{Address: a, regexp: re, body: body, between: true}
+}
+
 func (e loop) String() string {
-	return e.Address.String() + "x/" + Escape(e.regexp, '/') + "/" + e.body.String()
+	op := "x"
+	if e.between {
+		op = "y"
+	}
+	return e.Address.String() + op + "/" + Escape(e.regexp, '/') + "/" + e.body.String()
 }
 
 type ignoreApply struct{ Editor }
@@ -547,7 +842,7 @@ func (e loop) Do(ed Editor, print io.Writer) error {
 
 	dot := s
 	var prev []int
-	from := s[0]
+	from, start := s[0], s[0]
 	for from <= s[1] { // Allow one run on an empty input.
 		m := match(re, Span{from, s[1]}, ed)
 		if len(m) < 2 {
@@ -565,7 +860,20 @@ func (e loop) Do(ed Editor, print io.Writer) error {
 		}
 		prev = m
 
-		dot = Span{int64(m[0]), int64(m[1])}
+		if e.between {
+			dot = Span{start, int64(m[0])}
+			start = int64(m[1])
+		} else {
+			dot = Span{int64(m[0]), int64(m[1])}
+		}
+		setDot(ed, dot)
+		if err := e.body.Do(ignoreApply{ed}, print); err != nil {
+			return err
+		}
+	}
+	if e.between {
+		// The string following the last match.
+		dot = Span{start, s[1]}
 		setDot(ed, dot)
 		if err := e.body.Do(ignoreApply{ed}, print); err != nil {
 			return err
@@ -575,6 +883,119 @@ func (e loop) Do(ed Editor, print io.Writer) error {
 	return ed.Apply()
 }
 
+// FileLoop is an Edit that performs another Edit
+// on each file with a name matching a regular expression.
+//
+// A FileLoop performed on a single Editor
+// performs the Body edit only if
+// the Editor's file name matches.
+// Programs managing multiple Editors,
+// such as the editor server,
+// can use the Match method to perform the Body on each matching Editor.
+type FileLoop struct {
+	// Regexp is the regular expression matched against file names.
+	// The regular expression syntax is that of the standard library regexp package,
+	// but the regexp is wrapped in (?m:<re>), making it multi-line by default,
+	// as are all regular expressions of the edit language.
+	// If Regexp is the empty string, all file names match.
+	Regexp string
+
+	// Body is the Edit performed on each matching file.
+	// It is performed with dot unchanged.
+	Body Edit
+
+	// Not is whether the Body is performed on files
+	// whose names do not match Regexp, instead of those that do.
+	Not bool
+}
+
+// LoopFiles returns a FileLoop Edit
+// that performs body on each file
+// with a name matching a regular expression.
+func LoopFiles(re string, body Edit) Edit { return FileLoop{Regexp: re, Body: body} }
+
+// LoopFilesNot returns a FileLoop Edit
+// that performs body on each file
+// with a name not matching a regular expression.
+func LoopFilesNot(re string, body Edit) Edit { return FileLoop{Regexp: re, Body: body, Not: true} }
+
+func (e FileLoop) String() string {
+	op := "X"
+	if e.Not {
+		op = "Y"
+	}
+	return op + "/" + Escape(e.Regexp, '/') + "/" + e.Body.String()
+}
+
+// Match returns whether the Body should be performed
+// on the file with the given name.
+func (e FileLoop) Match(name string) (bool, error) {
+	re, err := regexpCompile(e.Regexp)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(name) != e.Not, nil
+}
+
+// Do performs the Body on the Editor
+// if the Editor's file name matches.
+func (e FileLoop) Do(ed Editor, print io.Writer) error {
+	switch ok, err := e.Match(ed.FileName()); {
+	case err != nil:
+		return err
+	case !ok:
+		return nil
+	}
+	return e.Body.Do(ed, print)
+}
+
+type guard struct {
+	Address
+	regexp string
+	body   Edit
+	not    bool
+}
+
+// Guard returns an Edit that performs another Edit, body,
+// only if the string at an Address contains a match
+// of a regular expression.
+// Dot is set to the Address,
+// and the body edit is executed with dot set to the Address.
+func Guard(a Address, re string, body Edit) Edit {
+	return guard{Address: a, regexp: re, body: body}
+}
+
+// GuardNot returns an Edit like Guard,
+// but the body edit is performed only if the string at the Address
+// does not contain a match of the regular expression.
+func GuardNot(a Address, re string, body Edit) Edit {
+	return guard{Address: a, regexp: re, body: body, not: true}
+}
+
+func (e guard) String() string {
+	op := "g"
+	if e.not {
+		op = "v"
+	}
+	return e.Address.String() + op + "/" + Escape(e.regexp, '/') + "/" + e.body.String()
+}
+
+func (e guard) Do(ed Editor, print io.Writer) error {
+	s, err := e.Address.Where(ed)
+	if err != nil {
+		return err
+	}
+	re, err := regexpCompile(e.regexp)
+	if err != nil {
+		return err
+	}
+	setDot(ed, s)
+	if m := match(re, s, ed); (len(m) >= 2) == e.not {
+		return nil
+	}
+	return e.body.Do(ed, print)
+}
+
 type pipe struct {
 	Address
 	cmd      string
@@ -595,6 +1016,8 @@ type pipe struct {
 // The shell is either the value of
 // the SHELL environment variable
 // or DefaultShell if SHELL is unset.
+// However, if the Editor is a CommandEditor,
+// the command is instead given by its Command method.
 func Pipe(a Address, cmd string) Edit {
 	return pipe{Address: a, cmd: cmd, to: true, from: true}
 }
@@ -649,14 +1072,42 @@ func shell() string {
 	return DefaultShell
 }
 
+// A CommandEditor is an Editor that controls
+// the commands run by pipe edits performed on it.
+type CommandEditor interface {
+	Editor
+
+	// Command returns the command to run
+	// for the shell command line of a pipe edit,
+	// or an error if the command must not be run.
+	Command(string) (*exec.Cmd, error)
+}
+
+// Command returns the command to run for a pipe edit on the Editor.
+func command(ed Editor, line string) (*exec.Cmd, error) {
+	for {
+		ia, ok := ed.(ignoreApply)
+		if !ok {
+			break
+		}
+		ed = ia.Editor
+	}
+	if c, ok := ed.(CommandEditor); ok {
+		return c.Command(line)
+	}
+	return exec.Command(shell(), "-c", line), nil
+}
+
 func (e pipe) Do(ed Editor, print io.Writer) error {
 	s, err := e.Where(ed)
 	if err != nil {
 		return err
 	}
+	cmd, err := command(ed, e.cmd)
+	if err != nil {
+		return err
+	}
 	setDot(ed, s)
-
-	cmd := exec.Command(shell(), "-c", e.cmd)
 	cmd.Stderr = print
 
 	if e.to {
@@ -688,6 +1139,337 @@ func (e pipe) Do(ed Editor, print io.Writer) error {
 	return ed.Apply()
 }
 
+// ErrNoFileName indicates that a file Edit was not given a file name,
+// and the Editor has no associated file.
+var ErrNoFileName = errors.New("no file name")
+
+func fileName(ed Editor, name string) (string, error) {
+	if name == "" {
+		name = ed.FileName()
+	}
+	if name == "" {
+		return "", ErrNoFileName
+	}
+	return name, nil
+}
+
+type editFile string
+
+// EditFile returns an Edit
+// that replaces the entire text
+// with the contents of the named file,
+// sets the Editor's file name to the name,
+// sets the Editor's encoding and line ending to those of the file,
+// and sets dot to the new text.
+// If the name is empty,
+// the Editor's file name is used.
+//
+// The file is opened using the Editor's FileSystem,
+// and decoded as described by ReadFile.
+func EditFile(name string) Edit { return editFile(name) }
+
+func (e editFile) String() string { return "e " + escNewlines(string(e)) + "\n" }
+
+func (e editFile) Do(ed Editor, _ io.Writer) error {
+	name, err := fileName(ed, string(e))
+	if err != nil {
+		return err
+	}
+	enc, nl, err := readFile(ed, Span{0, ed.Size()}, name)
+	if err != nil {
+		return err
+	}
+	ed.SetFileName(name)
+	ed.SetEncoding(enc)
+	ed.SetLineEnding(nl)
+	synced(ed)
+	return nil
+}
+
+// A SyncEditor is an Editor that is notified
+// when its entire text is read from or written to its file
+// by an EditFile or WriteFile Edit performed directly on it,
+// not within a loop or block.
+type SyncEditor interface {
+	Editor
+
+	// Synced is called after the text is read or written.
+	Synced()
+}
+
+func synced(ed Editor) {
+	if se, ok := ed.(SyncEditor); ok {
+		se.Synced()
+	}
+}
+
+type readFileEdit struct {
+	Address
+	name string
+}
+
+// ReadFile returns an Edit
+// that replaces the string at a
+// with the contents of the named file,
+// and sets dot to the new text.
+// If the name is empty,
+// the Editor's file name is used.
+//
+// The file is opened using the Editor's FileSystem.
+// If it is the Editor's file,
+// and the Editor's encoding is not auto,
+// the file is decoded using the Editor's encoding.
+// Otherwise, it is decoded using the encoding detected by DetectEncoding.
+// Likewise, line endings are replaced by newlines
+// using the Editor's line ending if it is the Editor's file
+// and the line ending is not auto,
+// or otherwise the line ending detected by DetectLineEnding.
+func ReadFile(a Address, name string) Edit { return readFileEdit{Address: a, name: name} }
+
+func (e readFileEdit) String() string {
+	return e.Address.String() + "r " + escNewlines(e.name) + "\n"
+}
+
+func (e readFileEdit) Do(ed Editor, _ io.Writer) error {
+	s, err := e.Where(ed)
+	if err != nil {
+		return err
+	}
+	name, err := fileName(ed, e.name)
+	if err != nil {
+		return err
+	}
+	_, _, err = readFile(ed, s, name)
+	return err
+}
+
+// ReadFile changes the Span to the contents of the named file
+// and returns the encoding and line ending used to decode the file.
+func readFile(ed Editor, s Span, name string) (Encoding, LineEnding, error) {
+	enc, nl := ed.Encoding(), ed.LineEnding()
+	if name != ed.FileName() {
+		enc, nl = AutoEncoding, AutoLineEnding
+	}
+	fs := ed.FileSystem()
+	if enc == AutoEncoding {
+		err := detectFile(fs, name, func(r io.Reader) (err error) {
+			enc, err = DetectEncoding(r)
+			return err
+		})
+		if err != nil {
+			return AutoEncoding, AutoLineEnding, err
+		}
+	}
+	if nl == AutoLineEnding {
+		err := detectFile(fs, name, func(r io.Reader) (err error) {
+			nl, err = DetectLineEnding(enc.NewDecoder(r))
+			return err
+		})
+		if err != nil {
+			return AutoEncoding, AutoLineEnding, err
+		}
+	}
+	f, err := fs.Open(name)
+	if err != nil {
+		return AutoEncoding, AutoLineEnding, err
+	}
+	setDot(ed, s)
+	_, changeErr := ed.Change(s, nl.NewDecoder(enc.NewDecoder(f)))
+	if err := f.Close(); err != nil {
+		return AutoEncoding, AutoLineEnding, err
+	}
+	if changeErr != nil {
+		return AutoEncoding, AutoLineEnding, changeErr
+	}
+	return enc, nl, ed.Apply()
+}
+
+// DetectFile calls detect with the contents of the named file.
+func detectFile(fs FileSystem, name string, detect func(io.Reader) error) error {
+	f, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+	detectErr := detect(f)
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return detectErr
+}
+
+type writeFile struct {
+	Address
+	name string
+}
+
+// WriteFile returns an Edit
+// that writes the string at a to the named file,
+// and sets dot to a.
+// If the name is empty,
+// the Editor's file name is used.
+// If the Editor has no file name,
+// it is set to the name.
+//
+// The file is created using the Editor's FileSystem,
+// and the string is encoded using the Editor's encoding,
+// or UTF-8 if the encoding is auto.
+// Each newline is written as the Editor's line ending,
+// or as a newline if the line ending is auto.
+//
+// The file is not changed if the string cannot be encoded.
+// If the FileSystem is a RenameFileSystem,
+// the string is written to a temporary file in the same directory,
+// which is renamed to the named file once it is completely written.
+// Otherwise, the string is encoded in memory before the file is created.
+func WriteFile(a Address, name string) Edit { return writeFile{Address: a, name: name} }
+
+func (e writeFile) String() string {
+	return e.Address.String() + "w " + escNewlines(e.name) + "\n"
+}
+
+func (e writeFile) Do(ed Editor, _ io.Writer) error {
+	s, err := e.Where(ed)
+	if err != nil {
+		return err
+	}
+	name, err := fileName(ed, e.name)
+	if err != nil {
+		return err
+	}
+	setDot(ed, s)
+	if err := writeText(ed, s, name); err != nil {
+		return err
+	}
+	if ed.FileName() == "" {
+		ed.SetFileName(name)
+	}
+	if name == ed.FileName() && s == (Span{0, ed.Size()}) {
+		synced(ed)
+	}
+	return nil
+}
+
+// WriteText writes the string at s to the named file
+// as described by WriteFile.
+func writeText(ed Editor, s Span, name string) error {
+	fs := ed.FileSystem()
+	if rfs, ok := fs.(RenameFileSystem); ok {
+		dir, file := filepath.Split(name)
+		tmp := filepath.Join(dir, "."+file+".T"+strconv.FormatInt(rand.Int63(), 36))
+		err := createFile(rfs, tmp, func(w io.Writer) error { return encodeText(ed, s, w) })
+		if err == nil {
+			err = rfs.Rename(tmp, name)
+		}
+		if err != nil {
+			rfs.Remove(tmp)
+		}
+		return err
+	}
+	var data bytes.Buffer
+	if err := encodeText(ed, s, &data); err != nil {
+		return err
+	}
+	return createFile(fs, name, func(w io.Writer) error {
+		_, err := data.WriteTo(w)
+		return err
+	})
+}
+
+// CreateFile creates the named file and calls write to write its contents.
+func createFile(fs FileSystem, name string, write func(io.Writer) error) error {
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// EncodeText writes the string at s to w
+// using the Editor's encoding and line ending.
+func encodeText(ed Editor, s Span, w io.Writer) error {
+	enc := ed.Encoding().NewEncoder(w)
+	if _, err := io.Copy(ed.LineEnding().NewEncoder(enc), ed.Reader(s)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+type file string
+
+// File returns an Edit
+// that sets the Editor's file name
+// and prints the file name followed by a newline
+// to an io.Writer.
+// If the name is empty,
+// the file name is printed but is not changed.
+func File(name string) Edit { return file(name) }
+
+func (e file) String() string { return "f " + escNewlines(string(e)) + "\n" }
+
+func (e file) Do(ed Editor, print io.Writer) error {
+	if e != "" {
+		ed.SetFileName(string(e))
+	}
+	_, err := io.WriteString(print, ed.FileName()+"\n")
+	return err
+}
+
+type encoding string
+
+// FileEncoding returns an Edit
+// that sets the Editor's encoding to the named Encoding
+// and prints the name of the encoding followed by a newline
+// to an io.Writer.
+// If the name is empty,
+// the encoding is printed but is not changed.
+//
+// The name is parsed by ParseEncoding.
+func FileEncoding(name string) Edit { return encoding(name) }
+
+func (e encoding) String() string { return "E " + escNewlines(string(e)) + "\n" }
+
+func (e encoding) Do(ed Editor, print io.Writer) error {
+	if e != "" {
+		enc, err := ParseEncoding(string(e))
+		if err != nil {
+			return err
+		}
+		ed.SetEncoding(enc)
+	}
+	_, err := io.WriteString(print, ed.Encoding().String()+"\n")
+	return err
+}
+
+type lineEnding string
+
+// FileLineEnding returns an Edit
+// that sets the Editor's line ending to the named LineEnding
+// and prints the name of the line ending followed by a newline
+// to an io.Writer.
+// If the name is empty,
+// the line ending is printed but is not changed.
+//
+// The name is parsed by ParseLineEnding.
+func FileLineEnding(name string) Edit { return lineEnding(name) }
+
+func (e lineEnding) String() string { return "N " + escNewlines(string(e)) + "\n" }
+
+func (e lineEnding) Do(ed Editor, print io.Writer) error {
+	if e != "" {
+		nl, err := ParseLineEnding(string(e))
+		if err != nil {
+			return err
+		}
+		ed.SetLineEnding(nl)
+	}
+	_, err := io.WriteString(print, ed.LineEnding().String()+"\n")
+	return err
+}
+
 type undo int
 
 // Undo returns an Edit
@@ -746,6 +1528,150 @@ func (e redo) Do(ed Editor, _ io.Writer) error {
 	return nil
 }
 
+type selectiveUndo int
+
+// SelectiveUndo returns an Edit
+// that reverts the n most recent changes
+// made by the Editor performing the Edit,
+// keeping intact the changes made since by other Editors,
+// and sets dot to the address
+// covering the last reverted change.
+// If n ≤ 0 then 1 change is reverted.
+func SelectiveUndo(n int) Edit { return selectiveUndo(n) }
+
+func (e selectiveUndo) String() string {
+	if e <= 0 {
+		return "o1"
+	}
+	return "o" + strconv.Itoa(int(e))
+}
+
+func (e selectiveUndo) Do(ed Editor, _ io.Writer) error {
+	if e <= 0 {
+		e = 1
+	}
+	for i := 0; i < int(e); i++ {
+		if err := ed.SelectiveUndo(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type undoList struct{}
+
+// UndoList returns an Edit
+// that prints the states of the undo tree, ordered by ID, one per line.
+// Each line contains the ID of the state,
+// the ID of its parent, or -1 for the root,
+// and the number of changes made to the parent to create the state,
+// separated by spaces.
+// The line of the current state is followed by a space and *,
+// and the line of each state with checkpoints is followed by,
+// for each checkpoint, a space, ', and the checkpoint name.
+// Dot is unchanged.
+func UndoList() Edit { return undoList{} }
+
+func (undoList) String() string { return "U" }
+
+func (undoList) Do(ed Editor, print io.Writer) error {
+	for _, s := range ed.UndoStates() {
+		line := strconv.Itoa(s.ID) + " " + strconv.Itoa(s.Parent) + " " + strconv.Itoa(s.Changes)
+		if s.Current {
+			line += " *"
+		}
+		for _, name := range s.Checkpoints {
+			line += " '" + name
+		}
+		if _, err := io.WriteString(print, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type undoTo int
+
+// UndoTo returns an Edit
+// that moves to the state of the undo tree with the given ID,
+// undoing and redoing changes along the path between the states,
+// and sets dot to the address
+// covering the last undone or redone change.
+// If id < 0 then the root state, 0, is used.
+func UndoTo(id int) Edit {
+	if id < 0 {
+		id = 0
+	}
+	return undoTo(id)
+}
+
+func (e undoTo) String() string { return "U" + strconv.Itoa(int(e)) }
+
+func (e undoTo) Do(ed Editor, _ io.Writer) error { return ed.UndoTo(int(e)) }
+
+type checkpoint string
+
+// Checkpoint returns an Edit
+// that sets a named checkpoint at the current state of the undo tree.
+// If the name already names a checkpoint,
+// the checkpoint is moved to the current state.
+// The name should be non-empty and contain no whitespace.
+// Dot is unchanged.
+func Checkpoint(name string) Edit { return checkpoint(name) }
+
+func (e checkpoint) String() string { return "K " + string(e) }
+
+func (e checkpoint) Do(ed Editor, _ io.Writer) error {
+	ed.SetCheckpoint(string(e))
+	return nil
+}
+
+type undoToCheckpoint string
+
+// UndoToCheckpoint returns an Edit
+// that moves to the state of the undo tree with the named checkpoint,
+// undoing and redoing changes along the path between the states,
+// and sets dot to the address
+// covering the last undone or redone change.
+// If there is no checkpoint with the name,
+// ErrNoCheckpoint is returned.
+func UndoToCheckpoint(name string) Edit { return undoToCheckpoint(name) }
+
+func (e undoToCheckpoint) String() string { return "U'" + string(e) }
+
+func (e undoToCheckpoint) Do(ed Editor, _ io.Writer) error {
+	for _, s := range ed.UndoStates() {
+		for _, name := range s.Checkpoints {
+			if name == string(e) {
+				return ed.UndoTo(s.ID)
+			}
+		}
+	}
+	return ErrNoCheckpoint
+}
+
+type undoToSequence int
+
+// UndoToSequence returns an Edit
+// that moves to the state of the undo tree
+// that was current after the edit with the given sequence number,
+// undoing and redoing changes along the path between the states,
+// and sets dot to the address
+// covering the last undone or redone change.
+// Sequence numbers are assigned by the Editor;
+// see Editor.UndoToSequence.
+// If seq < 0 then 0 is used.
+func UndoToSequence(seq int) Edit {
+	if seq < 0 {
+		seq = 0
+	}
+	return undoToSequence(seq)
+}
+
+func (e undoToSequence) String() string { return "U@" + strconv.Itoa(int(e)) }
+
+func (e undoToSequence) Do(ed Editor, _ io.Writer) error { return ed.UndoToSequence(int(e)) }
+
 type block struct {
 	Address
 	body []Edit
@@ -840,6 +1766,17 @@ func (e block) Do(ed Editor, print io.Writer) error {
 // 		in both the regexp and replacement text.
 // 		For example, ,s/\\s+/\\/g replaces runs of whitespace with \.
 //
+// 		The replacement text may also contain the following escapes:
+// 		\U converts the following text to upper case,
+// 		\L converts the following text to lower case,
+// 		\E ends a preceding \U or \L,
+// 		and \# is replaced with the number of the substitution,
+// 		1 for the first, 2 for the second, and so on.
+// 		For example, ,s/(\\w+)/\U${1}\E-\#/g
+// 		upper-cases each word and follows it with its number.
+// 		An escape preceded by an escaped \ is literal;
+// 		for example, \\\\U is the literal text \U.
+//
 //		A number n after s indicates we substitute the Nth match in the
 //		address range. If n == 0 set n = 1.
 // 		If the delimiter after the text is followed by the letter g
@@ -856,11 +1793,39 @@ func (e block) Do(ed Editor, print io.Writer) error {
 //
 // 		The regexp uses the same syntax as described for substitute.
 // 		However, if the regexp is empty, ".*\n" is used.
+// 		The regexp may be omitted entirely if the edit begins with a letter,
+// 		as in ,x d.
 //
 //		If an address is not supplied, dot is used.
 // 		After all matches, dot is set to the last match;
 // 		if there were no matches then it is set to the Address.
 //
+// 	[addr] y/regexp/edit
+// 		Like x, but executes the edit for each string
+// 		between matches of regexp within the Address,
+// 		including the strings before the first match
+// 		and after the last match.
+// 		The edit is executed with dot set to the string.
+//
+// 		If the regexp is empty, "\n" is used.
+//
+//		If an address is not supplied, dot is used.
+// 		After all strings, dot is set to the last string.
+//
+// 	[addr] g/regexp/edit
+// 	[addr] v/regexp/edit
+// 		g executes the edit only if the addressed string
+// 		contains a match of the regexp.
+// 		v executes the edit only if the addressed string
+// 		does not contain a match of the regexp.
+// 		The edit is executed with dot set to the address.
+//
+// 		The regexp uses the same syntax as described for substitute.
+// 		For example, ,x g/TODO/ d deletes all lines containing TODO.
+//
+//		If an address is not supplied, dot is used.
+// 		Dot is set to the address.
+//
 //	[addr] k [name]
 //		Sets the named mark to the address.
 //		If an address is not supplied, dot is used.
@@ -872,9 +1837,11 @@ func (e block) Do(ed Editor, print io.Writer) error {
 //		Returns the runes identified by the address.
 //		If an address is not supplied, dot is used.
 //		Dot is set to the address.
-//	[addr] =[#]
+//	[addr] =[#[b]]
 //		Without '#' returns the line offset(s) of the address.
 //		With '#' returns the rune offsets of the address.
+//		With '#b' returns the byte offsets of the address
+//		in the UTF-8 encoding of the text.
 //		If an address is not supplied, dot is used.
 //		Dot is set to the address.
 //	[addr] | cmd
@@ -896,6 +1863,77 @@ func (e block) Do(ed Editor, print io.Writer) error {
 //		Parsing of cmd is termiated by
 //		either a newline or the end of input.
 //		Within cmd, \n is interpreted as a newline literal.
+//	e [file]
+//		Replaces the entire text with the contents of the file,
+//		and sets the file name to file.
+//		If file is not supplied, the current file name is used.
+//		Dot is set to the new text.
+//	[addr] r [file]
+//		Replaces the addressed text with the contents of the file.
+//		If file is not supplied, the current file name is used.
+//		If an address is not supplied, dot is used.
+//		Dot is set to the new text.
+//
+//		Without an address, r followed by only an optional number
+//		and whitespace up to the end of the line
+//		is the redo command described below.
+//		A file name beginning with a digit must not be a number,
+//		so r 2019-notes.txt reads the file 2019-notes.txt.
+//	[addr] w [file]
+//		Writes the addressed text to the file.
+//		If file is not supplied, the current file name is used.
+//		If there is no current file name, it is set to file.
+//		If an address is not supplied, 0,$ is used.
+//		The file is not changed if the text cannot be encoded.
+//		Dot is set to the address.
+//	f [file]
+//		Sets the file name to file, and returns the file name.
+//		If file is not supplied, the file name is unchanged.
+//	E [encoding]
+//		Sets the encoding to encoding, and returns the encoding.
+//		If encoding is not supplied, the encoding is unchanged.
+//		The encodings are auto, utf-8, utf-8-bom,
+//		utf-16le, utf-16le-bom, utf-16be, utf-16be-bom,
+//		and iso-8859-1.
+//
+//		Files are accessed using the FileSystem of the Editor.
+//		The file of the Editor is read and written using its encoding.
+//		Other files are read using their detected encoding,
+//		which e sets as the encoding,
+//		and are written using the Editor's encoding.
+//		The auto encoding reads the detected encoding
+//		and writes UTF-8.
+//	N [line ending]
+//		Sets the line ending to line ending,
+//		and returns the line ending.
+//		If line ending is not supplied, the line ending is unchanged.
+//		The line endings are auto, lf, and crlf.
+//
+//		Files are read with each line ending replaced by a newline,
+//		and written with each newline replaced by the line ending.
+//		Like the encoding, the line ending of the Editor
+//		is used for its file,
+//		and the detected line ending is used for other files,
+//		which e sets as the line ending.
+//		The auto line ending reads the detected line ending
+//		and writes newlines.
+//		For all file commands, parsing of file is terminated by
+//		either a newline or the end of input.
+//		Within file, \n is interpreted as a newline literal.
+//	X/regexp/edit
+//	Y/regexp/edit
+//		X executes the edit on each file
+//		with a name matching the regexp.
+//		Y executes the edit on each file
+//		with a name not matching the regexp.
+//		If the regexp is empty, all file names match.
+//
+//		On a single Editor,
+//		the edit is executed only if the Editor's file name matches.
+//		Programs managing multiple Editors
+//		may execute the edit on each matching Editor;
+//		see FileLoop.
+//		Dot is unchanged.
 //	u[n]
 //		Undoes the n most recent changes
 // 		made to the buffer by any Editor.
@@ -909,6 +1947,45 @@ func (e block) Do(ed Editor, print io.Writer) error {
 //		Dot is set to the address covering
 // 		the last redone change.
 //
+//	o[n]
+//		Undoes the n most recent changes
+//		made to the buffer by this Editor,
+//		keeping intact the changes made since by other Editors.
+//		If n is not specified, it defaults to 1.
+//		Unlike u, the undo is itself a new change.
+//		It is an error if a later change modified
+//		the text changed by the undone changes.
+//		Dot is set to the address covering
+//		the last undone change.
+//
+//		Undo and redo move through the undo tree; see UndoState.
+//		Undoing changes and then making new changes
+//		starts a new branch, and r redoes the newest branch.
+//	U
+//		Returns the states of the undo tree, one per line,
+//		each with its ID, the ID of its parent, and its number of changes.
+//		The current state is marked with *,
+//		and checkpoints are listed by name, each preceded by '.
+//		Dot is unchanged.
+//	U n
+//		Moves to the state of the undo tree with ID n,
+//		undoing and redoing changes along the path between the states,
+//		which may be on different branches.
+//		Dot is set to the address covering
+// 		the last undone or redone change.
+//
+//	U'name
+//		Like U n, but moves to the state with the named checkpoint.
+//	U@n
+//		Like U n, but moves to the state that was current
+//		after the edit with sequence number n.
+//		Sequence numbers are assigned by the Editor.
+//	K name
+//		Sets the named checkpoint to the current state of the undo tree.
+//		The name is a sequence of non-whitespace runes.
+//		If the name is already set, it is moved to the current state.
+//		Dot is unchanged.
+//
 // 	[addr] {
 // 		edit
 // 		…
@@ -924,7 +2001,10 @@ func (e block) Do(ed Editor, print io.Writer) error {
 //
 // 		If EOF is encountered before }, the block is closed at EOF.
 // 		An empty group performs no edits and simply sets dot.
+//
+// Errors in the syntax of the edit are reported as a *ParseError.
 func Ed(rs io.RuneScanner) (Edit, error) {
+	rs = newScanner(rs)
 	a, err := Addr(rs)
 	switch {
 	case err != nil:
@@ -942,11 +2022,79 @@ func Ed(rs io.RuneScanner) (Edit, error) {
 			}
 			return Undo(n), nil
 		case r == 'r':
+			return parseRedo(rs)
+		case r == 'o':
+			n, err := parseNumber(rs)
+			if err != nil {
+				return nil, err
+			}
+			return SelectiveUndo(n), nil
+		case r == 'K':
+			name, err := parseName(rs)
+			if err != nil {
+				return nil, err
+			}
+			return Checkpoint(name), nil
+		case r == 'U':
+			if err := skipSpace(rs); err != nil {
+				return nil, err
+			}
+			switch r, _, err := rs.ReadRune(); {
+			case err == io.EOF:
+				return UndoList(), nil
+			case err != nil:
+				return nil, err
+			case r == '\'':
+				name, err := parseName(rs)
+				if err != nil {
+					return nil, err
+				}
+				return UndoToCheckpoint(name), nil
+			case r == '@':
+				n, err := parseNumber(rs)
+				if err != nil {
+					return nil, err
+				}
+				return UndoToSequence(n), nil
+			default:
+				if err := rs.UnreadRune(); err != nil {
+					return nil, err
+				}
+				if !unicode.IsDigit(r) {
+					return UndoList(), nil
+				}
+			}
 			n, err := parseNumber(rs)
 			if err != nil {
 				return nil, err
 			}
-			return Redo(n), nil
+			return UndoTo(n), nil
+		case r == 'X' || r == 'Y':
+			re, edit, err := parseRegexpEdit(rs)
+			if err != nil {
+				return nil, err
+			}
+			if r == 'X' {
+				return LoopFiles(re, edit), nil
+			}
+			return LoopFilesNot(re, edit), nil
+		case r == 'e' || r == 'f' || r == 'w' || r == 'E' || r == 'N':
+			name, err := parseCmd(rs)
+			if err != nil {
+				return nil, err
+			}
+			switch r {
+			case 'e':
+				return EditFile(name), nil
+			case 'f':
+				return File(name), nil
+			case 'E':
+				return FileEncoding(name), nil
+			case 'N':
+				return FileLineEnding(name), nil
+			default: // case 'w'
+				return WriteFile(All, name), nil
+			}
 		default:
 			if err := rs.UnreadRune(); err != nil {
 				return nil, err
@@ -994,6 +2142,16 @@ func Ed(rs io.RuneScanner) (Edit, error) {
 		case err != nil:
 			return nil, err
 		case r == '#':
+			switch r, _, err := rs.ReadRune(); {
+			case err == nil && r == 'b':
+				return WhereBytes(a), nil
+			case err == nil:
+				if err := rs.UnreadRune(); err != nil {
+					return nil, err
+				}
+			case err != io.EOF:
+				return nil, err
+			}
 			return Where(a), nil
 		default:
 			if err := rs.UnreadRune(); err != nil {
@@ -1027,18 +2185,15 @@ func Ed(rs io.RuneScanner) (Edit, error) {
 		case delim == '\n':
 			return Sub(a, "", ""), rs.UnreadRune()
 		}
-		re, err := parseDelimited(delim, rs)
+		re, err := parseRegexp(delim, rs)
 		if err != nil {
 			return nil, err
 		}
-		if _, err := regexpCompile(re); err != nil {
-			return nil, err
-		}
-		with, err := parseDelimited(delim, rs)
+		with, err := parseDelimitedRaw(delim, rs)
 		if err != nil {
 			return nil, err
 		}
-		sub := Substitute{Address: a, Regexp: re, With: with, From: from}
+		sub := Substitute{Address: a, Regexp: re, With: unescapeWith(with), From: from}
 		switch r, _, err := rs.ReadRune(); {
 		case err == io.EOF:
 			return sub, nil
@@ -1052,31 +2207,32 @@ func Ed(rs io.RuneScanner) (Edit, error) {
 			}
 		}
 		return sub, nil
-	case r == 'x':
-		if err := skipSpace(rs); err != nil {
-			return nil, err
-		}
-		delim, _, err := rs.ReadRune()
-		switch {
-		case err != nil && err != io.EOF:
-			return nil, err
-		case err == io.EOF:
-			return Loop(a, "", Set(Dot, '.')), nil
-		case delim == '\n':
-			return Loop(a, "", Set(Dot, '.')), rs.UnreadRune()
-		}
-		re, err := parseDelimited(delim, rs)
+	case r == 'x' || r == 'y' || r == 'g' || r == 'v':
+		re, edit, err := parseRegexpEdit(rs)
 		if err != nil {
 			return nil, err
 		}
-		if _, err := regexpCompile(re); err != nil {
-			return nil, err
+		switch r {
+		case 'x':
+			return Loop(a, re, edit), nil
+		case 'y':
+			return LoopBetween(a, re, edit), nil
+		case 'g':
+			return Guard(a, re, edit), nil
+		default: // case 'v'
+			return GuardNot(a, re, edit), nil
 		}
-		edit, err := Ed(rs)
+	case r == 'r' || r == 'w':
+		name, err := parseCmd(rs)
 		if err != nil {
 			return nil, err
 		}
-		return Loop(a, re, edit), nil
+		if r == 'r' {
+			return ReadFile(a, name), nil
+		}
+		return WriteFile(a, name), nil
+	case r == 'e' || r == 'f' || r == 'E' || r == 'N' || r == 'X' || r == 'Y':
+		return nil, parseErrorAt(offset(rs)-1, r, "", ErrAddressNotAllowed)
 	case r == '|' || r == '>' || r == '<':
 		c, err := parseCmd(rs)
 		if err != nil {
@@ -1112,7 +2268,7 @@ func Ed(rs io.RuneScanner) (Edit, error) {
 			}
 		}
 	default:
-		return nil, errors.New("unknown command: " + string(r))
+		return nil, parseErrorAt(offset(rs)-1, r, "", ErrUnknownCommand)
 	}
 }
 
@@ -1128,6 +2284,38 @@ func parseAddrOrDot(rs io.RuneScanner) (Address, error) {
 	}
 }
 
+// ParseRegexpEdit parses an optional, delimited regexp followed by an edit.
+// If the regexp is omitted, the empty string is returned.
+// If the edit is omitted, Set(Dot, '.') is returned.
+func parseRegexpEdit(rs io.RuneScanner) (string, Edit, error) {
+	if err := skipSpace(rs); err != nil {
+		return "", nil, err
+	}
+	var re string
+	switch delim, _, err := rs.ReadRune(); {
+	case err != nil && err != io.EOF:
+		return "", nil, err
+	case err == io.EOF:
+		return "", Set(Dot, '.'), nil
+	case delim == '\n':
+		return "", Set(Dot, '.'), rs.UnreadRune()
+	case unicode.IsLetter(delim):
+		// The regexp is omitted, and the edit begins at delim.
+		if err := rs.UnreadRune(); err != nil {
+			return "", nil, err
+		}
+	default:
+		if re, err = parseRegexp(delim, rs); err != nil {
+			return "", nil, err
+		}
+	}
+	edit, err := Ed(rs)
+	if err != nil {
+		return "", nil, err
+	}
+	return re, edit, nil
+}
+
 func parseText(rs io.RuneScanner) (string, error) {
 	for {
 		switch r, _, err := rs.ReadRune(); {
@@ -1166,6 +2354,13 @@ func parseLines(rs io.RuneScanner) (string, error) {
 // ParseDelimited returns the unescaped string of runes
 // up to the first non-escaped delimiter, raw newline, or EOF.
 func parseDelimited(delim rune, rs io.RuneScanner) (string, error) {
+	s, err := parseDelimitedRaw(delim, rs)
+	return Unescape(s), err
+}
+
+// ParseDelimitedRaw is like parseDelimited,
+// but the returned string is not unescaped.
+func parseDelimitedRaw(delim rune, rs io.RuneScanner) (string, error) {
 	var s []rune
 	var esc bool
 	for {
@@ -1173,9 +2368,9 @@ func parseDelimited(delim rune, rs io.RuneScanner) (string, error) {
 		case err != nil && err != io.EOF:
 			return "", err
 		case err == io.EOF || !esc && r == delim:
-			return Unescape(string(s)), nil
+			return string(s), nil
 		case r == '\n':
-			return Unescape(string(s)), rs.UnreadRune()
+			return string(s), rs.UnreadRune()
 		default:
 			s = append(s, r)
 			esc = !esc && r == '\\'
@@ -1248,6 +2443,36 @@ func parseMarkRune(rs io.RuneScanner) (rune, error) {
 	}
 }
 
+// ParseName parses and returns a non-empty sequence of non-whitespace runes.
+// Leading spaces are ignored.
+func parseName(rs io.RuneScanner) (string, error) {
+	if err := skipSpace(rs); err != nil {
+		return "", err
+	}
+	offs := offset(rs)
+	var s []rune
+	for {
+		r, _, err := rs.ReadRune()
+		switch {
+		case err == io.EOF:
+			r = -1
+		case err != nil:
+			return "", err
+		case !unicode.IsSpace(r):
+			s = append(s, r)
+			continue
+		default:
+			if err := rs.UnreadRune(); err != nil {
+				return "", err
+			}
+		}
+		if len(s) == 0 {
+			return "", parseErrorAt(offs, r, "a name", nil)
+		}
+		return string(s), nil
+	}
+}
+
 // ParseNumber parses and returns a positive integer.
 // Leading spaces are ignored.
 // If EOF is reached before any digits are encountered, 1 is returned.
@@ -1255,6 +2480,7 @@ func parseNumber(rs io.RuneScanner) (int, error) {
 	if err := skipSpace(rs); err != nil {
 		return 0, err
 	}
+	offs := offset(rs)
 	var s []rune
 	for {
 		switch r, _, err := rs.ReadRune(); {
@@ -1274,7 +2500,11 @@ func parseNumber(rs io.RuneScanner) (int, error) {
 		if len(s) == 0 {
 			return 1, nil
 		}
-		return strconv.Atoi(string(s))
+		n, err := strconv.Atoi(string(s))
+		if err != nil {
+			return 0, parseErrorAt(offs, s[0], "a number", err)
+		}
+		return n, nil
 	}
 }
 
@@ -1307,6 +2537,60 @@ func parseCmd(rs io.RuneScanner) (string, error) {
 	}
 }
 
+// ParseRedo parses the runes following an r command,
+// without an address, as either a redo or a read file command.
+// The r is a redo command if it is followed by
+// optional whitespace, an optional number,
+// optional whitespace, and a newline, a }, or EOF.
+// Otherwise, it is a read file command.
+func parseRedo(rs io.RuneScanner) (Edit, error) {
+	if err := skipSpace(rs); err != nil {
+		return nil, err
+	}
+	// Prefix is the digits and following whitespace,
+	// which begin the file name if this is a read file command.
+	offs := offset(rs)
+	var prefix []rune
+	var digits int
+	for {
+		r, _, err := rs.ReadRune()
+		switch {
+		case err == io.EOF:
+			return parseRedoCount(offs, prefix[:digits])
+		case err != nil:
+			return nil, err
+		case unicode.IsDigit(r) && digits == len(prefix):
+			digits++
+		case r == '\n' || r == '}':
+			if err := rs.UnreadRune(); err != nil {
+				return nil, err
+			}
+			return parseRedoCount(offs, prefix[:digits])
+		case !unicode.IsSpace(r):
+			if err := rs.UnreadRune(); err != nil {
+				return nil, err
+			}
+			name, err := parseCmd(rs)
+			if err != nil {
+				return nil, err
+			}
+			return ReadFile(Dot, string(prefix)+name), nil
+		}
+		prefix = append(prefix, r)
+	}
+}
+
+func parseRedoCount(offs int64, digits []rune) (Edit, error) {
+	if len(digits) == 0 {
+		return Redo(1), nil
+	}
+	n, err := strconv.Atoi(string(digits))
+	if err != nil {
+		return nil, parseErrorAt(offs, digits[0], "a number", err)
+	}
+	return Redo(n), nil
+}
+
 // SkipSpace consumes and ignores non-newline whitespace.
 // Terminates if a newline is encountered.
 // The terminating newline remains consumed.
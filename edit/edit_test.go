@@ -4,9 +4,15 @@ package edit
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -179,6 +185,8 @@ func TestEd(t *testing.T) {
 
 		{str: "=#", edit: Where(Dot)},
 		{str: "=#xyz", left: "xyz", edit: Where(Dot)},
+		{str: "=#b", edit: WhereBytes(Dot)},
+		{str: "#b1=#b", edit: WhereBytes(Byte(1))},
 		{str: "#1+1=#", edit: Where(Rune(1).Plus(Line(1)))},
 		{str: " #1 + 1 =#", edit: Where(Rune(1).Plus(Line(1)))},
 
@@ -188,6 +196,9 @@ func TestEd(t *testing.T) {
 		{str: "s/a//", edit: Sub(Dot, "a", "")},
 		{str: "s/a/\n/g", left: "\n/g", edit: Sub(Dot, "a", "")},
 		{str: `s/(.*)/a\1`, edit: Sub(Dot, "(.*)", "a1")},
+		{str: `s/(a)/\U$1\E-\#/g`, edit: SubGlobal(Dot, "(a)", `\U$1\E-\#`)},
+		{str: `s/a/\\U/`, edit: Sub(Dot, "a", `\U`)},
+		{str: `s/a/\\\\U/`, edit: Sub(Dot, "a", `\\U`)},
 		{str: ".s/a/b", edit: Sub(Dot, "a", "b")},
 		{str: "#1+1s/a/b", edit: Sub(Rune(1).Plus(Line(1)), "a", "b")},
 		{str: " #1 + 1 s/a/b", edit: Sub(Rune(1).Plus(Line(1)), "a", "b")},
@@ -226,6 +237,31 @@ func TestEd(t *testing.T) {
 		{str: "x//", edit: Loop(Dot, "", Set(Dot, '.'))},
 		{str: "x//\nd", left: "\nd", edit: Loop(Dot, "", Set(Dot, '.'))},
 
+		{str: "y/*", error: "missing"},
+		{str: ",y\n", left: "\n", edit: LoopBetween(All, "\n", Set(Dot, '.'))},
+		{str: ",y/abc/.d", edit: LoopBetween(All, "abc", Delete(Dot))},
+		{str: ",y//.d", edit: LoopBetween(All, "\n", Delete(Dot))},
+		{str: ",y/,/x/abc/d", edit: LoopBetween(All, ",", Loop(Dot, "abc", Delete(Dot)))},
+		{str: "y /abc/d", edit: LoopBetween(Dot, "abc", Delete(Dot))},
+		{str: "y", edit: LoopBetween(Dot, "", Set(Dot, '.'))},
+		{str: "y//\nd", left: "\nd", edit: LoopBetween(Dot, "", Set(Dot, '.'))},
+		{str: ",x d", edit: Loop(All, "", Delete(Dot))},
+		{str: ",x g/TODO/ d", edit: Loop(All, "", Guard(Dot, "TODO", Delete(Dot)))},
+
+		{str: "g/*", error: "missing"},
+		{str: "g/abc/d", edit: Guard(Dot, "abc", Delete(Dot))},
+		{str: "g /abc/ d", edit: Guard(Dot, "abc", Delete(Dot))},
+		{str: "g;abc;d", edit: Guard(Dot, "abc", Delete(Dot))},
+		{str: ",g/abc/.+1d", edit: Guard(All, "abc", Delete(Dot.Plus(Line(1))))},
+		{str: "g/abc/v/xyz/d", edit: Guard(Dot, "abc", GuardNot(Dot, "xyz", Delete(Dot)))},
+		{str: "g/abc/\nd", left: "\nd", edit: Guard(Dot, "abc", Set(Dot, '.'))},
+		{str: "g", edit: Guard(Dot, "", Set(Dot, '.'))},
+		{str: "v/*", error: "missing"},
+		{str: "v/abc/d", edit: GuardNot(Dot, "abc", Delete(Dot))},
+		{str: "v /abc/ d", edit: GuardNot(Dot, "abc", Delete(Dot))},
+		{str: ",v/abc/.+1d", edit: GuardNot(All, "abc", Delete(Dot.Plus(Line(1))))},
+		{str: "v", edit: GuardNot(Dot, "", Set(Dot, '.'))},
+
 		{str: "|cmd", edit: Pipe(Dot, "cmd")},
 		{str: "|	   cmd", edit: Pipe(Dot, "cmd")},
 		{str: "|cmd\nleft", left: "\nleft", edit: Pipe(Dot, "cmd")},
@@ -282,6 +318,89 @@ func TestEd(t *testing.T) {
 		{str: "r100", edit: Redo(100)},
 		{str: " r100", edit: Redo(100)},
 		{str: "r" + strconv.FormatInt(math.MaxInt64, 10) + "0", error: "value out of range"},
+		{str: "r\nxyz", left: "\nxyz", edit: Redo(1)},
+		{str: "r \nxyz", left: "\nxyz", edit: Redo(1)},
+		{str: "r 5", edit: Redo(5)},
+		{str: "r 5 \nxyz", left: "\nxyz", edit: Redo(5)},
+		{str: "r5\nxyz", left: "\nxyz", edit: Redo(5)},
+		{str: "r}", left: "}", edit: Redo(1)},
+		{str: "r2}", left: "}", edit: Redo(2)},
+		{str: "r 2 }", left: "}", edit: Redo(2)},
+		{str: "o", edit: SelectiveUndo(1)},
+		{str: " o", edit: SelectiveUndo(1)},
+		{str: "o1", edit: SelectiveUndo(1)},
+		{str: "o 100", edit: SelectiveUndo(100)},
+		{str: ",o", error: "unknown command"},
+		{str: "U", edit: UndoList()},
+		{str: " U", edit: UndoList()},
+		{str: "U\nxyz", left: "\nxyz", edit: UndoList()},
+		{str: "U0", edit: UndoTo(0)},
+		{str: "U3", edit: UndoTo(3)},
+		{str: " U 3", edit: UndoTo(3)},
+		{str: "U" + strconv.FormatInt(math.MaxInt64, 10) + "0", error: "value out of range"},
+		{str: ",U", error: "unknown command"},
+		{str: ",U3", error: "unknown command"},
+		{str: "U'a", edit: UndoToCheckpoint("a")},
+		{str: " U 'my-checkpoint\nxyz", left: "\nxyz", edit: UndoToCheckpoint("my-checkpoint")},
+		{str: "U' a", edit: UndoToCheckpoint("a")},
+		{str: "U'", error: "expected a name"},
+		{str: "U'\n", error: "expected a name"},
+		{str: "U@5", edit: UndoToSequence(5)},
+		{str: "U @ 5", edit: UndoToSequence(5)},
+		{str: "U@" + strconv.FormatInt(math.MaxInt64, 10) + "0", error: "value out of range"},
+		{str: ",U'a", error: "unknown command"},
+		{str: "K a", edit: Checkpoint("a")},
+		{str: "Kabc\nxyz", left: "\nxyz", edit: Checkpoint("abc")},
+		{str: "K 世界 xyz", left: " xyz", edit: Checkpoint("世界")},
+		{str: "K", error: "expected a name"},
+		{str: ",K a", error: "unknown command"},
+
+		{str: "e file", edit: EditFile("file")},
+		{str: "e  my file.txt\nxyz", left: "\nxyz", edit: EditFile("my file.txt")},
+		{str: "e", edit: EditFile("")},
+		{str: "e\n", left: "\n", edit: EditFile("")},
+		{str: `e a\nb`, edit: EditFile("a\nb")},
+		{str: ",e file", error: "address not allowed"},
+		{str: "r file", edit: ReadFile(Dot, "file")},
+		{str: "r  my file.txt\nxyz", left: "\nxyz", edit: ReadFile(Dot, "my file.txt")},
+		{str: "r 2019-notes.txt\n", left: "\n", edit: ReadFile(Dot, "2019-notes.txt")},
+		{str: "r 3file", edit: ReadFile(Dot, "3file")},
+		{str: "r3file", edit: ReadFile(Dot, "3file")},
+		{str: "rfile", edit: ReadFile(Dot, "file")},
+		{str: "r 12 3\nxyz", left: "\nxyz", edit: ReadFile(Dot, "12 3")},
+		{str: "r 1 \\nfile", edit: ReadFile(Dot, "1 \nfile")},
+		{str: "$r file", edit: ReadFile(End, "file")},
+		{str: "$r", edit: ReadFile(End, "")},
+		{str: "$r5", edit: ReadFile(End, "5")},
+		{str: "w file", edit: WriteFile(All, "file")},
+		{str: "w  my file.txt\nxyz", left: "\nxyz", edit: WriteFile(All, "my file.txt")},
+		{str: "w", edit: WriteFile(All, "")},
+		{str: ".w file", edit: WriteFile(Dot, "file")},
+		{str: "1,2w $file", edit: WriteFile(Line(1).To(Line(2)), "$file")},
+		{str: "f file", edit: File("file")},
+		{str: "f  my file.txt\nxyz", left: "\nxyz", edit: File("my file.txt")},
+		{str: "f", edit: File("")},
+		{str: "1f", error: "address not allowed"},
+		{str: "E latin1", edit: FileEncoding("latin1")},
+		{str: "E  utf-16le\nxyz", left: "\nxyz", edit: FileEncoding("utf-16le")},
+		{str: "E", edit: FileEncoding("")},
+		{str: "1E", error: "address not allowed"},
+		{str: "N crlf", edit: FileLineEnding("crlf")},
+		{str: "N  lf\nxyz", left: "\nxyz", edit: FileLineEnding("lf")},
+		{str: "N", edit: FileLineEnding("")},
+		{str: "1N", error: "address not allowed"},
+
+		{str: "X/*", error: "missing"},
+		{str: `X/\\.go$/,s/a/b/g`, edit: LoopFiles(`\.go$`, SubGlobal(All, "a", "b"))},
+		{str: "X /a/ w", edit: LoopFiles("a", WriteFile(All, ""))},
+		{str: "X w", edit: LoopFiles("", WriteFile(All, ""))},
+		{str: "X", edit: LoopFiles("", Set(Dot, '.'))},
+		{str: "X/a/\nd", left: "\nd", edit: LoopFiles("a", Set(Dot, '.'))},
+		{str: "Y/*", error: "missing"},
+		{str: `Y/\\.go$/,s/a/b/g`, edit: LoopFilesNot(`\.go$`, SubGlobal(All, "a", "b"))},
+		{str: "Y", edit: LoopFilesNot("", Set(Dot, '.'))},
+		{str: ",X/a/d", error: "address not allowed"},
+		{str: ",Y/a/d", error: "address not allowed"},
 
 		{str: "{bad edit}", error: "unknown"},
 		{str: "{}", edit: Block(Dot)},
@@ -292,6 +411,9 @@ func TestEd(t *testing.T) {
 		{str: "0,${.+1d}", edit: Block(All, Delete(Dot.Plus(Line(1))))},
 		{str: "0,${.+1d", edit: Block(All, Delete(Dot.Plus(Line(1))))},
 		{str: "0,${.d}abcxyz", left: "abcxyz", edit: Block(All, Delete(Dot))},
+		{str: "{r}", edit: Block(Dot, Redo(1))},
+		{str: "{r2}", edit: Block(Dot, Redo(2))},
+		{str: "{u\nr}", edit: Block(Dot, Undo(1), Redo(1))},
 		{
 			str: `0,${
 					.+1d
@@ -353,6 +475,35 @@ func TestEd(t *testing.T) {
 	}
 }
 
+func TestEdParseError(t *testing.T) {
+	tests := []struct {
+		str, error string
+		want       ParseError
+	}{
+		{str: "z", error: "unknown command", want: ParseError{Offset: 0, Rune: 'z', Err: ErrUnknownCommand}},
+		{str: "1,3z", error: "unknown command", want: ParseError{Offset: 3, Rune: 'z', Err: ErrUnknownCommand}},
+		{str: "{\n\tp\n\tΣ\n}", error: "unknown command", want: ParseError{Offset: 6, Rune: 'Σ', Err: ErrUnknownCommand}},
+		{str: "  1e foo", error: "address not allowed", want: ParseError{Offset: 3, Rune: 'e', Err: ErrAddressNotAllowed}},
+		{str: "s/(/x/", error: "missing closing", want: ParseError{Offset: 2, Rune: '(', Expected: "a valid regular expression"}},
+		{str: ",x/a/y/[/d", error: "missing closing", want: ParseError{Offset: 7, Rune: '[', Expected: "a valid regular expression"}},
+		{str: "u99999999999999999999", error: "out of range", want: ParseError{Offset: 1, Rune: '9', Expected: "a number"}},
+	}
+	for _, test := range tests {
+		_, err := Ed(strings.NewReader(test.str))
+		perr, ok := err.(*ParseError)
+		if !ok || !matchesError(test.error, err) {
+			t.Errorf("Ed(%q)=_,%v, want _,*ParseError matching %q", test.str, err, test.error)
+			continue
+		}
+		if test.want.Err == nil {
+			test.want.Err = perr.Err
+		}
+		if *perr != test.want {
+			t.Errorf("Ed(%q)=_,%#v, want _,%#v", test.str, *perr, test.want)
+		}
+	}
+}
+
 func TestEditString(t *testing.T) {
 	tests := []struct {
 		edit Edit
@@ -426,6 +577,7 @@ func TestEditString(t *testing.T) {
 		{Where(Dot), `.=#`},
 		{Where(Regexp("a*")), `/a*/=#`},
 		{Where(Regexp("/*")), `/\/*/=#`},
+		{WhereBytes(All), `0,$=#b`},
 
 		{WhereLine(All), `0,$=`},
 		{WhereLine(Dot), `.=`},
@@ -442,12 +594,48 @@ func TestEditString(t *testing.T) {
 		{Redo(0), "r1"},
 		{Redo(-4), "r1"},
 
+		{SelectiveUndo(1), "o1"},
+		{SelectiveUndo(2), "o2"},
+		{SelectiveUndo(0), "o1"},
+		{SelectiveUndo(-4), "o1"},
+
+		{UndoList(), "U"},
+		{UndoTo(0), "U0"},
+		{UndoTo(3), "U3"},
+		{UndoTo(-4), "U0"},
+		{UndoToCheckpoint("a"), "U'a"},
+		{UndoToSequence(5), "U@5"},
+		{UndoToSequence(-1), "U@0"},
+		{Checkpoint("a"), "K a"},
+
+		{EditFile("file"), "e file\n"},
+		{EditFile("my file.txt"), "e my file.txt\n"},
+		{EditFile("a\nb"), "e a\\nb\n"},
+		{EditFile(""), "e \n"},
+		{ReadFile(Dot, "file"), ".r file\n"},
+		{ReadFile(All, ""), "0,$r \n"},
+		{WriteFile(All, "file"), "0,$w file\n"},
+		{WriteFile(Regexp("/*"), "a b"), "/\\/*/w a b\n"},
+		{File("file"), "f file\n"},
+		{File(""), "f \n"},
+		{FileEncoding("utf-8"), "E utf-8\n"},
+		{FileEncoding(""), "E \n"},
+		{FileLineEnding("crlf"), "N crlf\n"},
+		{FileLineEnding(""), "N \n"},
+
+		{LoopFiles(`\.go$`, Delete(All)), `X/\\.go$/0,$d`},
+		{LoopFiles("/", Delete(All)), `X/\//0,$d`},
+		{LoopFilesNot(`\.go$`, Delete(All)), `Y/\\.go$/0,$d`},
+
 		{Sub(All, "a*", "b"), `0,$s/a*/b/`},
 		{Sub(All, "/*", "b"), `0,$s/\/*/b/`},
 		{Sub(All, "a*", "/"), `0,$s/a*/\//`},
 		{Sub(All, "\n*", "b"), `0,$s/\n*/b/`},
 		{Sub(All, "a*", "\n"), `0,$s/a*/\n/`},
 		{Sub(All, `(a*)bc`, `\1`), `0,$s/(a*)bc/\\1/`},
+		{SubGlobal(All, "(a)", `\U$1\E\L\#`), `0,$s/(a)/\U$1\E\L\#/g`},
+		{Sub(All, "a", `\\U\\#`), `0,$s/a/\\\\U\\\\#/`},
+		{Sub(All, "a", `\\\U`), `0,$s/a/\\\\\\U/`},
 
 		{SubGlobal(All, "a*", "b"), `0,$s/a*/b/g`},
 		{SubGlobal(All, "/*", "b"), `0,$s/\/*/b/g`},
@@ -466,6 +654,15 @@ func TestEditString(t *testing.T) {
 			Loop(All, "[a-zA-Z]*", Loop(Dot, "[a-z]*", Loop(Dot, "[abc]", Delete(Dot)))),
 			`0,$x/[a-zA-Z]*/.x/[a-z]*/.x/[abc]/.d`,
 		},
+
+		{LoopBetween(All, `\w*`, Delete(Dot)), `0,$y/\\w*/.d`},
+		{LoopBetween(All, "\n", Print(Dot)), `0,$y/\n/.p`},
+		{LoopBetween(All, "", Print(Dot)), `0,$y/\n/.p`},
+
+		{Guard(All, `\w*`, Delete(Dot)), `0,$g/\\w*/.d`},
+		{Guard(All, "/", Delete(Dot)), `0,$g/\//.d`},
+		{GuardNot(All, `\w*`, Delete(Dot)), `0,$v/\\w*/.d`},
+		{Loop(All, "", Guard(Dot, "a", GuardNot(Dot, "b", Delete(Dot)))), `0,$x/.*\n/.g/a/.v/b/.d`},
 	}
 	for _, test := range tests {
 		if s := test.edit.String(); s != test.str {
@@ -1078,6 +1275,20 @@ var whereTests = []editTest{
 		want:  "abc{.}xyz{.}",
 		print: "#3,#6\n",
 	},
+	{
+		name:  "where bytes point",
+		given: "{..}α世b",
+		do:    []Edit{WhereBytes(Rune(2))},
+		want:  "α世{..}b",
+		print: "#b5\n",
+	},
+	{
+		name:  "where bytes range",
+		given: "{..}α世b",
+		do:    []Edit{WhereBytes(Regexp("世b"))},
+		want:  "α{.}世b{.}",
+		print: "#b2,#b6\n",
+	},
 }
 
 func TestEditWhere(t *testing.T) {
@@ -1452,6 +1663,60 @@ var substituteTests = []editTest{
 		do:    []Edit{Sub(All, `abc`, `xyz\\n`)},
 		want:  `{.}xyz\\n{.}`,
 	},
+	{
+		name:  "upper case",
+		given: "{..}aabb",
+		do:    []Edit{Sub(All, "(a+)(b+)", `\U$1\E$2`)},
+		want:  "{.}AAbb{.}",
+	},
+	{
+		name:  "upper case to end",
+		given: "{..}aabb",
+		do:    []Edit{Sub(All, "(a+)(b+)", `$1-\U$2-x`)},
+		want:  "{.}aa-BB-X{.}",
+	},
+	{
+		name:  "lower case",
+		given: "{..}ABC DeF",
+		do:    []Edit{SubGlobal(All, `\w+`, `\L$0`)},
+		want:  "{.}abc def{.}",
+	},
+	{
+		name:  "upper then lower",
+		given: "{..}abc DEF",
+		do:    []Edit{Sub(All, `(\w+) (\w+)`, `\U$1 \L$2`)},
+		want:  "{.}ABC def{.}",
+	},
+	{
+		name:  "counter",
+		given: "{..}x x x",
+		do:    []Edit{SubGlobal(All, "x", `\#`)},
+		want:  "{.}1 2 3{.}",
+	},
+	{
+		name:  "counter not global",
+		given: "{..}x x x",
+		do:    []Edit{Sub(All, "x", `<\#>`)},
+		want:  "{.}<1> x x{.}",
+	},
+	{
+		name:  "counter from",
+		given: "{..}x x x",
+		do:    []Edit{Substitute{Address: All, Regexp: "x", With: `<\#>`, Global: true, From: 2}},
+		want:  "{.}x <1> <2>{.}",
+	},
+	{
+		name:  "counter empty matches",
+		given: "{..}abc",
+		do:    []Edit{SubGlobal(All, "b*", `\#`)},
+		want:  "{.}1a2c3{.}",
+	},
+	{
+		name:  "literal escapes",
+		given: "{..}abc",
+		do:    []Edit{Sub(All, "b", `\\U\\L\\E\\#`)},
+		want:  `{.}a\U\L\E\#c{.}`,
+	},
 }
 
 func TestEditSubstitute(t *testing.T) {
@@ -1466,6 +1731,99 @@ func TestEditSubstituteFromString(t *testing.T) {
 	}
 }
 
+func upper(subs []string) string { return strings.ToUpper(subs[0]) }
+
+var subFuncTests = []editTest{
+	{
+		name:  "out of range",
+		do:    []Edit{SubFunc(Rune(1), "a", upper)},
+		error: "out of range",
+	},
+	{
+		name:  "bad regexp",
+		do:    []Edit{SubstituteFunc{Address: Rune(0), Regexp: "*", With: upper}},
+		error: "missing",
+	},
+	{
+		name:  "empty buffer",
+		given: "{..}",
+		do:    []Edit{SubFunc(All, ".*", func([]string) string { return "abc" })},
+		want:  "{.}abc{.}",
+	},
+	{
+		name:  "not global",
+		given: "{.}abc abc{.}",
+		do:    []Edit{SubFunc(All, "abc", upper)},
+		want:  "{.}ABC abc{.}",
+	},
+	{
+		name:  "global",
+		given: "{.}abc abc{.}",
+		do:    []Edit{SubFuncGlobal(All, "abc", upper)},
+		want:  "{.}ABC ABC{.}",
+	},
+	{
+		name:  "from",
+		given: "{.}abc abc abc{.}",
+		do:    []Edit{SubstituteFunc{Address: All, Regexp: "abc", With: upper, Global: true, From: 2}},
+		want:  "{.}abc ABC ABC{.}",
+	},
+	{
+		name:  "empty matches",
+		given: "{.}abc{.}",
+		do:    []Edit{SubFuncGlobal(All, "b*", func([]string) string { return "-" })},
+		want:  "{.}-a-c-{.}",
+	},
+	{
+		name:  "renumber",
+		given: "{.}x x x{.}",
+		do: []Edit{SubFuncGlobal(All, "x", func() func([]string) string {
+			n := 0
+			return func([]string) string {
+				n++
+				return strconv.Itoa(n)
+			}
+		}())},
+		want: "{.}1 2 3{.}",
+	},
+	{
+		name:  "submatches",
+		given: "{.}a=世界, b={.}",
+		do: []Edit{SubFuncGlobal(All, `(\w)=(\pL*)(x)?`, func(subs []string) string {
+			return fmt.Sprintf("%q", subs)
+		})},
+		want: `{.}["a=世界" "a" "世界" ""], ["b=" "b" "" ""]{.}`,
+	},
+	{
+		name:  "sets dot",
+		given: "{..}abc abc",
+		do:    []Edit{SubFunc(Line(1), "c", upper)},
+		want:  "{.}abC abc{.}",
+	},
+}
+
+func TestEditSubstituteFunc(t *testing.T) {
+	for _, test := range subFuncTests {
+		test.run(t)
+	}
+}
+
+func TestSubstituteFuncString(t *testing.T) {
+	e := SubFuncGlobal(All, "a/b", upper)
+	if got, want := e.String(), `0,$@s/a\/b/func/g`; got != want {
+		t.Errorf("%v.String()=%q, want %q", e, got, want)
+	}
+	if _, err := Ed(strings.NewReader(e.String())); err == nil {
+		t.Errorf("Ed(%q)=_,nil, want error", e.String())
+	}
+	if _, err := e.(SubstituteFunc).MarshalText(); err != ErrSubstituteFunc {
+		t.Errorf("%v.MarshalText()=_,%v, want _,%v", e, err, ErrSubstituteFunc)
+	}
+	if _, err := json.Marshal(Loop(All, "x", e)); err == nil || !strings.Contains(err.Error(), ErrSubstituteFunc.Error()) {
+		t.Errorf("json.Marshal(%v)=_,%v, want _,%v", Loop(All, "x", e), err, ErrSubstituteFunc)
+	}
+}
+
 var loopTests = []editTest{
 	{
 		name:  "out of range",
@@ -1557,6 +1915,193 @@ func TestEditLoopFromString(t *testing.T) {
 	}
 }
 
+var loopBetweenTests = []editTest{
+	{
+		name:  "out of range",
+		do:    []Edit{LoopBetween(Rune(1), "a", Delete(Dot))},
+		error: "out of range",
+	},
+	{
+		name:  "bad regexp",
+		do:    []Edit{LoopBetween(Rune(0), "*", Delete(Dot))},
+		error: "missing",
+	},
+	{
+		name:  "empty buffer",
+		given: "{..}",
+		do:    []Edit{LoopBetween(Rune(0), ",", Append(Dot, "abc"))},
+		want:  "{.}abc{.}",
+	},
+	{
+		name:  "no matches",
+		given: "{..}abc",
+		do:    []Edit{LoopBetween(All, ",", Print(Dot))},
+		want:  "{.}abc{.}",
+		print: "abc",
+	},
+	{
+		name:  "loop where",
+		given: "{..}abc,xyz,,123",
+		do:    []Edit{LoopBetween(All, `,`, Where(Dot))},
+		want:  "abc,xyz,,{.}123{.}",
+		print: "#0,#3\n#4,#7\n#8\n#9,#12\n",
+	},
+	{
+		name:  "loop print lines",
+		given: "{..}abc\nxyz\n123",
+		do:    []Edit{LoopBetween(All, "", Print(Dot))},
+		want:  "abc\nxyz\n{.}123{.}",
+		print: "abcxyz123",
+	},
+	{
+		name:  "loop change",
+		given: "{..}abc<abc>abc<abcXYZabc>abcXYZabc<abc>abc",
+		do:    []Edit{LoopBetween(All, `<[^>]*>`, Change(Dot, "_"))},
+		want:  "_<abc>_<abcXYZabc>_<abc>{.}_{.}",
+	},
+	{
+		name:  "loop delete",
+		given: "{..}abc<abc>abc<abcXYZabc>abcXYZabc<abc>abc",
+		do:    []Edit{LoopBetween(All, `<[^>]*>`, Delete(Dot))},
+		want:  "<abc><abcXYZabc><abc>{..}",
+	},
+	{
+		name:  "loop subst",
+		given: "{..}abc,abc,abc",
+		do:    []Edit{LoopBetween(All, `,`, Sub(Dot, "b", "B"))},
+		want:  "aBc,aBc,{.}aBc{.}",
+	},
+	{
+		name:  "loop loop",
+		given: "{..}a1b2c3,d4e5f6",
+		do:    []Edit{LoopBetween(All, `,`, LoopBetween(Dot, `[0-9]`, Delete(Dot)))},
+		want:  "123,{.}456{.}",
+	},
+	{
+		name:  "loop address not all",
+		given: "{..}abc;x,y;123",
+		do:    []Edit{LoopBetween(Regexp(";").Then(Regexp(";")), `,`, Change(Dot, "_"))},
+		want:  "abc_,{.}_{.}123",
+	},
+}
+
+func TestEditLoopBetween(t *testing.T) {
+	for _, test := range loopBetweenTests {
+		test.run(t)
+	}
+}
+
+func TestEditLoopBetweenFromString(t *testing.T) {
+	for _, test := range loopBetweenTests {
+		test.runFromString(t)
+	}
+}
+
+var guardTests = []editTest{
+	{
+		name:  "out of range",
+		do:    []Edit{Guard(Rune(1), "a", Delete(Dot))},
+		error: "out of range",
+	},
+	{
+		name:  "bad regexp",
+		do:    []Edit{Guard(Rune(0), "*", Delete(Dot))},
+		error: "missing",
+	},
+	{
+		name:  "match",
+		given: "{..}abcxyz",
+		do:    []Edit{Guard(All, "c", Delete(Dot))},
+		want:  "{..}",
+	},
+	{
+		name:  "no match",
+		given: "{..}abcxyz",
+		do:    []Edit{Guard(All, "123", Delete(Dot))},
+		want:  "{.}abcxyz{.}",
+	},
+	{
+		name:  "match outside address",
+		given: "{..}abcxyz",
+		do:    []Edit{Guard(Regexp("xyz"), "c", Delete(Dot))},
+		want:  "abc{.}xyz{.}",
+	},
+	{
+		name:  "print",
+		given: "{..}abcxyz",
+		do:    []Edit{Guard(All, "^abc", Print(Dot))},
+		want:  "{.}abcxyz{.}",
+		print: "abcxyz",
+	},
+	{
+		name:  "filter lines",
+		given: "{..}a\nTODO b\nc\nd TODO\n",
+		do:    []Edit{Loop(All, "", Guard(Dot, "TODO", Delete(Dot)))},
+		want:  "a\nc\n{..}",
+	},
+	{
+		name:  "nested",
+		given: "{..}ab\nac\nbc\n",
+		do:    []Edit{Loop(All, "", Guard(Dot, "a", Guard(Dot, "b", Change(Dot, "_\n"))))},
+		want:  "_\nac\n{.}bc\n{.}",
+	},
+}
+
+func TestEditGuard(t *testing.T) {
+	for _, test := range guardTests {
+		test.run(t)
+	}
+}
+
+func TestEditGuardFromString(t *testing.T) {
+	for _, test := range guardTests {
+		test.runFromString(t)
+	}
+}
+
+var guardNotTests = []editTest{
+	{
+		name:  "out of range",
+		do:    []Edit{GuardNot(Rune(1), "a", Delete(Dot))},
+		error: "out of range",
+	},
+	{
+		name:  "bad regexp",
+		do:    []Edit{GuardNot(Rune(0), "*", Delete(Dot))},
+		error: "missing",
+	},
+	{
+		name:  "match",
+		given: "{..}abcxyz",
+		do:    []Edit{GuardNot(All, "c", Delete(Dot))},
+		want:  "{.}abcxyz{.}",
+	},
+	{
+		name:  "no match",
+		given: "{..}abcxyz",
+		do:    []Edit{GuardNot(All, "123", Delete(Dot))},
+		want:  "{..}",
+	},
+	{
+		name:  "filter lines",
+		given: "{..}a\nTODO b\nc\nd TODO\n",
+		do:    []Edit{Loop(All, "", GuardNot(Dot, "TODO", Delete(Dot)))},
+		want:  "TODO b\n{.}d TODO\n{.}",
+	},
+}
+
+func TestEditGuardNot(t *testing.T) {
+	for _, test := range guardNotTests {
+		test.run(t)
+	}
+}
+
+func TestEditGuardNotFromString(t *testing.T) {
+	for _, test := range guardNotTests {
+		test.runFromString(t)
+	}
+}
+
 var pipeFromTests = []editTest{
 	{
 		name:  "out of range",
@@ -1724,64 +2269,384 @@ func TestPipeDefaultShell(t *testing.T) {
 	}
 }
 
-var undoTests = []editTest{
-	{
-		name:  "empty undo 1",
-		given: "{..}",
-		do:    []Edit{Undo(1)},
-		want:  "{..}",
-	},
-	{
-		name:  "empty undo 100",
-		given: "{..}",
-		do:    []Edit{Undo(100)},
-		want:  "{..}",
-	},
-	{
-		name:  "1 append, undo 1",
-		given: "{..}",
-		do:    []Edit{Append(End, "abc"), Undo(1)},
-		want:  "{..}",
-	},
-	{
-		name:  "1 append, undo 0",
-		given: "{..}",
-		do:    []Edit{Append(End, "abc"), Undo(0)},
-		want:  "{..}",
-	},
-	{
-		name:  "1 append, undo -1",
-		given: "{..}",
-		do:    []Edit{Append(End, "abc"), Undo(-1)},
-		want:  "{..}",
-	},
-	{
-		name:  "2 appends, undo 1",
-		given: "{..}",
-		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), Undo(1)},
-		want:  "abc{..}",
-	},
-	{
-		name:  "2 appends, undo 2",
-		given: "{..}",
-		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), Undo(2)},
-		want:  "{..}",
-	},
-	{
-		name:  "2 appends, undo 3",
-		given: "{..}",
-		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), Undo(3)},
-		want:  "{..}",
-	},
-	{
-		name:  "1 delete, undo 1",
-		given: "{.}abc{.}",
-		do:    []Edit{Delete(All), Undo(1)},
-		want:  "{.}abc{.}",
-	},
-	{
-		name:  "2 deletes, undo 1",
-		given: "{.}abc{.}",
+// A printfEditor is a CommandEditor
+// that prints the command line of pipe edits.
+type printfEditor struct{ *Buffer }
+
+func (printfEditor) Command(line string) (*exec.Cmd, error) {
+	if line == "fail" {
+		return nil, errors.New("no commands")
+	}
+	return exec.Command("printf", "%s", line), nil
+}
+
+func TestPipeCommandEditor(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	ed := printfEditor{buf}
+	for _, e := range []Edit{Append(All, "a b"), Loop(All, "b", Pipe(Dot, "x"))} {
+		if err := e.Do(ed, ioutil.Discard); err != nil {
+			t.Fatalf("%q.Do(…)=%v, want nil", e, err)
+		}
+	}
+	if s := buf.String(); s != "a x" {
+		t.Errorf("text=%q, want %q", s, "a x")
+	}
+	if err := Loop(All, "a", PipeFrom(Dot, "fail")).Do(ed, ioutil.Discard); err == nil || err.Error() != "no commands" {
+		t.Errorf("Do(,x/a/<fail)=%v, want no commands", err)
+	}
+	if s := buf.String(); s != "a x" {
+		t.Errorf("text=%q, want %q", s, "a x")
+	}
+}
+
+type memFS map[string]string
+
+func (fs memFS) Open(name string) (io.ReadCloser, error) {
+	str, ok := fs[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(strings.NewReader(str)), nil
+}
+
+func (fs memFS) Create(name string) (io.WriteCloser, error) {
+	return &memFile{fs: fs, name: name}, nil
+}
+
+type memFile struct {
+	bytes.Buffer
+	fs   memFS
+	name string
+}
+
+func (f *memFile) Close() error {
+	f.fs[f.name] = f.String()
+	return nil
+}
+
+func TestEditFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// Files are the initial files.
+		files map[string]string
+		// FileName is the initial file name.
+		fileName string
+		given    string
+		do       []Edit
+		want     string
+		print    string
+		error    string
+		// WantFiles are the files changed by the edits.
+		wantFiles map[string]string
+		// WantFileName is the desired final file name.
+		wantFileName string
+	}{
+		{
+			name:  "e no file name",
+			do:    []Edit{EditFile("")},
+			error: ErrNoFileName.Error(),
+		},
+		{
+			name:  "e not exist",
+			do:    []Edit{EditFile("nothing")},
+			error: "not exist",
+		},
+		{
+			name:         "e",
+			files:        map[string]string{"my file": "Hello, 世界"},
+			given:        "{..}abc{m}xyz{m}",
+			do:           []Edit{EditFile("my file")},
+			want:         "{.}Hello, 世界{.m}{m}",
+			wantFileName: "my file",
+		},
+		{
+			name:         "e current file",
+			files:        map[string]string{"a": "Hello, 世界"},
+			fileName:     "a",
+			given:        "{..}abc",
+			do:           []Edit{EditFile("")},
+			want:         "{.}Hello, 世界{.}",
+			wantFileName: "a",
+		},
+		{
+			name:         "r",
+			files:        map[string]string{"a": "123"},
+			given:        "{..}abcxyz",
+			do:           []Edit{ReadFile(Regexp("c").To(Regexp("x")), "a")},
+			want:         "ab{.}123{.}yz",
+			wantFileName: "",
+		},
+		{
+			name:         "r current file",
+			files:        map[string]string{"a": "123"},
+			fileName:     "a",
+			given:        "abc{..}xyz",
+			do:           []Edit{ReadFile(Dot, "")},
+			want:         "abc{.}123{.}xyz",
+			wantFileName: "a",
+		},
+		{
+			name:  "r no file name",
+			do:    []Edit{ReadFile(Dot, "")},
+			error: ErrNoFileName.Error(),
+		},
+		{
+			name:  "r out of range",
+			files: map[string]string{"a": "123"},
+			do:    []Edit{ReadFile(Rune(1), "a")},
+			error: "out of range",
+		},
+		{
+			name:  "w no file name",
+			do:    []Edit{WriteFile(All, "")},
+			error: ErrNoFileName.Error(),
+		},
+		{
+			name:         "w",
+			given:        "{..}Hello, 世界",
+			do:           []Edit{WriteFile(All, "a")},
+			want:         "{.}Hello, 世界{.}",
+			wantFiles:    map[string]string{"a": "Hello, 世界"},
+			wantFileName: "a",
+		},
+		{
+			name:         "w keeps file name",
+			fileName:     "a",
+			given:        "{..}Hello, 世界",
+			do:           []Edit{WriteFile(Regexp("世界"), "b")},
+			want:         "Hello, {.}世界{.}",
+			wantFiles:    map[string]string{"b": "世界"},
+			wantFileName: "a",
+		},
+		{
+			name:         "w current file",
+			files:        map[string]string{"a": "old"},
+			fileName:     "a",
+			given:        "{..}Hello, 世界",
+			do:           []Edit{WriteFile(All, "")},
+			want:         "{.}Hello, 世界{.}",
+			wantFiles:    map[string]string{"a": "Hello, 世界"},
+			wantFileName: "a",
+		},
+		{
+			name:         "f print",
+			fileName:     "a",
+			do:           []Edit{File("")},
+			print:        "a\n",
+			wantFileName: "a",
+		},
+		{
+			name:         "f set",
+			fileName:     "a",
+			do:           []Edit{File("b c")},
+			print:        "b c\n",
+			wantFileName: "b c",
+		},
+		{
+			name:         "loop read",
+			files:        map[string]string{"a": "123"},
+			given:        "{..}x,x,x",
+			do:           []Edit{Loop(All, "x", ReadFile(Dot, "a"))},
+			want:         "123,123,{.}123{.}",
+			wantFileName: "",
+		},
+	}
+	for _, test := range tests {
+		files := make(memFS)
+		for k, v := range test.files {
+			files[k] = v
+		}
+		buf := newTestBuffer(test.given)
+		buf.SetFileSystem(files)
+		buf.SetFileName(test.fileName)
+		print := bytes.NewBuffer(nil)
+		for i, e := range test.do {
+			err := e.Do(buf, print)
+			if !matchesError(test.error, err) {
+				t.Errorf("%s: Do(do[%d]=%q)=%v, want %q", test.name, i, e, err, test.error)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if test.error == "" && !hasState(buf, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, stateString(buf), test.want)
+		}
+		if got := print.String(); got != test.print {
+			t.Errorf("%s: printed %q, want %q", test.name, got, test.print)
+		}
+		wantFiles := make(memFS)
+		for k, v := range test.files {
+			wantFiles[k] = v
+		}
+		for k, v := range test.wantFiles {
+			wantFiles[k] = v
+		}
+		if !reflect.DeepEqual(files, wantFiles) {
+			t.Errorf("%s: files=%v, want %v", test.name, files, wantFiles)
+		}
+		if test.error == "" && buf.FileName() != test.wantFileName {
+			t.Errorf("%s: file name=%q, want %q", test.name, buf.FileName(), test.wantFileName)
+		}
+		buf.Close()
+	}
+}
+
+func TestEditFileLoop(t *testing.T) {
+	tests := []struct {
+		fileName string
+		edit     Edit
+		want     string
+		error    string
+	}{
+		{fileName: "a.go", edit: LoopFiles(`\.go$`, Delete(All)), want: "{..}"},
+		{fileName: "a.c", edit: LoopFiles(`\.go$`, Delete(All)), want: "{..}abc"},
+		{fileName: "", edit: LoopFiles("", Delete(All)), want: "{..}"},
+		{fileName: "a.go", edit: LoopFilesNot(`\.go$`, Delete(All)), want: "{..}abc"},
+		{fileName: "a.c", edit: LoopFilesNot(`\.go$`, Delete(All)), want: "{..}"},
+		{fileName: "a.c", edit: LoopFiles("*", Delete(All)), error: "missing"},
+	}
+	for _, test := range tests {
+		buf := newTestBuffer("{..}abc")
+		buf.SetFileName(test.fileName)
+		err := test.edit.Do(buf, ioutil.Discard)
+		if !matchesError(test.error, err) {
+			t.Errorf("%q.Do(%q)=%v, want %q", test.edit, test.fileName, err, test.error)
+		}
+		if test.error == "" && !hasState(buf, test.want) {
+			t.Errorf("%q.Do(%q): got %q, want %q", test.edit, test.fileName, stateString(buf), test.want)
+		}
+		buf.Close()
+	}
+}
+
+// A syncEditor is a SyncEditor
+// that counts calls to Synced.
+type syncEditor struct {
+	*Buffer
+	synced int
+}
+
+func (ed *syncEditor) Synced() { ed.synced++ }
+
+func TestSyncEditor(t *testing.T) {
+	tests := []struct {
+		edit Edit
+		want int
+	}{
+		{edit: EditFile("a"), want: 1},
+		{edit: EditFile(""), want: 1},
+		{edit: WriteFile(All, ""), want: 1},
+		{edit: WriteFile(All, "a"), want: 1},
+		{edit: WriteFile(Line(1), ""), want: 0},
+		{edit: WriteFile(All, "b"), want: 0},
+		{edit: ReadFile(All, "a"), want: 0},
+		{edit: EditFile("notfound"), want: 0},
+		{edit: Block(All, EditFile("a")), want: 0},
+	}
+	for _, test := range tests {
+		buf := newTestBuffer("abc\nxyz\n")
+		buf.SetFileSystem(memFS{"a": "123"})
+		buf.SetFileName("a")
+		ed := &syncEditor{Buffer: buf}
+		test.edit.Do(ed, ioutil.Discard)
+		if ed.synced != test.want {
+			t.Errorf("%q.Do(…) called Synced %d times, want %d", test.edit, ed.synced, test.want)
+		}
+		buf.Close()
+	}
+}
+
+func TestEditFilesOS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edit_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a file; with $pecial\\ 'names'")
+
+	buf := newTestBuffer("{..}Hello, 世界")
+	defer buf.Close()
+	if err := WriteFile(All, path).Do(buf, ioutil.Discard); err != nil {
+		t.Fatalf("WriteFile(All, %q).Do(…)=%v, want nil", path, err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "Hello, 世界" {
+		t.Fatalf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "Hello, 世界")
+	}
+	if err := Change(All, "").Do(buf, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Ed(strings.NewReader("e " + path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Do(buf, ioutil.Discard); err != nil {
+		t.Fatalf("%q.Do(…)=%v, want nil", e, err)
+	}
+	if !hasState(buf, "{.}Hello, 世界{.}") {
+		t.Errorf("got %q, want %q", stateString(buf), "{.}Hello, 世界{.}")
+	}
+}
+
+var undoTests = []editTest{
+	{
+		name:  "empty undo 1",
+		given: "{..}",
+		do:    []Edit{Undo(1)},
+		want:  "{..}",
+	},
+	{
+		name:  "empty undo 100",
+		given: "{..}",
+		do:    []Edit{Undo(100)},
+		want:  "{..}",
+	},
+	{
+		name:  "1 append, undo 1",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Undo(1)},
+		want:  "{..}",
+	},
+	{
+		name:  "1 append, undo 0",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Undo(0)},
+		want:  "{..}",
+	},
+	{
+		name:  "1 append, undo -1",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Undo(-1)},
+		want:  "{..}",
+	},
+	{
+		name:  "2 appends, undo 1",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), Undo(1)},
+		want:  "abc{..}",
+	},
+	{
+		name:  "2 appends, undo 2",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), Undo(2)},
+		want:  "{..}",
+	},
+	{
+		name:  "2 appends, undo 3",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), Undo(3)},
+		want:  "{..}",
+	},
+	{
+		name:  "1 delete, undo 1",
+		given: "{.}abc{.}",
+		do:    []Edit{Delete(All), Undo(1)},
+		want:  "{.}abc{.}",
+	},
+	{
+		name:  "2 deletes, undo 1",
+		given: "{.}abc{.}",
 		do: []Edit{
 			Delete(Rune(2).To(End)),
 			Delete(Rune(1).To(End)),
@@ -2006,6 +2871,446 @@ func TestEditRedoFromString(t *testing.T) {
 	}
 }
 
+var undoTreeTests = []editTest{
+	{
+		name:  "undo to current",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), UndoTo(2)},
+		want:  "{.}abc{.}",
+	},
+	{
+		name:  "undo to root",
+		given: "{.}abc{.}",
+		do:    []Edit{Delete(All), UndoTo(0)},
+		want:  "{..}",
+	},
+	{
+		name:  "undo to ancestor",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), UndoTo(1)},
+		want:  "{..}",
+	},
+	{
+		name:  "undo to descendant",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), Undo(2), UndoTo(3)},
+		want:  "abc{.}xyz{.}",
+	},
+	{
+		name:  "no such state",
+		given: "{.}abc{.}",
+		do:    []Edit{UndoTo(100)},
+		want:  "{.}abc{.}",
+		error: "no such undo state",
+	},
+	{
+		name:  "new change keeps undone branch",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Undo(1), Append(End, "xyz"), UndoTo(2)},
+		want:  "{.}abc{.}",
+	},
+	{
+		name:  "redo follows new branch",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Undo(1), Append(End, "xyz"), Undo(1), Redo(1)},
+		want:  "{.}xyz{.}",
+	},
+	{
+		name:  "redo follows visited branch",
+		given: "{..}",
+		do: []Edit{
+			Append(End, "abc"),
+			Undo(1),
+			Append(End, "xyz"),
+			UndoTo(2),
+			Undo(1),
+			Redo(1),
+		},
+		want: "{.}abc{.}",
+	},
+	{
+		name:  "switch branches",
+		given: "{..}",
+		do: []Edit{
+			Append(End, "abc"),
+			Append(End, "def"),
+			Undo(2),
+			Append(End, "xyz"),
+			Append(End, "123"),
+			UndoTo(3),
+		},
+		want: "abc{.}def{.}",
+	},
+	{
+		name:  "switch multi-change branches",
+		given: "{..}a.a.a",
+		do: []Edit{
+			SubGlobal(All, "[.]", "z"),
+			Undo(1),
+			SubGlobal(All, "a", "b"),
+			UndoTo(2),
+		},
+		want: "a{.}zaz{.}a",
+	},
+	{
+		name:  "update marks",
+		given: "{..}{a}abc{a}",
+		do: []Edit{
+			Insert(Line(0), "xyz"),
+			Undo(1),
+			Append(End, "123"),
+			UndoTo(2),
+		},
+		want: "{.}xyz{.}{a}abc{a}",
+	},
+	{
+		name:  "list",
+		given: "{..}",
+		do: []Edit{
+			Append(End, "abc"),
+			Undo(1),
+			SubGlobal(All, "", "x"),
+			UndoList(),
+		},
+		print: "0 -1 0\n1 0 1\n2 1 1\n3 1 1 *\n",
+		want:  "{.}x{.}",
+	},
+	{
+		name:  "undo to checkpoint",
+		given: "{..}",
+		do: []Edit{
+			Append(End, "abc"),
+			Checkpoint("a"),
+			Append(End, "xyz"),
+			UndoToCheckpoint("a"),
+		},
+		want: "abc{..}",
+	},
+	{
+		name:  "undo to checkpoint on another branch",
+		given: "{..}",
+		do: []Edit{
+			Append(End, "abc"),
+			Checkpoint("a"),
+			Undo(1),
+			Append(End, "xyz"),
+			UndoToCheckpoint("a"),
+		},
+		want: "{.}abc{.}",
+	},
+	{
+		name:  "move checkpoint",
+		given: "{..}",
+		do: []Edit{
+			Checkpoint("a"),
+			Append(End, "abc"),
+			Checkpoint("a"),
+			Append(End, "xyz"),
+			UndoToCheckpoint("a"),
+		},
+		want: "abc{..}",
+	},
+	{
+		name:  "no such checkpoint",
+		given: "{.}abc{.}",
+		do:    []Edit{UndoToCheckpoint("b")},
+		want:  "{.}abc{.}",
+		error: "no such checkpoint",
+	},
+	{
+		name:  "list checkpoints",
+		given: "{..}",
+		do: []Edit{
+			Checkpoint("b"),
+			Checkpoint("a"),
+			Append(End, "abc"),
+			Checkpoint("c"),
+			UndoList(),
+		},
+		print: "0 -1 0\n1 0 1 'a 'b\n2 1 1 * 'c\n",
+		want:  "{.}abc{.}",
+	},
+	{
+		name:  "undo to sequence",
+		given: "{..}",
+		do: []Edit{
+			Append(End, "abc"),
+			Append(End, "xyz"),
+			Append(End, "123"),
+			UndoToSequence(2),
+		},
+		want: "abc{..}",
+	},
+	{
+		name:  "undo to sequence before undo",
+		given: "{..}",
+		do: []Edit{
+			Append(End, "abc"),
+			Append(End, "xyz"),
+			Undo(2),
+			Append(End, "123"),
+			UndoToSequence(3),
+		},
+		want: "abc{.}xyz{.}",
+	},
+	{
+		name:  "undo to sequence of undo",
+		given: "{..}",
+		do: []Edit{
+			Append(End, "abc"),
+			Append(End, "xyz"),
+			Undo(1),
+			Append(End, "123"),
+			UndoToSequence(4),
+		},
+		want: "abc{..}",
+	},
+	{
+		name:  "undo to sequence 0",
+		given: "{..}abc",
+		do:    []Edit{Append(End, "xyz"), UndoToSequence(0)},
+		want:  "{..}",
+	},
+	{
+		name:  "no such sequence",
+		given: "{.}abc{.}",
+		do:    []Edit{UndoToSequence(100)},
+		want:  "{.}abc{.}",
+		error: "no such undo state",
+	},
+}
+
+func TestEditUndoTree(t *testing.T) {
+	for _, test := range undoTreeTests {
+		test.run(t)
+	}
+}
+
+func TestEditUndoTreeFromString(t *testing.T) {
+	for _, test := range undoTreeTests {
+		test.runFromString(t)
+	}
+}
+
+var selectiveUndoTests = []editTest{
+	{
+		name:  "nothing to undo",
+		given: "{..}",
+		do:    []Edit{SelectiveUndo(100)},
+		want:  "{..}",
+	},
+	{
+		name:  "undo 1",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), SelectiveUndo(1)},
+		want:  "abc{..}",
+	},
+	{
+		name:  "undo 2",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), SelectiveUndo(2)},
+		want:  "{..}",
+	},
+	{
+		name:  "undo is a new state",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), SelectiveUndo(1), Undo(1)},
+		want:  "abc{.}xyz{.}",
+	},
+	{
+		name:  "skip undone changes",
+		given: "{..}",
+		do:    []Edit{Append(End, "abc"), Append(End, "xyz"), Undo(1), SelectiveUndo(1)},
+		want:  "{..}",
+	},
+	{
+		name:  "undo an earlier change",
+		given: "{..}123",
+		do: []Edit{
+			Change(Line(1), "abc"),
+			Append(End, "xyz"),
+			SelectiveUndo(1),
+			SelectiveUndo(1),
+		},
+		want: "{.}123{.}",
+	},
+	{
+		name:  "undo changes of the same text",
+		given: "{..}abc",
+		do: []Edit{
+			Change(Rune(1).To(Rune(2)), "X"),
+			Change(Rune(1).To(Rune(2)), "Y"),
+			SelectiveUndo(1),
+			SelectiveUndo(1),
+		},
+		want: "a{.}b{.}c",
+	},
+	{
+		name:  "undo 2 changes of the same text",
+		given: "{..}abc",
+		do: []Edit{
+			Change(Rune(1).To(Rune(2)), "X"),
+			Change(Rune(1).To(Rune(2)), "Y"),
+			Change(Rune(1).To(Rune(2)), "Z"),
+			SelectiveUndo(2),
+		},
+		want: "a{.}X{.}c",
+	},
+}
+
+func TestEditSelectiveUndo(t *testing.T) {
+	for _, test := range selectiveUndoTests {
+		test.run(t)
+	}
+}
+
+func TestEditSelectiveUndoFromString(t *testing.T) {
+	for _, test := range selectiveUndoTests {
+		test.runFromString(t)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	tests := []struct {
+		name  string
+		given string
+		do    []Edit
+		// Revert is the ID of the state to revert.
+		revert int
+		want   string
+		error  string
+	}{
+		{
+			name:   "no such state",
+			given:  "{..}",
+			revert: 100,
+			want:   "{..}",
+			error:  "no such undo state",
+		},
+		{
+			name:   "root",
+			given:  "{..}",
+			revert: 0,
+			want:   "{..}",
+			error:  "no such undo state",
+		},
+		{
+			name:   "undone state",
+			given:  "{..}",
+			do:     []Edit{Append(End, "abc"), Undo(1)},
+			revert: 2,
+			want:   "{..}",
+			error:  "no such undo state",
+		},
+		{
+			name:   "current state",
+			given:  "{..}",
+			do:     []Edit{Append(End, "abc")},
+			revert: 2,
+			want:   "{..}",
+		},
+		{
+			name:   "keep later insert before",
+			given:  "{..}",
+			do:     []Edit{Append(End, "abc"), Insert(Line(0), "xyz")},
+			revert: 2,
+			want:   "xyz{..}",
+		},
+		{
+			name:   "keep later insert after",
+			given:  "{..}",
+			do:     []Edit{Append(End, "abc"), Append(End, "xyz")},
+			revert: 2,
+			want:   "{..}xyz",
+		},
+		{
+			name:   "keep later delete",
+			given:  "{..}123",
+			do:     []Edit{Append(End, "abc"), Delete(Line(0).To(Rune(1)))},
+			revert: 2,
+			want:   "23{..}",
+		},
+		{
+			name:  "multiple changes",
+			given: "{..}a.a.a",
+			do: []Edit{
+				SubGlobal(All, "[.]", "zz"),
+				Insert(Line(0), "123"),
+				Append(End, "!"),
+			},
+			revert: 2,
+			want:   "123a{.}.a.{.}a!",
+		},
+		{
+			name:   "later change conflicts",
+			given:  "{..}",
+			do:     []Edit{Append(End, "abc"), Change(Rune(1).To(Rune(2)), "B")},
+			revert: 2,
+			want:   "a{.}B{.}c",
+			error:  "undo conflicts",
+		},
+		{
+			name:   "later change overlaps",
+			given:  "{..}123",
+			do:     []Edit{Append(End, "abc"), Delete(Rune(2).To(Rune(4)))},
+			revert: 2,
+			want:   "12{..}bc",
+			error:  "undo conflicts",
+		},
+		{
+			name:   "later change deletes reverted insert point",
+			given:  "{..}123",
+			do:     []Edit{Delete(Rune(1).To(Rune(2))), Delete(Line(0).To(End))},
+			revert: 2,
+			want:   "{..}",
+			error:  "undo conflicts",
+		},
+	}
+	for _, test := range tests {
+		buf := newTestBuffer(test.given)
+		for i, e := range test.do {
+			if err := e.Do(buf, ioutil.Discard); err != nil {
+				t.Fatalf("%s: Do(do[%d]=%q)=%v, want nil", test.name, i, e, err)
+			}
+		}
+		if err := buf.Revert(buf, test.revert); !matchesError(test.error, err) {
+			t.Errorf("%s: Revert(buf, %d)=%v, want %q", test.name, test.revert, err, test.error)
+		}
+		if !hasState(buf, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, stateString(buf), test.want)
+		}
+		buf.Close()
+	}
+}
+
+func TestUndoStates(t *testing.T) {
+	buf := newTestBuffer("{..}")
+	defer buf.Close()
+	for _, e := range []Edit{Append(End, "abc"), Undo(1), Append(End, "xyz"), Append(End, "123")} {
+		if err := e.Do(buf, ioutil.Discard); err != nil {
+			t.Fatalf("%q.Do(…)=%v, want nil", e, err)
+		}
+	}
+	states := buf.UndoStates()
+	wantParents := []int{-1, 0, 1, 1, 3}
+	if len(states) != len(wantParents) {
+		t.Fatalf("len(UndoStates())=%d, want %d", len(states), len(wantParents))
+	}
+	for i, s := range states {
+		if s.ID != i || s.Parent != wantParents[i] {
+			t.Errorf("UndoStates()[%d]={ID: %d, Parent: %d}, want {ID: %d, Parent: %d}",
+				i, s.ID, s.Parent, i, wantParents[i])
+		}
+		if s.Current != (i == 4) {
+			t.Errorf("UndoStates()[%d].Current=%v, want %v", i, s.Current, i == 4)
+		}
+		if i > 0 && s.Time.Before(states[i-1].Time) {
+			t.Errorf("UndoStates()[%d].Time=%v, before UndoStates()[%d].Time=%v",
+				i, s.Time, i-1, states[i-1].Time)
+		}
+	}
+}
+
 var blockTests = []editTest{
 	{
 		name:  "empty block",
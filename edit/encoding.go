@@ -0,0 +1,414 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// An Encoding is a character encoding of the bytes of a file.
+//
+// The zero Encoding, named auto, is not itself an encoding.
+// A file read by an Editor with the auto Encoding
+// is decoded using the Encoding detected by DetectEncoding,
+// and a file written by an Editor with the auto Encoding
+// is encoded as UTF-8.
+type Encoding string
+
+// The supported Encodings.
+// The BOM variants begin the file with a byte order mark.
+const (
+	AutoEncoding Encoding = ""
+	UTF8         Encoding = "utf-8"
+	UTF8BOM      Encoding = "utf-8-bom"
+	UTF16LE      Encoding = "utf-16le"
+	UTF16LEBOM   Encoding = "utf-16le-bom"
+	UTF16BE      Encoding = "utf-16be"
+	UTF16BEBOM   Encoding = "utf-16be-bom"
+	Latin1       Encoding = "iso-8859-1"
+)
+
+var encodingAliases = map[string]Encoding{
+	"auto":     AutoEncoding,
+	"utf8":     UTF8,
+	"utf8-bom": UTF8BOM,
+	"latin1":   Latin1,
+	"latin-1":  Latin1,
+}
+
+// ParseEncoding returns the Encoding with the given name.
+// Names are case insensitive.
+// In addition to the name of each Encoding,
+// auto names the zero Encoding,
+// utf8 is UTF-8, and latin1 is ISO-8859-1.
+func ParseEncoding(name string) (Encoding, error) {
+	name = strings.ToLower(name)
+	if _, ok := codecs[Encoding(name)]; ok {
+		return Encoding(name), nil
+	}
+	if e, ok := encodingAliases[name]; ok {
+		return e, nil
+	}
+	return AutoEncoding, errors.New("unknown encoding: " + name)
+}
+
+// String returns the name of the Encoding.
+func (e Encoding) String() string {
+	if e == AutoEncoding {
+		return "auto"
+	}
+	return string(e)
+}
+
+// A codec converts between runes and the bytes of an Encoding.
+type codec struct {
+	bom []byte
+
+	// Decode decodes the rune at the beginning of p,
+	// returning the rune, its size in bytes, and whether it is valid.
+	// If p is an incomplete encoding of a rune, the size is 0.
+	// If the encoding is invalid, the rune is utf8.RuneError.
+	decode func(p []byte) (rune, int, bool)
+
+	// Encode appends the encoding of a rune to p,
+	// or returns false if the rune cannot be encoded.
+	encode func(p []byte, r rune) ([]byte, bool)
+}
+
+var (
+	utf8Codec    = codec{decode: decodeUTF8, encode: encodeUTF8}
+	utf16LECodec = codec{decode: decodeUTF16(binary.LittleEndian), encode: encodeUTF16(binary.LittleEndian)}
+	utf16BECodec = codec{decode: decodeUTF16(binary.BigEndian), encode: encodeUTF16(binary.BigEndian)}
+
+	codecs = map[Encoding]codec{
+		UTF8:       utf8Codec,
+		UTF8BOM:    withBOM(utf8Codec, []byte{0xEF, 0xBB, 0xBF}),
+		UTF16LE:    utf16LECodec,
+		UTF16LEBOM: withBOM(utf16LECodec, []byte{0xFF, 0xFE}),
+		UTF16BE:    utf16BECodec,
+		UTF16BEBOM: withBOM(utf16BECodec, []byte{0xFE, 0xFF}),
+		Latin1:     codec{decode: decodeLatin1, encode: encodeLatin1},
+	}
+)
+
+func withBOM(c codec, bom []byte) codec {
+	c.bom = bom
+	return c
+}
+
+func (e Encoding) codec() codec {
+	if c, ok := codecs[e]; ok {
+		return c
+	}
+	return utf8Codec
+}
+
+func decodeUTF8(p []byte) (rune, int, bool) {
+	if !utf8.FullRune(p) {
+		return 0, 0, false
+	}
+	r, n := utf8.DecodeRune(p)
+	return r, n, r != utf8.RuneError || n > 1
+}
+
+func encodeUTF8(p []byte, r rune) ([]byte, bool) {
+	var b [utf8.UTFMax]byte
+	n := utf8.EncodeRune(b[:], r)
+	return append(p, b[:n]...), true
+}
+
+func decodeLatin1(p []byte) (rune, int, bool) { return rune(p[0]), 1, true }
+
+func encodeLatin1(p []byte, r rune) ([]byte, bool) {
+	if r < 0 || r > 0xFF {
+		return p, false
+	}
+	return append(p, byte(r)), true
+}
+
+func decodeUTF16(order binary.ByteOrder) func([]byte) (rune, int, bool) {
+	return func(p []byte) (rune, int, bool) {
+		if len(p) < 2 {
+			return 0, 0, false
+		}
+		u := rune(order.Uint16(p))
+		switch {
+		case !utf16.IsSurrogate(u):
+			return u, 2, true
+		case u >= 0xDC00:
+			// A low surrogate without a preceding high surrogate.
+			return utf8.RuneError, 2, false
+		case len(p) < 4:
+			return 0, 0, false
+		}
+		r := utf16.DecodeRune(u, rune(order.Uint16(p[2:])))
+		if r == utf8.RuneError {
+			return r, 2, false
+		}
+		return r, 4, true
+	}
+}
+
+func encodeUTF16(order binary.ByteOrder) func([]byte, rune) ([]byte, bool) {
+	return func(p []byte, r rune) ([]byte, bool) {
+		var b [4]byte
+		switch r1, r2 := utf16.EncodeRune(r); {
+		case r1 != utf8.RuneError || r2 != utf8.RuneError:
+			order.PutUint16(b[:], uint16(r1))
+			order.PutUint16(b[2:], uint16(r2))
+			return append(p, b[:4]...), true
+		case r < 0 || r > 0xFFFF || utf16.IsSurrogate(r):
+			return p, false
+		default:
+			order.PutUint16(b[:], uint16(r))
+			return append(p, b[:2]...), true
+		}
+	}
+}
+
+// NewDecoder returns an io.Reader
+// that reads the bytes of r in the Encoding,
+// and returns them encoded as UTF-8.
+// A byte order mark at the beginning of r is not returned,
+// and an invalid encoding is returned as utf8.RuneError.
+// The auto Encoding is decoded as UTF-8.
+func (e Encoding) NewDecoder(r io.Reader) io.Reader {
+	if e == AutoEncoding || e == UTF8 {
+		return r
+	}
+	return &decoder{codec: e.codec(), r: r}
+}
+
+type decoder struct {
+	codec
+	r io.Reader
+	// In is the undecoded input, and out is the decoded output.
+	in, out []byte
+	// Err is the error from reading r.
+	err     error
+	bomDone bool
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		var buf [4096]byte
+		n, err := d.r.Read(buf[:])
+		d.in = append(d.in, buf[:n]...)
+		d.err = err
+		if !d.bomDone {
+			if len(d.in) < len(d.bom) && d.err == nil {
+				continue
+			}
+			d.in = bytes.TrimPrefix(d.in, d.bom)
+			d.bomDone = true
+		}
+		d.convert()
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// Convert decodes the input to the output.
+// An incomplete rune at the end of the input is left
+// unless there is no more input.
+func (d *decoder) convert() {
+	var i int
+	for i < len(d.in) {
+		r, n, _ := d.codec.decode(d.in[i:])
+		if n == 0 {
+			if d.err == nil {
+				break
+			}
+			r, n = utf8.RuneError, len(d.in)-i
+		}
+		d.out, _ = encodeUTF8(d.out, r)
+		i += n
+	}
+	d.in = append(d.in[:0], d.in[i:]...)
+}
+
+// NewEncoder returns an io.WriteCloser
+// that writes the UTF-8 bytes written to it to w in the Encoding.
+// Writing a rune that cannot be encoded is an error.
+// The byte order mark, if any, is written by the first Write or by Close.
+// Close does not close w,
+// but it must be called to complete the encoding.
+// The auto Encoding is encoded as UTF-8.
+func (e Encoding) NewEncoder(w io.Writer) io.WriteCloser {
+	return &encoder{codec: e.codec(), e: e, w: w}
+}
+
+type encoder struct {
+	codec
+	e Encoding
+	w io.Writer
+	// Partial is an incomplete UTF-8 encoding
+	// at the end of the last Write.
+	partial []byte
+	bomDone bool
+}
+
+func (enc *encoder) Write(p []byte) (int, error) {
+	var out []byte
+	if !enc.bomDone {
+		out = append(out, enc.bom...)
+		enc.bomDone = true
+	}
+	in := append(enc.partial, p...)
+	for len(in) > 0 && utf8.FullRune(in) {
+		r, n := utf8.DecodeRune(in)
+		var ok bool
+		if out, ok = enc.encode(out, r); !ok {
+			return 0, errors.New("cannot encode " + strconv.QuoteRune(r) + " in " + enc.e.String())
+		}
+		in = in[n:]
+	}
+	enc.partial = append(enc.partial[:0], in...)
+	if _, err := enc.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (enc *encoder) Close() error {
+	if !enc.bomDone {
+		enc.bomDone = true
+		if _, err := enc.w.Write(enc.bom); err != nil {
+			return err
+		}
+	}
+	if len(enc.partial) > 0 {
+		return errors.New("incomplete UTF-8 encoding")
+	}
+	return nil
+}
+
+// DetectEncoding returns the Encoding of the bytes read from r.
+//
+// The Encoding is one in which the bytes are valid,
+// so decoding and re-encoding them gives the original bytes.
+// It is chosen by the first match of:
+// a byte order mark;
+// UTF-16 without a byte order mark,
+// if most units have a high byte of zero;
+// UTF-8;
+// or ISO-8859-1, in which any bytes are valid.
+// The Encoding of empty input is UTF-8.
+func DetectEncoding(r io.Reader) (Encoding, error) {
+	var cands []*candidate
+	var zeros [2]int64
+	var size int64
+	var head []byte
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		p := buf[:n]
+		if cands == nil {
+			if head = append(head, p...); len(head) < 3 && err == nil {
+				continue
+			}
+			cands = candidates(head)
+			p = head
+		}
+		for _, c := range cands {
+			c.feed(p)
+		}
+		for _, b := range p {
+			if b == 0 {
+				zeros[size%2]++
+			}
+			size++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return AutoEncoding, err
+		}
+	}
+	units := size / 2
+	for _, c := range cands {
+		if !c.valid || len(c.carry) > 0 {
+			continue
+		}
+		switch c.e {
+		case UTF16LE:
+			if size%2 != 0 || zeros[1] <= zeros[0] || 2*zeros[1] < units {
+				continue
+			}
+		case UTF16BE:
+			if size%2 != 0 || zeros[0] <= zeros[1] || 2*zeros[0] < units {
+				continue
+			}
+		}
+		return c.e, nil
+	}
+	return Latin1, nil
+}
+
+// A candidate is an Encoding being checked by DetectEncoding.
+type candidate struct {
+	e     Encoding
+	valid bool
+	// Carry is an incomplete rune at the end of the last input.
+	carry []byte
+	// Skip is the number of bytes of the BOM yet to skip.
+	skip int
+}
+
+// Candidates returns the candidate Encodings,
+// in order of preference,
+// given the first bytes of the input.
+func candidates(head []byte) []*candidate {
+	var cands []*candidate
+	for _, e := range []Encoding{UTF8BOM, UTF16LEBOM, UTF16BEBOM} {
+		if bom := codecs[e].bom; bytes.HasPrefix(head, bom) {
+			cands = append(cands, &candidate{e: e, valid: true, skip: len(bom)})
+		}
+	}
+	if len(cands) > 0 {
+		return cands
+	}
+	for _, e := range []Encoding{UTF16LE, UTF16BE, UTF8} {
+		cands = append(cands, &candidate{e: e, valid: true})
+	}
+	return cands
+}
+
+func (c *candidate) feed(p []byte) {
+	if c.skip > 0 {
+		n := c.skip
+		if n > len(p) {
+			n = len(p)
+		}
+		p, c.skip = p[n:], c.skip-n
+	}
+	if !c.valid {
+		return
+	}
+	in := append(c.carry, p...)
+	decode := codecs[c.e].decode
+	for len(in) > 0 {
+		_, n, ok := decode(in)
+		if n == 0 {
+			break
+		}
+		if !ok {
+			c.valid = false
+			return
+		}
+		in = in[n:]
+	}
+	c.carry = append(c.carry[:0], in...)
+}
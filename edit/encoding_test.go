@@ -0,0 +1,330 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var encodingTests = []struct {
+	name string
+	enc  Encoding
+	text string
+	data string
+}{
+	{name: "utf-8", enc: UTF8, text: "Hello, 世界", data: "Hello, 世界"},
+	{name: "utf-8 bom", enc: UTF8BOM, text: "Hello, 世界", data: "\xEF\xBB\xBFHello, 世界"},
+	{name: "utf-16le", enc: UTF16LE, text: "Hi☺", data: "H\x00i\x00\x3A\x26"},
+	{name: "utf-16be", enc: UTF16BE, text: "Hi☺", data: "\x00H\x00i\x26\x3A"},
+	{name: "utf-16le bom", enc: UTF16LEBOM, text: "a😀", data: "\xFF\xFEa\x00\x3D\xD8\x00\xDE"},
+	{name: "utf-16be bom", enc: UTF16BEBOM, text: "a😀", data: "\xFE\xFF\x00a\xD8\x3D\xDE\x00"},
+	{name: "latin1", enc: Latin1, text: "café ÿ", data: "caf\xE9 \xFF"},
+	{name: "empty utf-8 bom", enc: UTF8BOM, text: "", data: "\xEF\xBB\xBF"},
+	{name: "empty utf-16le bom", enc: UTF16LEBOM, text: "", data: "\xFF\xFE"},
+}
+
+func TestEncodingDecode(t *testing.T) {
+	for _, test := range encodingTests {
+		r := test.enc.NewDecoder(iotest.OneByteReader(strings.NewReader(test.data)))
+		text, err := ioutil.ReadAll(r)
+		if err != nil || string(text) != test.text {
+			t.Errorf("%s: decoded %q,%v, want %q,nil", test.name, text, err, test.text)
+		}
+	}
+}
+
+func TestEncodingEncode(t *testing.T) {
+	for _, test := range encodingTests {
+		var data bytes.Buffer
+		w := test.enc.NewEncoder(&data)
+		for _, b := range []byte(test.text) {
+			if _, err := w.Write([]byte{b}); err != nil {
+				t.Fatalf("%s: Write(…)=_,%v, want _,nil", test.name, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: Close()=%v, want nil", test.name, err)
+		}
+		if data.String() != test.data {
+			t.Errorf("%s: encoded %q, want %q", test.name, data.String(), test.data)
+		}
+	}
+}
+
+func TestEncodingEncodeError(t *testing.T) {
+	w := Latin1.NewEncoder(ioutil.Discard)
+	if _, err := w.Write([]byte("世界")); err == nil || !strings.Contains(err.Error(), "cannot encode") {
+		t.Errorf("Latin1 Write(世界)=_,%v, want _,cannot encode", err)
+	}
+	w = UTF8.NewEncoder(ioutil.Discard)
+	if _, err := w.Write([]byte("世界")[:2]); err != nil {
+		t.Fatalf("Write(…)=_,%v, want _,nil", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Errorf("Close() after a partial rune=nil, want error")
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		data string
+		want Encoding
+	}{
+		{data: "", want: UTF8},
+		{data: "a", want: UTF8},
+		{data: "Hello, 世界\n", want: UTF8},
+		{data: "\xEF\xBB\xBFHello, 世界\n", want: UTF8BOM},
+		{data: "\xEF\xBB\xBF", want: UTF8BOM},
+		{data: "\xEF\xBB\xBFcaf\xE9", want: Latin1},
+		{data: "\xFF\xFEH\x00i\x00", want: UTF16LEBOM},
+		{data: "\xFE\xFF\x00H\x00i", want: UTF16BEBOM},
+		{data: "\xFF\xFE\x00\xDE", want: Latin1}, // unpaired low surrogate
+		{data: "\xFF\xFEH\x00i", want: Latin1},   // odd length
+		{data: "H\x00i\x00\n\x00", want: UTF16LE},
+		{data: "\x00H\x00i\x00\n", want: UTF16BE},
+		{data: "caf\xE9\n", want: Latin1},
+		{data: "caf\xC3", want: Latin1}, // truncated UTF-8
+		{data: "\x00\x00", want: UTF8},
+		{data: strings.Repeat("Hello, 世界\n", 10000) + "\xE9", want: Latin1},
+	}
+	for _, test := range tests {
+		enc, err := DetectEncoding(iotest.HalfReader(strings.NewReader(test.data)))
+		if err != nil || enc != test.want {
+			t.Errorf("DetectEncoding(%.20q)=%v,%v, want %v,nil", test.data, enc, err, test.want)
+		}
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		want  Encoding
+		error string
+	}{
+		{name: "auto", want: AutoEncoding},
+		{name: "utf-8", want: UTF8},
+		{name: "UTF8", want: UTF8},
+		{name: "utf-16le-bom", want: UTF16LEBOM},
+		{name: "Latin1", want: Latin1},
+		{name: "iso-8859-1", want: Latin1},
+		{name: "ebcdic", error: "unknown encoding"},
+	}
+	for _, test := range tests {
+		enc, err := ParseEncoding(test.name)
+		if !matchesError(test.error, err) || test.error == "" && enc != test.want {
+			t.Errorf("ParseEncoding(%q)=%v,%v, want %v,%q", test.name, enc, err, test.want, test.error)
+		}
+	}
+}
+
+func TestEditFileEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		fileName string
+		encoding Encoding
+		do       []Edit
+		want     string
+		print    string
+		error    string
+		// WantFiles are the files that differ from files.
+		wantFiles    map[string]string
+		wantEncoding Encoding
+	}{
+		{
+			name:         "e detect",
+			files:        map[string]string{"a": "\xFF\xFEH\x00i\x00"},
+			do:           []Edit{EditFile("a")},
+			want:         "{.}Hi{.}",
+			wantEncoding: UTF16LEBOM,
+		},
+		{
+			name:         "e round trip",
+			files:        map[string]string{"a": "\xFF\xFEH\x00i\x00"},
+			do:           []Edit{EditFile("a"), WriteFile(All, "")},
+			want:         "{.}Hi{.}",
+			wantEncoding: UTF16LEBOM,
+		},
+		{
+			name:         "e round trip latin1",
+			files:        map[string]string{"a": "caf\xE9\n"},
+			do:           []Edit{EditFile("a"), WriteFile(All, "")},
+			want:         "{.}café\n{.}",
+			wantEncoding: Latin1,
+		},
+		{
+			name:         "e round trip invalid utf-16",
+			files:        map[string]string{"a": "\xFF\xFE\x00\xDE"},
+			do:           []Edit{EditFile("a"), WriteFile(All, "")},
+			want:         "{.}ÿþ\x00Þ{.}",
+			wantEncoding: Latin1,
+		},
+		{
+			name:         "e explicit encoding",
+			files:        map[string]string{"a": "caf\xC3\xA9"},
+			fileName:     "a",
+			encoding:     Latin1,
+			do:           []Edit{EditFile("")},
+			want:         "{.}cafÃ©{.}",
+			wantEncoding: Latin1,
+		},
+		{
+			name:         "e other file detects",
+			files:        map[string]string{"a": "x", "b": "caf\xC3\xA9"},
+			fileName:     "a",
+			encoding:     Latin1,
+			do:           []Edit{EditFile("b")},
+			want:         "{.}café{.}",
+			wantEncoding: UTF8,
+		},
+		{
+			name:         "E then w converts",
+			files:        map[string]string{"a": "caf\xE9"},
+			do:           []Edit{EditFile("a"), FileEncoding("utf-16be"), WriteFile(All, "b")},
+			want:         "{.}café{.}",
+			print:        "utf-16be\n",
+			wantFiles:    map[string]string{"b": "\x00c\x00a\x00f\x00\xE9"},
+			wantEncoding: UTF16BE,
+		},
+		{
+			name:         "E print",
+			encoding:     UTF8BOM,
+			do:           []Edit{FileEncoding("")},
+			print:        "utf-8-bom\n",
+			wantEncoding: UTF8BOM,
+		},
+		{
+			name:         "E auto",
+			encoding:     UTF8BOM,
+			do:           []Edit{FileEncoding("auto")},
+			print:        "auto\n",
+			wantEncoding: AutoEncoding,
+		},
+		{
+			name:         "E unknown",
+			encoding:     Latin1,
+			do:           []Edit{FileEncoding("zzz")},
+			error:        "unknown encoding",
+			wantEncoding: Latin1,
+		},
+		{
+			name:         "r detects",
+			files:        map[string]string{"a": "\xFE\xFF\x00H\x00i"},
+			encoding:     Latin1,
+			do:           []Edit{ReadFile(All, "a")},
+			want:         "{.}Hi{.}",
+			wantEncoding: Latin1,
+		},
+		{
+			name:         "w auto is utf-8",
+			do:           []Edit{Append(All, "世界"), WriteFile(All, "a")},
+			want:         "{.}世界{.}",
+			wantFiles:    map[string]string{"a": "世界"},
+			wantEncoding: AutoEncoding,
+		},
+		{
+			name:         "w unencodable keeps file",
+			files:        map[string]string{"a": "caf\xE9"},
+			do:           []Edit{EditFile("a"), Append(End, "5€"), WriteFile(All, "")},
+			error:        "cannot encode",
+			wantEncoding: Latin1,
+		},
+		{
+			name:         "w unencodable",
+			encoding:     Latin1,
+			do:           []Edit{Append(All, "世界"), WriteFile(All, "a")},
+			error:        "cannot encode",
+			wantEncoding: Latin1,
+		},
+	}
+	for _, test := range tests {
+		files := make(memFS)
+		wantFiles := make(memFS)
+		for k, v := range test.files {
+			files[k] = v
+			wantFiles[k] = v
+		}
+		for k, v := range test.wantFiles {
+			wantFiles[k] = v
+		}
+		buf := NewBuffer()
+		buf.SetFileSystem(files)
+		buf.SetFileName(test.fileName)
+		buf.SetEncoding(test.encoding)
+		print := bytes.NewBuffer(nil)
+		var err error
+		for _, e := range test.do {
+			if err = e.Do(buf, print); err != nil {
+				break
+			}
+		}
+		if !matchesError(test.error, err) {
+			t.Errorf("%s: Do(…)=%v, want %q", test.name, err, test.error)
+		}
+		if test.error == "" && test.want != "" && !hasState(buf, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, stateString(buf), test.want)
+		}
+		if got := print.String(); got != test.print {
+			t.Errorf("%s: printed %q, want %q", test.name, got, test.print)
+		}
+		for k, v := range wantFiles {
+			if files[k] != v {
+				t.Errorf("%s: file %q=%q, want %q", test.name, k, files[k], v)
+			}
+		}
+		if enc := buf.Encoding(); enc != test.wantEncoding {
+			t.Errorf("%s: encoding=%v, want %v", test.name, enc, test.wantEncoding)
+		}
+		buf.Close()
+	}
+}
+
+func TestWriteFileEncodeError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edit_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "latin1.txt")
+	if err := ioutil.WriteFile(path, []byte("caf\xE9\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := NewBuffer()
+	defer buf.Close()
+	if err := EditFile(path).Do(buf, ioutil.Discard); err != nil {
+		t.Fatalf("EditFile(%q).Do(…)=%v, want nil", path, err)
+	}
+	if err := Append(End, "5€").Do(buf, ioutil.Discard); err != nil {
+		t.Fatalf("Append(…).Do(…)=%v, want nil", err)
+	}
+	if err := WriteFile(All, "").Do(buf, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "cannot encode") {
+		t.Errorf("WriteFile(…).Do(…)=%v, want cannot encode", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "caf\xE9\n" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "caf\xE9\n")
+	}
+
+	if err := Change(Line(2), "5$").Do(buf, ioutil.Discard); err != nil {
+		t.Fatalf("Change(…).Do(…)=%v, want nil", err)
+	}
+	if err := WriteFile(All, "").Do(buf, ioutil.Discard); err != nil {
+		t.Errorf("WriteFile(…).Do(…)=%v, want nil", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "caf\xE9\n5$" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "caf\xE9\n5$")
+	}
+	// The file keeps its permissions, and no temporary file is left.
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("os.Stat(%q)=%v,%v, want mode 0600", path, fi, err)
+	}
+	if fis, err := ioutil.ReadDir(dir); err != nil || len(fis) != 1 {
+		t.Errorf("ioutil.ReadDir(%q)=%v,%v, want 1 file", dir, fis, err)
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HistoryVersion is the version of the undo history format
+// written by WriteHistory.
+const HistoryVersion = 3
+
+var (
+	// ErrBadHistory indicates a malformed or corrupt undo history.
+	ErrBadHistory = errors.New("bad undo history")
+
+	// ErrStaleHistory indicates an undo history
+	// that was saved for text different from the current text.
+	ErrStaleHistory = errors.New("stale undo history")
+)
+
+// historyMagic begins every undo history.
+var historyMagic = []byte("T undo\n")
+
+// HistoryFileName returns the name of the file
+// in which SaveHistory saves the undo history
+// of the text saved in the named file.
+// It is a hidden file in the same directory:
+// the base name of the file, preceded by . and followed by .undo.
+func HistoryFileName(name string) string {
+	dir, base := filepath.Split(name)
+	return filepath.Join(dir, "."+base+".undo")
+}
+
+// SaveHistory saves the undo history of the Buffer
+// to the history file of the Buffer's file name,
+// as named by HistoryFileName,
+// using the Buffer's FileSystem.
+// The history should be saved after saving the text,
+// since LoadHistory only accepts a history
+// that matches the text at the time it was saved.
+//
+// If the Buffer has no file name, ErrNoFileName is returned.
+func (buf *Buffer) SaveHistory() error {
+	if buf.FileName() == "" {
+		return ErrNoFileName
+	}
+	f, err := buf.FileSystem().Create(HistoryFileName(buf.FileName()))
+	if err != nil {
+		return err
+	}
+	if err := buf.WriteHistory(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadHistory loads the undo history of the Buffer
+// from the history file of the Buffer's file name,
+// as named by HistoryFileName,
+// using the Buffer's FileSystem.
+// See ReadHistory.
+//
+// If the Buffer has no file name, ErrNoFileName is returned.
+func (buf *Buffer) LoadHistory() error {
+	if buf.FileName() == "" {
+		return ErrNoFileName
+	}
+	f, err := buf.FileSystem().Open(HistoryFileName(buf.FileName()))
+	if err != nil {
+		return err
+	}
+	if err := buf.ReadHistory(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteHistory writes the undo history of the Buffer.
+//
+// The history consists of the undo tree, the changes between its states,
+// the named checkpoints, and a checksum of the current text.
+// It is followed by a checksum of the history itself.
+func (buf *Buffer) WriteHistory(w io.Writer) error {
+	textSum, err := buf.textSum()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	h := sha256.New()
+	hw := &historyWriter{w: io.MultiWriter(bw, h)}
+	hw.write(historyMagic)
+	hw.int(HistoryVersion)
+	hw.write(textSum)
+	hw.int(buf.Size())
+	hw.int(int64(buf.nextID))
+	hw.int(int64(len(buf.states)))
+	hw.int(int64(buf.state.id))
+	for _, s := range buf.states {
+		hw.int(int64(s.id))
+		hw.int(stateID(s.parent))
+		hw.int(stateID(s.next))
+		hw.int(s.time.UnixNano())
+		hw.int(s.first)
+		hw.int(s.last)
+		hw.int(int64(s.n))
+		hw.int(s.size)
+	}
+	names := make([]string, 0, len(buf.checkpoints))
+	for name := range buf.checkpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hw.int(int64(len(names)))
+	for _, name := range names {
+		hw.int(int64(len(name)))
+		hw.write([]byte(name))
+		hw.int(int64(buf.checkpoints[name].id))
+	}
+	log := buf.history
+	hw.int(log.last)
+	hw.int(log.buf.Size())
+	const chunk = 1 << 12
+	for at := int64(0); at < log.buf.Size() && hw.err == nil; at += chunk {
+		n := log.buf.Size() - at
+		if n > chunk {
+			n = chunk
+		}
+		rs, err := log.buf.Read(int(n), at)
+		if err != nil {
+			return err
+		}
+		hw.err = binary.Write(hw.w, binary.BigEndian, rs)
+	}
+	if hw.err != nil {
+		return hw.err
+	}
+	if _, err := bw.Write(h.Sum(nil)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func stateID(s *state) int64 {
+	if s == nil {
+		return -1
+	}
+	return int64(s.id)
+}
+
+// ReadHistory reads an undo history written by WriteHistory,
+// and replaces the undo history of the Buffer with it.
+// Any staged, unapplied changes are canceled.
+//
+// The undo history of the Buffer is unchanged if there is an error.
+// ErrBadHistory is returned if the history is malformed,
+// was written with a different HistoryVersion,
+// or fails its checksum.
+// ErrStaleHistory is returned if the history
+// was written for text other than the current text of the Buffer.
+// The history is pruned to meet the Buffer's UndoLimits.
+func (buf *Buffer) ReadHistory(r io.Reader) error {
+	textSum, err := buf.textSum()
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	hr := &historyReader{r: io.TeeReader(bufio.NewReader(r), h)}
+	if magic := hr.read(len(historyMagic)); hr.err == nil && !bytes.Equal(magic, historyMagic) {
+		return ErrBadHistory
+	}
+	if v := hr.int(); hr.err == nil && v != HistoryVersion {
+		return ErrBadHistory
+	}
+	sum := hr.read(sha256.Size)
+	size := hr.int()
+	if hr.err != nil {
+		return hr.error()
+	}
+	if !bytes.Equal(sum, textSum) || size != buf.Size() {
+		return ErrStaleHistory
+	}
+
+	nextID, nstates, cur := hr.int(), hr.int(), hr.int()
+	if hr.err == nil && (nstates < 1 || nextID < nstates) {
+		return ErrBadHistory
+	}
+	// The states are allocated as they are read,
+	// so a corrupt count fails at the end of the input,
+	// not by exhausting memory.
+	var states []*state
+	var next []int64
+	byID := make(map[int64]*state)
+	for i := int64(0); i < nstates && hr.err == nil; i++ {
+		id := hr.int()
+		if hr.err == nil && (id >= nextID || i > 0 && id <= int64(states[i-1].id)) {
+			// IDs are in increasing order.
+			return ErrBadHistory
+		}
+		s := &state{id: int(id)}
+		switch p := hr.int(); {
+		case hr.err != nil:
+		case (p < 0) != (i == 0) || p >= 0 && byID[p] == nil:
+			// The root is first,
+			// and parents are created before their children.
+			return ErrBadHistory
+		case p >= 0:
+			s.parent = byID[p]
+			s.depth = s.parent.depth + 1
+		}
+		next = append(next, hr.int())
+		s.time = time.Unix(0, hr.int())
+		s.first, s.last, s.n, s.size = hr.int(), hr.int(), int(hr.int()), hr.int()
+		states = append(states, s)
+		byID[id] = s
+	}
+	if hr.err != nil {
+		return hr.error()
+	}
+	if byID[cur] == nil || states[0].n != 0 || states[0].size != 0 {
+		return ErrBadHistory
+	}
+	for i, n := range next {
+		if n >= 0 && (byID[n] == nil || byID[n].parent != states[i]) {
+			return ErrBadHistory
+		}
+		if n >= 0 {
+			states[i].next = byID[n]
+		}
+	}
+
+	checkpoints := make(map[string]*state)
+	ncheckpoints := hr.int()
+	for i := int64(0); i < ncheckpoints && hr.err == nil; i++ {
+		n := hr.int()
+		if hr.err == nil && n < 0 {
+			return ErrBadHistory
+		}
+		name := hr.string(n)
+		id := hr.int()
+		if hr.err == nil && byID[id] == nil {
+			return ErrBadHistory
+		}
+		if hr.err == nil {
+			checkpoints[name] = byID[id]
+		}
+	}
+	if hr.err != nil {
+		return hr.error()
+	}
+
+	log := newLog(buf.storage)
+	log.last = hr.int()
+	logSize := hr.int()
+	if err := hr.error(); err != nil {
+		log.close()
+		return err
+	}
+	var undoRunes int64
+	for _, s := range states[1:] {
+		if s.n < 1 || s.first < 0 || s.last < s.first || s.size <= s.last-s.first || s.first+s.size > logSize {
+			log.close()
+			return ErrBadHistory
+		}
+		undoRunes += s.size
+	}
+	const chunk = 1 << 12
+	rs := make([]rune, chunk)
+	for at := int64(0); at < logSize; at += chunk {
+		n := logSize - at
+		if n > chunk {
+			n = chunk
+		}
+		if err := binary.Read(hr.r, binary.BigEndian, rs[:n]); err != nil {
+			log.close()
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = ErrBadHistory
+			}
+			return err
+		}
+		if err := log.buf.Insert(rs[:n], at); err != nil {
+			log.close()
+			return err
+		}
+	}
+	want := h.Sum(nil)
+	if got := hr.read(sha256.Size); hr.err != nil || !bytes.Equal(got, want) {
+		log.close()
+		if hr.err != nil {
+			return hr.error()
+		}
+		return ErrBadHistory
+	}
+
+	if err := buf.history.close(); err != nil {
+		log.close()
+		return err
+	}
+	buf.pending.reset()
+	buf.history = log
+	buf.states = states
+	buf.state = byID[cur]
+	buf.nextID = int(nextID)
+	buf.undoRunes = undoRunes
+	buf.checkpoints = checkpoints
+	buf.seqs = []seqState{{seq: buf.seq, state: buf.state}}
+	buf.own = nil
+	return buf.prune()
+}
+
+// TextSum returns the SHA-256 checksum of the UTF-8 encoded text.
+func (buf *Buffer) textSum() ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, buf.Reader(Span{0, buf.Size()})); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// A historyWriter writes the fields of a history,
+// recording the first error.
+type historyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (hw *historyWriter) write(p []byte) {
+	if hw.err == nil {
+		_, hw.err = hw.w.Write(p)
+	}
+}
+
+func (hw *historyWriter) int(n int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(n))
+	hw.write(b[:])
+}
+
+// A historyReader reads the fields of a history,
+// recording the first error.
+type historyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (hr *historyReader) read(n int) []byte {
+	p := make([]byte, n)
+	if hr.err == nil {
+		_, hr.err = io.ReadFull(hr.r, p)
+	}
+	return p
+}
+
+// String reads a string of n bytes.
+// The string is read incrementally,
+// so a corrupt length fails at the end of the input,
+// not by exhausting memory.
+func (hr *historyReader) string(n int64) string {
+	if hr.err != nil {
+		return ""
+	}
+	p, err := ioutil.ReadAll(io.LimitReader(hr.r, n))
+	switch {
+	case err != nil:
+		hr.err = err
+	case int64(len(p)) < n:
+		hr.err = io.ErrUnexpectedEOF
+	}
+	return string(p)
+}
+
+func (hr *historyReader) int() int64 {
+	return int64(binary.BigEndian.Uint64(hr.read(8)))
+}
+
+// Error returns the recorded error,
+// or ErrBadHistory if the history ended early.
+func (hr *historyReader) error() error {
+	if hr.err == io.EOF || hr.err == io.ErrUnexpectedEOF {
+		return ErrBadHistory
+	}
+	return hr.err
+}
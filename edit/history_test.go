@@ -0,0 +1,190 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryFileName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{name: "file", want: ".file.undo"},
+		{name: "dir/file.go", want: "dir/.file.go.undo"},
+		{name: "/a/b/c", want: "/a/b/.c.undo"},
+	}
+	for _, test := range tests {
+		if got := HistoryFileName(test.name); got != test.want {
+			t.Errorf("HistoryFileName(%q)=%q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteReadHistory(t *testing.T) {
+	buf := newTestBuffer("{..}")
+	defer buf.Close()
+	edits := []Edit{
+		Append(End, "Hello, "),
+		Checkpoint("hello"),
+		Append(End, "World"),
+		Undo(1),
+		Append(End, "世界"),
+		SubGlobal(All, "l", "L"),
+	}
+	for _, e := range edits {
+		if err := e.Do(buf, ioutil.Discard); err != nil {
+			t.Fatalf("%q.Do(…)=%v, want nil", e, err)
+		}
+	}
+	history := bytes.NewBuffer(nil)
+	if err := buf.WriteHistory(history); err != nil {
+		t.Fatalf("WriteHistory(…)=%v, want nil", err)
+	}
+
+	reopened := newTestBuffer("{..}HeLLo, 世界")
+	defer reopened.Close()
+	if err := reopened.ReadHistory(bytes.NewReader(history.Bytes())); err != nil {
+		t.Fatalf("ReadHistory(…)=%v, want nil", err)
+	}
+	want, got := buf.UndoStates(), reopened.UndoStates()
+	for i := range want {
+		if !want[i].Time.Equal(got[i].Time) {
+			t.Errorf("UndoStates()[%d].Time=%v, want %v", i, got[i].Time, want[i].Time)
+		}
+		want[i].Time, got[i].Time = want[i].Time.UTC(), got[i].Time.UTC()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UndoStates()=%v, want %v", got, want)
+	}
+
+	for _, e := range []Edit{Undo(1), Undo(1)} {
+		if err := e.Do(reopened, ioutil.Discard); err != nil {
+			t.Fatalf("%q.Do(…)=%v, want nil", e, err)
+		}
+	}
+	if s := reopened.String(); s != "Hello, " {
+		t.Errorf("after undo, text=%q, want %q", s, "Hello, ")
+	}
+	if err := UndoTo(3).Do(reopened, ioutil.Discard); err != nil {
+		t.Fatalf("UndoTo(3).Do(…)=%v, want nil", err)
+	}
+	if s := reopened.String(); s != "Hello, World" {
+		t.Errorf("after UndoTo(3), text=%q, want %q", s, "Hello, World")
+	}
+	if err := UndoToCheckpoint("hello").Do(reopened, ioutil.Discard); err != nil {
+		t.Fatalf("UndoToCheckpoint(\"hello\").Do(…)=%v, want nil", err)
+	}
+	if s := reopened.String(); s != "Hello, " {
+		t.Errorf("after UndoToCheckpoint(\"hello\"), text=%q, want %q", s, "Hello, ")
+	}
+}
+
+func TestReadHistoryErrors(t *testing.T) {
+	buf := newTestBuffer("{..}abc")
+	defer buf.Close()
+	if err := Append(End, "xyz").Do(buf, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	history := bytes.NewBuffer(nil)
+	if err := buf.WriteHistory(history); err != nil {
+		t.Fatalf("WriteHistory(…)=%v, want nil", err)
+	}
+	data := history.Bytes()
+
+	modify := func(i int, b byte) []byte {
+		d := append([]byte{}, data...)
+		d[i] = b
+		return d
+	}
+	tests := []struct {
+		name string
+		text string
+		data []byte
+		want error
+	}{
+		{name: "ok", text: "abcxyz", data: data},
+		{name: "stale", text: "abcxyZ", data: data, want: ErrStaleHistory},
+		{name: "empty", text: "abcxyz", data: nil, want: ErrBadHistory},
+		{name: "truncated", text: "abcxyz", data: data[:len(data)-1], want: ErrBadHistory},
+		{name: "bad magic", text: "abcxyz", data: modify(0, 'X'), want: ErrBadHistory},
+		{
+			name: "bad version",
+			text: "abcxyz",
+			data: modify(len(historyMagic)+7, HistoryVersion+1),
+			want: ErrBadHistory,
+		},
+		{
+			name: "bad checksum",
+			text: "abcxyz",
+			data: modify(len(data)-1, data[len(data)-1]+1),
+			want: ErrBadHistory,
+		},
+		{
+			name: "corrupt log",
+			text: "abcxyz",
+			data: modify(len(data)-sha256.Size-1, data[len(data)-sha256.Size-1]+1),
+			want: ErrBadHistory,
+		},
+	}
+	for _, test := range tests {
+		b := newTestBuffer("{..}" + test.text)
+		before := b.UndoStates()
+		err := b.ReadHistory(bytes.NewReader(test.data))
+		if err != test.want {
+			t.Errorf("%s: ReadHistory(…)=%v, want %v", test.name, err, test.want)
+		}
+		if err != nil && !reflect.DeepEqual(b.UndoStates(), before) {
+			t.Errorf("%s: UndoStates()=%v after error, want %v", test.name, b.UndoStates(), before)
+		}
+		b.Close()
+	}
+}
+
+func TestSaveLoadHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/file"
+
+	buf := newTestBuffer("{..}")
+	defer buf.Close()
+	if err := buf.SaveHistory(); err != ErrNoFileName {
+		t.Errorf("SaveHistory()=%v, want %v", err, ErrNoFileName)
+	}
+	if err := buf.LoadHistory(); err != ErrNoFileName {
+		t.Errorf("LoadHistory()=%v, want %v", err, ErrNoFileName)
+	}
+	buf.SetFileName(path)
+	for _, e := range []Edit{Append(End, "abc"), WriteFile(All, "")} {
+		if err := e.Do(buf, ioutil.Discard); err != nil {
+			t.Fatalf("%q.Do(…)=%v, want nil", e, err)
+		}
+	}
+	if err := buf.SaveHistory(); err != nil {
+		t.Fatalf("SaveHistory()=%v, want nil", err)
+	}
+	if _, err := os.Stat(HistoryFileName(path)); err != nil {
+		t.Errorf("os.Stat(%q)=_,%v, want _,nil", HistoryFileName(path), err)
+	}
+
+	reopened := NewBuffer()
+	defer reopened.Close()
+	if err := EditFile(path).Do(reopened, ioutil.Discard); err != nil {
+		t.Fatalf("EditFile(%q).Do(…)=%v, want nil", path, err)
+	}
+	if err := reopened.LoadHistory(); err != nil {
+		t.Fatalf("LoadHistory()=%v, want nil", err)
+	}
+	if err := Undo(1).Do(reopened, ioutil.Discard); err != nil {
+		t.Fatalf("Undo(1).Do(…)=%v, want nil", err)
+	}
+	if s := reopened.String(); s != "" {
+		t.Errorf("after undo, text=%q, want %q", s, "")
+	}
+}
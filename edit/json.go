@@ -0,0 +1,537 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+)
+
+// The JSON encoding of Addresses and Edits is an abstract syntax tree.
+// Each node is a JSON object with a "type" field
+// naming the function that constructs the node,
+// and further fields giving the function's arguments.
+//
+// Addresses:
+// 	{"type": "to", "left": <Address>, "right": <Address>}
+// 	{"type": "then", "left": <Address>, "right": <Address>}
+// 	{"type": "between", "left": <Address>, "right": <Address>}
+// 	{"type": "plus", "left": <Address>, "right": <Address>}
+// 	{"type": "minus", "left": <Address>, "right": <Address>}
+// 	{"type": "clamp", "addr": <Address>}
+// 	{"type": "end"}
+// 	{"type": "line", "n": <number>}
+// 	{"type": "rune", "n": <number>}
+// 	{"type": "byte", "n": <number>}
+// 	{"type": "mark", "mark": <string of one rune>}
+// 	{"type": "regexp", "regexp": <string>}
+// 	{"type": "balanced"}
+//
+// Edits:
+// 	{"type": "change", "addr": <Address>, "text": <string>}
+// 	{"type": "append", "addr": <Address>, "text": <string>}
+// 	{"type": "insert", "addr": <Address>, "text": <string>}
+// 	{"type": "delete", "addr": <Address>}
+// 	{"type": "move", "src": <Address>, "dst": <Address>}
+// 	{"type": "copy", "src": <Address>, "dst": <Address>}
+// 	{"type": "set", "addr": <Address>, "mark": <string of one rune>}
+// 	{"type": "print", "addr": <Address>}
+// 	{"type": "where", "addr": <Address>}
+// 	{"type": "whereLine", "addr": <Address>}
+// 	{"type": "whereBytes", "addr": <Address>}
+// 	{"type": "substitute", "addr": <Address>, "regexp": <string>, "with": <string>, "global": <bool>, "from": <number>}
+// 	{"type": "loop", "addr": <Address>, "regexp": <string>, "body": <Edit>}
+// 	{"type": "loopBetween", "addr": <Address>, "regexp": <string>, "body": <Edit>}
+// 	{"type": "loopFiles", "regexp": <string>, "body": <Edit>}
+// 	{"type": "loopFilesNot", "regexp": <string>, "body": <Edit>}
+// 	{"type": "guard", "addr": <Address>, "regexp": <string>, "body": <Edit>}
+// 	{"type": "guardNot", "addr": <Address>, "regexp": <string>, "body": <Edit>}
+// 	{"type": "pipe", "addr": <Address>, "cmd": <string>}
+// 	{"type": "pipeTo", "addr": <Address>, "cmd": <string>}
+// 	{"type": "pipeFrom", "addr": <Address>, "cmd": <string>}
+// 	{"type": "editFile", "name": <string>}
+// 	{"type": "readFile", "addr": <Address>, "name": <string>}
+// 	{"type": "writeFile", "addr": <Address>, "name": <string>}
+// 	{"type": "file", "name": <string>}
+// 	{"type": "encoding", "name": <string>}
+// 	{"type": "lineEnding", "name": <string>}
+// 	{"type": "undo", "n": <number>}
+// 	{"type": "redo", "n": <number>}
+// 	{"type": "selectiveUndo", "n": <number>}
+// 	{"type": "undoList"}
+// 	{"type": "undoTo", "n": <number>}
+// 	{"type": "undoToSequence", "n": <number>}
+// 	{"type": "undoToCheckpoint", "name": <string>}
+// 	{"type": "checkpoint", "name": <string>}
+// 	{"type": "block", "addr": <Address>, "edits": [<Edit>, ...]}
+//
+// Fields with zero values may be omitted.
+
+// addrNode is the JSON encoding of an Address.
+type addrNode struct {
+	Type   string    `json:"type"`
+	Left   *jsonAddr `json:"left,omitempty"`
+	Right  *jsonAddr `json:"right,omitempty"`
+	Addr   *jsonAddr `json:"addr,omitempty"`
+	N      int64     `json:"n,omitempty"`
+	Mark   string    `json:"mark,omitempty"`
+	Regexp string    `json:"regexp,omitempty"`
+}
+
+// jsonAddr wraps an Address, for encoding and decoding as a JSON field.
+type jsonAddr struct{ Address }
+
+func (a jsonAddr) MarshalJSON() ([]byte, error) { return json.Marshal(a.Address) }
+
+func (a *jsonAddr) UnmarshalJSON(data []byte) error {
+	var err error
+	a.Address, err = UnmarshalAddress(data)
+	return err
+}
+
+func wrapAddr(a Address) *jsonAddr { return &jsonAddr{a} }
+
+func marshalAddr(typ string, left Address, right Address) ([]byte, error) {
+	return json.Marshal(addrNode{Type: typ, Left: wrapAddr(left), Right: wrapAddr(right)})
+}
+
+func (a to) MarshalJSON() ([]byte, error)      { return marshalAddr("to", a.left, a.right) }
+func (a then) MarshalJSON() ([]byte, error)    { return marshalAddr("then", a.left, a.right) }
+func (a between) MarshalJSON() ([]byte, error) { return marshalAddr("between", a.left, a.right) }
+func (a plus) MarshalJSON() ([]byte, error)    { return marshalAddr("plus", a.left, a.right) }
+func (a minus) MarshalJSON() ([]byte, error)   { return marshalAddr("minus", a.left, a.right) }
+
+func (a clamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(addrNode{Type: "clamp", Addr: wrapAddr(a.addr)})
+}
+
+func (a end) MarshalJSON() ([]byte, error) { return json.Marshal(addrNode{Type: "end"}) }
+
+func (a line) MarshalJSON() ([]byte, error) {
+	return json.Marshal(addrNode{Type: "line", N: int64(a.n)})
+}
+
+func (a runeAddr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(addrNode{Type: "rune", N: int64(a)})
+}
+
+func (a byteAddr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(addrNode{Type: "byte", N: int64(a)})
+}
+
+func (a mark) MarshalJSON() ([]byte, error) {
+	return json.Marshal(addrNode{Type: "mark", Mark: string(rune(a))})
+}
+
+func (a regexpAddr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(addrNode{Type: "regexp", Regexp: a.regexp})
+}
+
+func (a balanced) MarshalJSON() ([]byte, error) {
+	return json.Marshal(addrNode{Type: "balanced"})
+}
+
+// UnmarshalAddress returns the Address
+// decoded from its JSON encoding.
+func UnmarshalAddress(data []byte) (Address, error) {
+	var n addrNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	switch n.Type {
+	case "to", "then", "between":
+		left, right, err := additiveOperands(n)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Type {
+		case "to":
+			return left.To(right), nil
+		case "then":
+			return left.Then(right), nil
+		default:
+			return left.Between(right), nil
+		}
+	case "plus", "minus":
+		left, right, err := additiveOperands(n)
+		if err != nil {
+			return nil, err
+		}
+		l, ok := left.(AdditiveAddress)
+		if !ok {
+			return nil, errors.New("bad left operand of " + n.Type + ": " + left.String())
+		}
+		r, ok := right.(SimpleAddress)
+		if !ok {
+			return nil, errors.New("bad right operand of " + n.Type + ": " + right.String())
+		}
+		if n.Type == "plus" {
+			return l.Plus(r), nil
+		}
+		return l.Minus(r), nil
+	case "clamp":
+		if n.Addr == nil {
+			return nil, errors.New("missing addr")
+		}
+		a, ok := n.Addr.Address.(SimpleAddress)
+		if !ok {
+			return nil, errors.New("bad operand of clamp: " + n.Addr.String())
+		}
+		return Clamp(a), nil
+	case "end":
+		return End, nil
+	case "line":
+		return Line(int(n.N)), nil
+	case "rune":
+		return Rune(n.N), nil
+	case "byte":
+		return Byte(n.N), nil
+	case "mark":
+		m, err := jsonMark(n.Mark)
+		if err != nil {
+			return nil, err
+		}
+		return Mark(m), nil
+	case "regexp":
+		if _, err := regexpCompile(n.Regexp); err != nil {
+			return nil, err
+		}
+		return Regexp(n.Regexp), nil
+	case "balanced":
+		return Balanced(), nil
+	default:
+		return nil, errors.New("unknown address type: " + n.Type)
+	}
+}
+
+func additiveOperands(n addrNode) (Address, AdditiveAddress, error) {
+	if n.Left == nil || n.Right == nil {
+		return nil, nil, errors.New("missing operand of " + n.Type)
+	}
+	right, ok := n.Right.Address.(AdditiveAddress)
+	if !ok {
+		return nil, nil, errors.New("bad right operand of " + n.Type + ": " + n.Right.String())
+	}
+	return n.Left.Address, right, nil
+}
+
+func jsonMark(s string) (rune, error) {
+	r, w := utf8.DecodeRuneInString(s)
+	if w == 0 || w != len(s) || r == utf8.RuneError {
+		return 0, errors.New("bad mark: " + s)
+	}
+	return r, nil
+}
+
+// editNode is the JSON encoding of an Edit.
+type editNode struct {
+	Type   string     `json:"type"`
+	Addr   *jsonAddr  `json:"addr,omitempty"`
+	Src    *jsonAddr  `json:"src,omitempty"`
+	Dst    *jsonAddr  `json:"dst,omitempty"`
+	Text   string     `json:"text,omitempty"`
+	Mark   string     `json:"mark,omitempty"`
+	Regexp string     `json:"regexp,omitempty"`
+	With   string     `json:"with,omitempty"`
+	Global bool       `json:"global,omitempty"`
+	From   int        `json:"from,omitempty"`
+	Body   *jsonEdit  `json:"body,omitempty"`
+	Edits  []jsonEdit `json:"edits,omitempty"`
+	Cmd    string     `json:"cmd,omitempty"`
+	Name   string     `json:"name,omitempty"`
+	N      int        `json:"n,omitempty"`
+}
+
+// jsonEdit wraps an Edit, for encoding and decoding as a JSON field.
+type jsonEdit struct{ Edit }
+
+func (e jsonEdit) MarshalJSON() ([]byte, error) { return json.Marshal(e.Edit) }
+
+func (e *jsonEdit) UnmarshalJSON(data []byte) error {
+	var err error
+	e.Edit, err = UnmarshalEdit(data)
+	return err
+}
+
+func (e change) MarshalJSON() ([]byte, error) {
+	var typ string
+	switch e.op {
+	case 'a':
+		typ = "append"
+	case 'i':
+		typ = "insert"
+	case 'd':
+		typ = "delete"
+	default:
+		typ = "change"
+	}
+	return json.Marshal(editNode{Type: typ, Addr: wrapAddr(e.Address), Text: e.str})
+}
+
+func (e move) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "move", Src: wrapAddr(e.src), Dst: wrapAddr(e.dst)})
+}
+
+func (e copyEdit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "copy", Src: wrapAddr(e.src), Dst: wrapAddr(e.dst)})
+}
+
+func (e set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "set", Addr: wrapAddr(e.Address), Mark: string(e.mark)})
+}
+
+func (e print) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "print", Addr: wrapAddr(e.Address)})
+}
+
+func (e where) MarshalJSON() ([]byte, error) {
+	typ := "where"
+	switch {
+	case e.line:
+		typ = "whereLine"
+	case e.bytes:
+		typ = "whereBytes"
+	}
+	return json.Marshal(editNode{Type: typ, Addr: wrapAddr(e.Address)})
+}
+
+func (e Substitute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{
+		Type:   "substitute",
+		Addr:   wrapAddr(e.Address),
+		Regexp: e.Regexp,
+		With:   e.With,
+		Global: e.Global,
+		From:   e.From,
+	})
+}
+
+func (e loop) MarshalJSON() ([]byte, error) {
+	typ := "loop"
+	if e.between {
+		typ = "loopBetween"
+	}
+	return json.Marshal(editNode{Type: typ, Addr: wrapAddr(e.Address), Regexp: e.regexp, Body: &jsonEdit{e.body}})
+}
+
+func (e FileLoop) MarshalJSON() ([]byte, error) {
+	typ := "loopFiles"
+	if e.Not {
+		typ = "loopFilesNot"
+	}
+	return json.Marshal(editNode{Type: typ, Regexp: e.Regexp, Body: &jsonEdit{e.Body}})
+}
+
+func (e guard) MarshalJSON() ([]byte, error) {
+	typ := "guard"
+	if e.not {
+		typ = "guardNot"
+	}
+	return json.Marshal(editNode{Type: typ, Addr: wrapAddr(e.Address), Regexp: e.regexp, Body: &jsonEdit{e.body}})
+}
+
+func (e pipe) MarshalJSON() ([]byte, error) {
+	typ := "pipe"
+	if !e.to {
+		typ = "pipeFrom"
+	} else if !e.from {
+		typ = "pipeTo"
+	}
+	return json.Marshal(editNode{Type: typ, Addr: wrapAddr(e.Address), Cmd: e.cmd})
+}
+
+func (e editFile) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "editFile", Name: string(e)})
+}
+
+// MarshalJSON returns ErrSubstituteFunc,
+// since the function of a SubstituteFunc has no JSON representation.
+func (e SubstituteFunc) MarshalJSON() ([]byte, error) { return nil, ErrSubstituteFunc }
+
+func (e readFileEdit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "readFile", Addr: wrapAddr(e.Address), Name: e.name})
+}
+
+func (e writeFile) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "writeFile", Addr: wrapAddr(e.Address), Name: e.name})
+}
+
+func (e file) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "file", Name: string(e)})
+}
+
+func (e encoding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "encoding", Name: string(e)})
+}
+
+func (e lineEnding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "lineEnding", Name: string(e)})
+}
+
+func (e undo) MarshalJSON() ([]byte, error) { return json.Marshal(editNode{Type: "undo", N: int(e)}) }
+func (e redo) MarshalJSON() ([]byte, error) { return json.Marshal(editNode{Type: "redo", N: int(e)}) }
+
+func (e selectiveUndo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "selectiveUndo", N: int(e)})
+}
+
+func (undoList) MarshalJSON() ([]byte, error) { return json.Marshal(editNode{Type: "undoList"}) }
+func (e undoTo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "undoTo", N: int(e)})
+}
+
+func (e undoToSequence) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "undoToSequence", N: int(e)})
+}
+
+func (e undoToCheckpoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "undoToCheckpoint", Name: string(e)})
+}
+
+func (e checkpoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editNode{Type: "checkpoint", Name: string(e)})
+}
+
+func (e block) MarshalJSON() ([]byte, error) {
+	edits := make([]jsonEdit, len(e.body))
+	for i, b := range e.body {
+		edits[i] = jsonEdit{b}
+	}
+	return json.Marshal(editNode{Type: "block", Addr: wrapAddr(e.Address), Edits: edits})
+}
+
+// UnmarshalEdit returns the Edit
+// decoded from its JSON encoding.
+func UnmarshalEdit(data []byte) (Edit, error) {
+	var n editNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	switch n.Type {
+	case "editFile":
+		return EditFile(n.Name), nil
+	case "file":
+		return File(n.Name), nil
+	case "encoding":
+		return FileEncoding(n.Name), nil
+	case "lineEnding":
+		return FileLineEnding(n.Name), nil
+	case "undo":
+		return Undo(n.N), nil
+	case "redo":
+		return Redo(n.N), nil
+	case "selectiveUndo":
+		return SelectiveUndo(n.N), nil
+	case "undoList":
+		return UndoList(), nil
+	case "undoTo":
+		return UndoTo(n.N), nil
+	case "undoToSequence":
+		return UndoToSequence(n.N), nil
+	case "undoToCheckpoint":
+		return UndoToCheckpoint(n.Name), nil
+	case "checkpoint":
+		return Checkpoint(n.Name), nil
+	case "move", "copy":
+		if n.Src == nil || n.Dst == nil {
+			return nil, errors.New("missing src or dst of " + n.Type)
+		}
+		if n.Type == "move" {
+			return Move(n.Src.Address, n.Dst.Address), nil
+		}
+		return Copy(n.Src.Address, n.Dst.Address), nil
+	case "loopFiles", "loopFilesNot":
+		if err := checkRegexpBody(n); err != nil {
+			return nil, err
+		}
+		if n.Type == "loopFiles" {
+			return LoopFiles(n.Regexp, n.Body.Edit), nil
+		}
+		return LoopFilesNot(n.Regexp, n.Body.Edit), nil
+	}
+
+	if n.Addr == nil {
+		switch n.Type {
+		case "change", "append", "insert", "delete", "set", "print", "where", "whereLine", "whereBytes",
+			"substitute", "loop", "loopBetween", "guard", "guardNot",
+			"pipe", "pipeTo", "pipeFrom", "readFile", "writeFile", "block":
+			return nil, errors.New("missing addr of " + n.Type)
+		default:
+			return nil, errors.New("unknown edit type: " + n.Type)
+		}
+	}
+	a := n.Addr.Address
+	switch n.Type {
+	case "change":
+		return Change(a, n.Text), nil
+	case "append":
+		return Append(a, n.Text), nil
+	case "insert":
+		return Insert(a, n.Text), nil
+	case "delete":
+		return Delete(a), nil
+	case "set":
+		m, err := jsonMark(n.Mark)
+		if err != nil {
+			return nil, err
+		}
+		return Set(a, m), nil
+	case "print":
+		return Print(a), nil
+	case "where":
+		return Where(a), nil
+	case "whereLine":
+		return WhereLine(a), nil
+	case "whereBytes":
+		return WhereBytes(a), nil
+	case "substitute":
+		if _, err := regexpCompile(n.Regexp); err != nil {
+			return nil, err
+		}
+		return Substitute{Address: a, Regexp: n.Regexp, With: n.With, Global: n.Global, From: n.From}, nil
+	case "loop", "loopBetween", "guard", "guardNot":
+		if err := checkRegexpBody(n); err != nil {
+			return nil, err
+		}
+		switch n.Type {
+		case "loop":
+			return Loop(a, n.Regexp, n.Body.Edit), nil
+		case "loopBetween":
+			return LoopBetween(a, n.Regexp, n.Body.Edit), nil
+		case "guard":
+			return Guard(a, n.Regexp, n.Body.Edit), nil
+		default:
+			return GuardNot(a, n.Regexp, n.Body.Edit), nil
+		}
+	case "pipe":
+		return Pipe(a, n.Cmd), nil
+	case "pipeTo":
+		return PipeTo(a, n.Cmd), nil
+	case "pipeFrom":
+		return PipeFrom(a, n.Cmd), nil
+	case "readFile":
+		return ReadFile(a, n.Name), nil
+	case "writeFile":
+		return WriteFile(a, n.Name), nil
+	case "block":
+		var body []Edit
+		for _, e := range n.Edits {
+			if e.Edit == nil {
+				return nil, errors.New("missing edit in block")
+			}
+			body = append(body, e.Edit)
+		}
+		return Block(a, body...), nil
+	default:
+		return nil, errors.New("unknown edit type: " + n.Type)
+	}
+}
+
+func checkRegexpBody(n editNode) error {
+	if n.Body == nil {
+		return errors.New("missing body of " + n.Type)
+	}
+	_, err := regexpCompile(n.Regexp)
+	return err
+}
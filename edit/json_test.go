@@ -0,0 +1,157 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddressJSON(t *testing.T) {
+	tests := []string{
+		"$",
+		".",
+		"'a",
+		"'☺",
+		"#0",
+		"#100",
+		"#b0",
+		"#b100",
+		"0",
+		"100",
+		"/abc/",
+		`/a\/b/`,
+		"%",
+		"/f/+%-%",
+		"!#5",
+		"!/abc/",
+		"0,$",
+		"1;/abc/",
+		"1~#3",
+		"1+#3",
+		"1-#3",
+		"!1+!#3",
+		"1+#3,/the/+8",
+		"1+#3;/the/-8",
+		"/a/,/b/~/c/;/d/",
+	}
+	for _, test := range tests {
+		a, err := Addr(strings.NewReader(test))
+		if err != nil {
+			t.Errorf("Addr(%q)=_,%v", test, err)
+			continue
+		}
+		data, err := json.Marshal(a)
+		if err != nil {
+			t.Errorf("json.Marshal(%q)=_,%v", test, err)
+			continue
+		}
+		got, err := UnmarshalAddress(data)
+		if err != nil || !reflect.DeepEqual(got, a) || got.String() != a.String() {
+			t.Errorf("UnmarshalAddress(%s)=%q,%v, want %q,nil", data, got, err, a)
+		}
+	}
+}
+
+func TestEditJSON(t *testing.T) {
+	tests := []string{
+		"0,$c/xyz/",
+		`.a/a\nb\nc/`,
+		"/a*/i/b/",
+		"1,3d",
+		"1m$",
+		"1t$",
+		"#3ka",
+		"0,$p",
+		"1=",
+		"1=#",
+		"1=#b",
+		"s/a/b/",
+		"0,$s3/(a)/$1/g",
+		",x/abc/d",
+		",x/abc/x/b/c/z/",
+		",y/\\n/p",
+		",g/a/d",
+		",v/a/d",
+		"X/\\.go$/,p",
+		"Y/\\.go$/,p",
+		"|sort\n",
+		">cat\n",
+		"<echo hi\n",
+		"e foo\n",
+		"1r bar\n",
+		",w baz\n",
+		"f\n",
+		"u3",
+		"r2",
+		"{\n}",
+		",{\n1p\n$d\n,x/a/{\nc/b/\n}\n}",
+	}
+	for _, test := range tests {
+		e, err := Ed(strings.NewReader(test))
+		if err != nil {
+			t.Errorf("Ed(%q)=_,%v", test, err)
+			continue
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Errorf("json.Marshal(%q)=_,%v", test, err)
+			continue
+		}
+		got, err := UnmarshalEdit(data)
+		if err != nil || !reflect.DeepEqual(got, e) || got.String() != e.String() {
+			t.Errorf("UnmarshalEdit(%s)=%q,%v, want %q,nil", data, got, err, e)
+		}
+	}
+}
+
+func TestUnmarshalEdit(t *testing.T) {
+	tests := []struct {
+		json  string
+		want  Edit
+		error string
+	}{
+		{
+			json: `{"type": "change", "addr": {"type": "to", "left": {"type": "line"}, "right": {"type": "end"}}, "text": "xyz"}`,
+			want: Change(All, "xyz"),
+		},
+		{
+			json: `{"type": "substitute", "addr": {"type": "mark", "mark": "."}, "regexp": "a", "with": "b", "global": true}`,
+			want: Substitute{Address: Dot, Regexp: "a", With: "b", Global: true},
+		},
+		{
+			json: `{"type": "block", "addr": {"type": "line", "n": 1}, "edits": [{"type": "print", "addr": {"type": "end"}}]}`,
+			want: Block(Line(1), Print(End)),
+		},
+		{json: `{"type": "undo", "n": 2}`, want: Undo(2)},
+		{json: `{"type": "selectiveUndo", "n": 2}`, want: SelectiveUndo(2)},
+		{json: `{"type": "undoList"}`, want: UndoList()},
+		{json: `{"type": "undoTo", "n": 3}`, want: UndoTo(3)},
+		{json: `{"type": "undoToSequence", "n": 3}`, want: UndoToSequence(3)},
+		{json: `{"type": "undoToCheckpoint", "name": "a"}`, want: UndoToCheckpoint("a")},
+		{json: `{"type": "checkpoint", "name": "a"}`, want: Checkpoint("a")},
+		{json: `{"type": "encoding", "name": "utf-8"}`, want: FileEncoding("utf-8")},
+		{json: `{"type": "lineEnding", "name": "crlf"}`, want: FileLineEnding("crlf")},
+		{json: `{"type": "zzz"}`, error: "unknown edit type"},
+		{json: `{"type": "print"}`, error: "missing addr"},
+		{json: `{"type": "print", "addr": {"type": "zzz"}}`, error: "unknown address type"},
+		{json: `{"type": "print", "addr": {"type": "regexp", "regexp": "("}}`, error: "missing closing"},
+		{json: `{"type": "print", "addr": {"type": "mark", "mark": "ab"}}`, error: "bad mark"},
+		{json: `{"type": "loop", "addr": {"type": "end"}, "regexp": "a"}`, error: "missing body"},
+		{json: `{"type": "loop", "addr": {"type": "end"}, "regexp": "[", "body": {"type": "print", "addr": {"type": "end"}}}`, error: "missing closing"},
+		{json: `{"type": "move", "src": {"type": "end"}}`, error: "missing src or dst"},
+		{
+			json:  `{"type": "print", "addr": {"type": "plus", "left": {"type": "end"}, "right": {"type": "to", "left": {"type": "end"}, "right": {"type": "end"}}}}`,
+			error: "bad right operand",
+		},
+		{json: `["p"]`, error: "cannot unmarshal"},
+	}
+	for _, test := range tests {
+		got, err := UnmarshalEdit([]byte(test.json))
+		if !matchesError(test.error, err) || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("UnmarshalEdit(%s)=%q,%v, want %q,%q", test.json, got, err, test.want, test.error)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// A LineEnding is the convention used to end the lines of a file.
+//
+// The text of an Editor always ends lines with a single newline.
+// A file read with the CRLF LineEnding has each \r\n replaced by \n,
+// and a file written with the CRLF LineEnding has each \n replaced by \r\n.
+//
+// The zero LineEnding, named auto, is not itself a convention.
+// A file read by an Editor with the auto LineEnding
+// is read using the LineEnding detected by DetectLineEnding,
+// and a file written by an Editor with the auto LineEnding
+// is written using LF.
+type LineEnding string
+
+// The supported LineEndings.
+const (
+	AutoLineEnding LineEnding = ""
+	LF             LineEnding = "lf"
+	CRLF           LineEnding = "crlf"
+)
+
+// ParseLineEnding returns the LineEnding with the given name.
+// Names are case insensitive.
+// In addition to lf and crlf, auto names the zero LineEnding.
+func ParseLineEnding(name string) (LineEnding, error) {
+	switch l := LineEnding(strings.ToLower(name)); l {
+	case LF, CRLF:
+		return l, nil
+	case "auto":
+		return AutoLineEnding, nil
+	}
+	return AutoLineEnding, errors.New("unknown line ending: " + name)
+}
+
+// String returns the name of the LineEnding.
+func (l LineEnding) String() string {
+	if l == AutoLineEnding {
+		return "auto"
+	}
+	return string(l)
+}
+
+// NewDecoder returns an io.Reader
+// that reads the UTF-8 bytes of r,
+// and returns them with each line ending replaced by \n.
+// The LF and auto LineEndings return r.
+func (l LineEnding) NewDecoder(r io.Reader) io.Reader {
+	if l != CRLF {
+		return r
+	}
+	return &crlfDecoder{r: r}
+}
+
+type crlfDecoder struct {
+	r io.Reader
+	// Out is the decoded output.
+	out []byte
+	// Cr is whether the last byte read from r is a \r
+	// that is not yet decoded.
+	cr bool
+	// Err is the error from reading r.
+	err error
+}
+
+func (d *crlfDecoder) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			if !d.cr {
+				return 0, d.err
+			}
+			d.cr = false
+			d.out = append(d.out, '\r')
+			break
+		}
+		var buf [4096]byte
+		n, err := d.r.Read(buf[:])
+		d.err = err
+		for _, b := range buf[:n] {
+			if d.cr && b != '\n' {
+				d.out = append(d.out, '\r')
+			}
+			if d.cr = b == '\r'; !d.cr {
+				d.out = append(d.out, b)
+			}
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// NewEncoder returns an io.Writer
+// that writes the UTF-8 bytes written to it to w,
+// with each \n replaced by the line ending.
+// The LF and auto LineEndings return w.
+func (l LineEnding) NewEncoder(w io.Writer) io.Writer {
+	if l != CRLF {
+		return w
+	}
+	return crlfEncoder{w}
+}
+
+type crlfEncoder struct{ w io.Writer }
+
+func (e crlfEncoder) Write(p []byte) (int, error) {
+	out := bytes.Replace(p, []byte{'\n'}, []byte{'\r', '\n'}, -1)
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// DetectLineEnding returns the LineEnding of the UTF-8 bytes read from r.
+//
+// The LineEnding is CRLF if there is a line ending with \r\n
+// and no line ends with \n alone; otherwise it is LF.
+// Text with mixed line endings is therefore read and written unchanged.
+func DetectLineEnding(r io.Reader) (LineEnding, error) {
+	var lf, crlf int64
+	var cr bool
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if b == '\n' {
+				if cr {
+					crlf++
+				} else {
+					lf++
+				}
+			}
+			cr = b == '\r'
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return AutoLineEnding, err
+		}
+	}
+	if crlf > 0 && lf == 0 {
+		return CRLF, nil
+	}
+	return LF, nil
+}
@@ -0,0 +1,257 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var lineEndingTests = []struct {
+	name string
+	nl   LineEnding
+	text string
+	data string
+}{
+	{name: "lf", nl: LF, text: "a\r\nb\n", data: "a\r\nb\n"},
+	{name: "auto", nl: AutoLineEnding, text: "a\r\nb\n", data: "a\r\nb\n"},
+	{name: "crlf", nl: CRLF, text: "a\nb\n", data: "a\r\nb\r\n"},
+	{name: "crlf no final newline", nl: CRLF, text: "a\nb", data: "a\r\nb"},
+	{name: "crlf lone cr", nl: CRLF, text: "a\rb\r", data: "a\rb\r"},
+	{name: "crlf cr before crlf", nl: CRLF, text: "a\r\n\n", data: "a\r\r\n\r\n"},
+	{name: "crlf empty", nl: CRLF, text: "", data: ""},
+}
+
+func TestLineEndingDecode(t *testing.T) {
+	for _, test := range lineEndingTests {
+		r := test.nl.NewDecoder(iotest.OneByteReader(strings.NewReader(test.data)))
+		text, err := ioutil.ReadAll(r)
+		if err != nil || string(text) != test.text {
+			t.Errorf("%s: decoded %q,%v, want %q,nil", test.name, text, err, test.text)
+		}
+	}
+}
+
+func TestLineEndingEncode(t *testing.T) {
+	for _, test := range lineEndingTests {
+		var data bytes.Buffer
+		w := test.nl.NewEncoder(&data)
+		for _, b := range []byte(test.text) {
+			if _, err := w.Write([]byte{b}); err != nil {
+				t.Fatalf("%s: Write(…)=_,%v, want _,nil", test.name, err)
+			}
+		}
+		if data.String() != test.data {
+			t.Errorf("%s: encoded %q, want %q", test.name, data.String(), test.data)
+		}
+	}
+}
+
+func TestDetectLineEnding(t *testing.T) {
+	tests := []struct {
+		data string
+		want LineEnding
+	}{
+		{data: "", want: LF},
+		{data: "abc", want: LF},
+		{data: "a\nb\n", want: LF},
+		{data: "a\r\nb\r\n", want: CRLF},
+		{data: "a\r\nb", want: CRLF},
+		{data: "a\rb\r", want: LF},
+		{data: "a\r\nb\r\nc\n", want: LF},
+		{data: "a\nb\r\nc\r\n", want: LF},
+		{data: "a\r\nb\nc\n", want: LF},
+		{data: "a\r\nb\n", want: LF},
+		{data: strings.Repeat("Hello, 世界\r\n", 10000), want: CRLF},
+	}
+	for _, test := range tests {
+		nl, err := DetectLineEnding(iotest.HalfReader(strings.NewReader(test.data)))
+		if err != nil || nl != test.want {
+			t.Errorf("DetectLineEnding(%.20q)=%v,%v, want %v,nil", test.data, nl, err, test.want)
+		}
+	}
+}
+
+func TestParseLineEnding(t *testing.T) {
+	tests := []struct {
+		name  string
+		want  LineEnding
+		error string
+	}{
+		{name: "auto", want: AutoLineEnding},
+		{name: "lf", want: LF},
+		{name: "CRLF", want: CRLF},
+		{name: "cr", error: "unknown line ending"},
+	}
+	for _, test := range tests {
+		nl, err := ParseLineEnding(test.name)
+		if !matchesError(test.error, err) || test.error == "" && nl != test.want {
+			t.Errorf("ParseLineEnding(%q)=%v,%v, want %v,%q", test.name, nl, err, test.want, test.error)
+		}
+	}
+}
+
+func TestEditFileLineEnding(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		fileName   string
+		lineEnding LineEnding
+		do         []Edit
+		want       string
+		print      string
+		error      string
+		// WantFiles are the files that differ from files.
+		wantFiles      map[string]string
+		wantLineEnding LineEnding
+	}{
+		{
+			name:           "e detect crlf",
+			files:          map[string]string{"a": "a\r\nb\r\n"},
+			do:             []Edit{EditFile("a")},
+			want:           "{.}a\nb\n{.}",
+			wantLineEnding: CRLF,
+		},
+		{
+			name:           "e detect lf",
+			files:          map[string]string{"a": "a\r\nb\nc\n"},
+			do:             []Edit{EditFile("a")},
+			want:           "{.}a\r\nb\nc\n{.}",
+			wantLineEnding: LF,
+		},
+		{
+			name:           "e mixed mostly crlf",
+			files:          map[string]string{"a": "a\r\nb\r\nc\n"},
+			do:             []Edit{EditFile("a")},
+			want:           "{.}a\r\nb\r\nc\n{.}",
+			wantLineEnding: LF,
+		},
+		{
+			name:           "e mixed write round trip",
+			files:          map[string]string{"a": "a\r\nb\r\nc\n"},
+			do:             []Edit{EditFile("a"), WriteFile(All, "b")},
+			want:           "{.}a\r\nb\r\nc\n{.}",
+			wantFiles:      map[string]string{"b": "a\r\nb\r\nc\n"},
+			wantLineEnding: LF,
+		},
+		{
+			name:  "e crlf edit and write",
+			files: map[string]string{"a": "a\r\nb\r\n"},
+			do: []Edit{
+				EditFile("a"),
+				Substitute{Address: All, Regexp: "b$", With: "c"},
+				Append(End, "d\n"),
+				WriteFile(All, ""),
+			},
+			want:           "{.}a\nc\nd\n{.}",
+			wantFiles:      map[string]string{"a": "a\r\nc\r\nd\r\n"},
+			wantLineEnding: CRLF,
+		},
+		{
+			name:           "e crlf line address",
+			files:          map[string]string{"a": "a\r\nb\r\n"},
+			do:             []Edit{EditFile("a"), Print(Line(2))},
+			want:           "a\n{.}b\n{.}",
+			print:          "b\n",
+			wantLineEnding: CRLF,
+		},
+		{
+			name:           "e crlf utf-16",
+			files:          map[string]string{"a": "\xFF\xFEa\x00\r\x00\n\x00"},
+			do:             []Edit{EditFile("a"), WriteFile(All, "")},
+			want:           "{.}a\n{.}",
+			wantLineEnding: CRLF,
+		},
+		{
+			name:           "e explicit line ending",
+			files:          map[string]string{"a": "a\nb\r\nc\r\n"},
+			fileName:       "a",
+			lineEnding:     LF,
+			do:             []Edit{EditFile("")},
+			want:           "{.}a\nb\r\nc\r\n{.}",
+			wantLineEnding: LF,
+		},
+		{
+			name:           "r detects",
+			files:          map[string]string{"a": "x", "b": "b\r\n"},
+			fileName:       "a",
+			lineEnding:     LF,
+			do:             []Edit{ReadFile(All, "b")},
+			want:           "{.}b\n{.}",
+			wantLineEnding: LF,
+		},
+		{
+			name:           "N converts",
+			files:          map[string]string{"a": "a\r\nb\r\n"},
+			do:             []Edit{EditFile("a"), FileLineEnding("lf"), WriteFile(All, "")},
+			want:           "{.}a\nb\n{.}",
+			print:          "lf\n",
+			wantFiles:      map[string]string{"a": "a\nb\n"},
+			wantLineEnding: LF,
+		},
+		{
+			name:           "N print",
+			lineEnding:     CRLF,
+			do:             []Edit{FileLineEnding("")},
+			print:          "crlf\n",
+			wantLineEnding: CRLF,
+		},
+		{
+			name:           "N unknown",
+			lineEnding:     CRLF,
+			do:             []Edit{FileLineEnding("zzz")},
+			error:          "unknown line ending",
+			wantLineEnding: CRLF,
+		},
+		{
+			name:           "w auto is lf",
+			do:             []Edit{Append(All, "a\nb\n"), WriteFile(All, "a")},
+			want:           "{.}a\nb\n{.}",
+			wantFiles:      map[string]string{"a": "a\nb\n"},
+			wantLineEnding: AutoLineEnding,
+		},
+	}
+	for _, test := range tests {
+		files := make(memFS)
+		wantFiles := make(memFS)
+		for k, v := range test.files {
+			files[k] = v
+			wantFiles[k] = v
+		}
+		for k, v := range test.wantFiles {
+			wantFiles[k] = v
+		}
+		buf := NewBuffer()
+		buf.SetFileSystem(files)
+		buf.SetFileName(test.fileName)
+		buf.SetLineEnding(test.lineEnding)
+		print := bytes.NewBuffer(nil)
+		var err error
+		for _, e := range test.do {
+			if err = e.Do(buf, print); err != nil {
+				break
+			}
+		}
+		if !matchesError(test.error, err) {
+			t.Errorf("%s: Do(…)=%v, want %q", test.name, err, test.error)
+		}
+		if test.error == "" && test.want != "" && !hasState(buf, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, stateString(buf), test.want)
+		}
+		if got := print.String(); got != test.print {
+			t.Errorf("%s: printed %q, want %q", test.name, got, test.print)
+		}
+		for k, v := range wantFiles {
+			if files[k] != v {
+				t.Errorf("%s: file %q=%q, want %q", test.name, k, files[k], v)
+			}
+		}
+		if nl := buf.LineEnding(); nl != test.wantLineEnding {
+			t.Errorf("%s: line ending=%v, want %v", test.name, nl, test.wantLineEnding)
+		}
+		buf.Close()
+	}
+}
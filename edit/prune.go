@@ -0,0 +1,231 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import "time"
+
+// RuneBytes is the number of bytes used to store a rune in the history log.
+const runeBytes = 4
+
+// UndoLimits are limits on the resources used by the undo tree of a Buffer.
+// A zero field is no limit.
+//
+// The limits are enforced by Apply and SetUndoLimits,
+// which prune the oldest states of the tree until the limits are met.
+// Pruning removes the state with the least ID other than the root.
+// If the state is on the path from the root to the current state,
+// it becomes the new root, and the changes that created it
+// can no longer be undone.
+// The old root is pruned, along with all of its other descendants.
+// Otherwise, the state is pruned along with all of its descendants,
+// and their changes can no longer be redone.
+// The current state is never pruned.
+// Checkpoints of pruned states are removed.
+type UndoLimits struct {
+	// Depth is the maximum depth of the current state:
+	// the number of changes that can be undone by Undo.
+	Depth int
+
+	// Bytes is the maximum number of bytes
+	// used by the changes of the states of the tree.
+	Bytes int64
+
+	// Age is the maximum age of a state other than the root.
+	Age time.Duration
+}
+
+// UndoUsage describes the resources used by the undo tree of a Buffer.
+type UndoUsage struct {
+	// States is the number of states in the tree.
+	States int
+
+	// Root is the ID of the root state.
+	// It is 0 until the initial root is pruned.
+	// Every state in the tree has an ID no less than the root.
+	Root int
+
+	// Depth is the depth of the current state:
+	// the number of changes that can be undone by Undo.
+	Depth int
+
+	// Bytes is the number of bytes
+	// used by the changes of the states of the tree.
+	Bytes int64
+
+	// LogBytes is the number of bytes
+	// used by the log that records the changes.
+	// It includes the changes of pruned states
+	// that have not yet been reclaimed.
+	LogBytes int64
+
+	// Oldest is the time at which the oldest state
+	// other than the root was created.
+	// If the root is the only state, Oldest is the zero time.
+	Oldest time.Time
+}
+
+// UndoLimits returns the limits of the Buffer's undo tree.
+func (buf *Buffer) UndoLimits() UndoLimits { return buf.undoLimits }
+
+// SetUndoLimits sets the limits of the Buffer's undo tree,
+// and prunes the tree to meet them.
+func (buf *Buffer) SetUndoLimits(l UndoLimits) error {
+	buf.undoLimits = l
+	return buf.prune()
+}
+
+// UndoUsage returns the resources used by the Buffer's undo tree.
+func (buf *Buffer) UndoUsage() UndoUsage {
+	root := buf.states[0]
+	u := UndoUsage{
+		States:   len(buf.states),
+		Root:     root.id,
+		Depth:    buf.state.depth - root.depth,
+		Bytes:    buf.undoRunes * runeBytes,
+		LogBytes: buf.history.buf.Size() * runeBytes,
+	}
+	if len(buf.states) > 1 {
+		u.Oldest = buf.states[1].time
+	}
+	return u
+}
+
+// OverLimits returns whether the undo tree exceeds its limits.
+func (buf *Buffer) overLimits(now time.Time) bool {
+	l, u := buf.undoLimits, buf.UndoUsage()
+	return l.Depth > 0 && u.Depth > l.Depth ||
+		l.Bytes > 0 && u.Bytes > l.Bytes ||
+		l.Age > 0 && u.States > 1 && now.Sub(u.Oldest) > l.Age
+}
+
+// Prune prunes the oldest states of the undo tree until it meets its limits.
+// The history log is compacted once it is mostly pruned entries.
+func (buf *Buffer) prune() error {
+	if buf.undoLimits == (UndoLimits{}) {
+		return nil
+	}
+	now := time.Now()
+	var pruned bool
+	for len(buf.states) > 1 && buf.overLimits(now) {
+		buf.pruneOldest()
+		pruned = true
+	}
+	if !pruned {
+		return nil
+	}
+	buf.forgetPruned()
+	if dead := buf.history.buf.Size() - buf.undoRunes; dead > buf.undoRunes {
+		return buf.compactHistory()
+	}
+	return nil
+}
+
+// PruneOldest prunes the state with the least ID other than the root.
+func (buf *Buffer) pruneOldest() {
+	// The parent of the oldest state is the root,
+	// since parents are older than their children.
+	root, s := buf.states[0], buf.states[1]
+	a := buf.state
+	for a.depth > s.depth {
+		a = a.parent
+	}
+	if a == s {
+		root.pruned = true
+		buf.undoRunes -= s.size
+		s.parent = nil
+		s.first, s.last, s.n, s.size = 0, 0, 0, 0
+	} else {
+		s.pruned = true
+	}
+
+	// Parents precede their children,
+	// so pruning propagates to all descendants in one pass.
+	live := buf.states[:0]
+	for _, t := range buf.states {
+		if t.parent != nil && t.parent.pruned {
+			t.pruned = true
+		}
+		if t.pruned {
+			buf.undoRunes -= t.size
+			continue
+		}
+		live = append(live, t)
+	}
+	for i := len(live); i < len(buf.states); i++ {
+		buf.states[i] = nil
+	}
+	buf.states = live
+}
+
+// ForgetPruned removes references to pruned states.
+func (buf *Buffer) forgetPruned() {
+	for _, s := range buf.states {
+		if s.next != nil && s.next.pruned {
+			s.next = nil
+		}
+	}
+	for name, s := range buf.checkpoints {
+		if s.pruned {
+			delete(buf.checkpoints, name)
+		}
+	}
+	// A run of sequence numbers with pruned states
+	// is collapsed to a single entry with a nil state.
+	seqs := buf.seqs[:0]
+	for _, q := range buf.seqs {
+		if q.state != nil && q.state.pruned {
+			q.state = nil
+		}
+		if q.state == nil && (len(seqs) == 0 || seqs[len(seqs)-1].state == nil) {
+			continue
+		}
+		seqs = append(seqs, q)
+	}
+	buf.seqs = seqs
+	own := buf.own[:0]
+	for _, id := range buf.own {
+		if buf.stateByID(id) != nil {
+			own = append(own, id)
+		}
+	}
+	buf.own = own
+}
+
+// CompactHistory replaces the history log
+// with a new log containing only the entries of the states of the tree,
+// and closes the old log, reclaiming its storage.
+// On error, the history log is unchanged.
+func (buf *Buffer) compactHistory() error {
+	log := newLog(buf.storage)
+	offs := make([][2]int64, len(buf.states))
+	for i, s := range buf.states {
+		if s.n == 0 {
+			continue
+		}
+		offs[i][0] = log.buf.Size()
+		e := logAt(buf.history, s.first)
+		for j := 0; j < 2*s.n; j++ {
+			if e.err != nil {
+				log.close()
+				return e.err
+			}
+			if _, err := log.append(e.seq, e.span, e.data()); err != nil {
+				log.close()
+				return err
+			}
+			e = e.next()
+		}
+		offs[i][1] = log.last
+	}
+	if err := buf.history.close(); err != nil {
+		log.close()
+		return err
+	}
+	for i, s := range buf.states {
+		if s.n > 0 {
+			s.first, s.last = offs[i][0], offs[i][1]
+		}
+	}
+	buf.history = log
+	return nil
+}
@@ -0,0 +1,247 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func doEdits(t *testing.T, buf *Buffer, edits ...Edit) {
+	for _, e := range edits {
+		if err := e.Do(buf, ioutil.Discard); err != nil {
+			t.Fatalf("%q.Do(…)=%v, want nil", e, err)
+		}
+	}
+}
+
+func undoIDs(buf *Buffer) []int {
+	var ids []int
+	for _, s := range buf.UndoStates() {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestUndoLimitsDepth(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	if err := buf.SetUndoLimits(UndoLimits{Depth: 2}); err != nil {
+		t.Fatalf("SetUndoLimits(…)=%v, want nil", err)
+	}
+	doEdits(t, buf,
+		Append(End, "a"),
+		Append(End, "b"),
+		Append(End, "c"),
+		Append(End, "d"),
+	)
+	u := buf.UndoUsage()
+	if u.States != 3 || u.Root != 2 || u.Depth != 2 {
+		t.Errorf("UndoUsage()=%+v, want States=3, Root=2, Depth=2", u)
+	}
+	if ids, want := undoIDs(buf), []int{2, 3, 4}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("UndoStates() IDs=%v, want %v", ids, want)
+	}
+	if err := buf.UndoTo(1); err != ErrNoUndoState {
+		t.Errorf("UndoTo(1)=%v, want %v", err, ErrNoUndoState)
+	}
+	doEdits(t, buf, Undo(100))
+	if s := buf.String(); s != "ab" {
+		t.Errorf("after Undo(100), text=%q, want %q", s, "ab")
+	}
+	doEdits(t, buf, Redo(100))
+	if s := buf.String(); s != "abcd" {
+		t.Errorf("after Redo(100), text=%q, want %q", s, "abcd")
+	}
+}
+
+func TestUndoLimitsPruneBranches(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	doEdits(t, buf,
+		Append(End, "a"), // 1
+		Undo(1),
+		Append(End, "b"), // 2
+		Append(End, "c"), // 3
+		Undo(1),
+		Append(End, "d"), // 4
+		Checkpoint("a"),
+		UndoTo(1),
+		Checkpoint("b"),
+		UndoTo(4),
+	)
+
+	// State 1 is not on the path to the current state,
+	// so its branch is pruned. Then state 2 is the new root.
+	if err := buf.SetUndoLimits(UndoLimits{Depth: 1}); err != nil {
+		t.Fatalf("SetUndoLimits(…)=%v, want nil", err)
+	}
+	if ids, want := undoIDs(buf), []int{2, 3, 4}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("UndoStates() IDs=%v, want %v", ids, want)
+	}
+	states := buf.UndoStates()
+	if states[0].Parent != -1 || states[0].Changes != 0 {
+		t.Errorf("root=%+v, want Parent=-1, Changes=0", states[0])
+	}
+	if !reflect.DeepEqual(states[2].Checkpoints, []string{"a"}) {
+		t.Errorf("state 4 Checkpoints=%v, want [a]", states[2].Checkpoints)
+	}
+	if err := UndoToCheckpoint("b").Do(buf, ioutil.Discard); err != ErrNoCheckpoint {
+		t.Errorf("UndoToCheckpoint(b)=%v, want %v", err, ErrNoCheckpoint)
+	}
+	doEdits(t, buf, UndoTo(3))
+	if s := buf.String(); s != "bc" {
+		t.Errorf("after UndoTo(3), text=%q, want %q", s, "bc")
+	}
+
+	// The root is current, so all other states are pruned.
+	doEdits(t, buf, UndoTo(2))
+	if err := buf.SetUndoLimits(UndoLimits{Bytes: 1}); err != nil {
+		t.Fatalf("SetUndoLimits(…)=%v, want nil", err)
+	}
+	if ids, want := undoIDs(buf), []int{2}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("UndoStates() IDs=%v, want %v", ids, want)
+	}
+	if u := buf.UndoUsage(); u.Bytes != 0 || u.LogBytes != 0 {
+		t.Errorf("UndoUsage()=%+v, want Bytes=0, LogBytes=0", u)
+	}
+	if s := buf.String(); s != "b" {
+		t.Errorf("text=%q, want %q", s, "b")
+	}
+}
+
+func TestUndoLimitsBytes(t *testing.T) {
+	const limit = 1 << 14
+	buf := NewBuffer()
+	defer buf.Close()
+	if err := buf.SetUndoLimits(UndoLimits{Bytes: limit}); err != nil {
+		t.Fatalf("SetUndoLimits(…)=%v, want nil", err)
+	}
+	for i := 0; i < 100; i++ {
+		doEdits(t, buf, Change(All, strings.Repeat(string('a'+rune(i%26)), 100)))
+		u := buf.UndoUsage()
+		if u.Bytes > limit {
+			t.Fatalf("UndoUsage().Bytes=%d, want ≤ %d", u.Bytes, limit)
+		}
+		if u.LogBytes > 2*u.Bytes {
+			t.Fatalf("UndoUsage().LogBytes=%d, want ≤ %d", u.LogBytes, 2*u.Bytes)
+		}
+	}
+	u := buf.UndoUsage()
+	if u.States < 2 || u.States > 100 {
+		t.Fatalf("UndoUsage().States=%d, want in [2, 100]", u.States)
+	}
+	doEdits(t, buf, Undo(1))
+	if s, want := buf.String(), strings.Repeat("u", 100); s != want {
+		t.Errorf("after Undo(1), text=%q, want %q", s, want)
+	}
+	doEdits(t, buf, Undo(1000))
+	if u := buf.UndoUsage(); u.Depth != 0 {
+		t.Errorf("after Undo(1000), UndoUsage().Depth=%d, want 0", u.Depth)
+	}
+}
+
+func TestUndoLimitsAge(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	doEdits(t, buf, Append(End, "a"), Append(End, "b"), Append(End, "c"))
+	old := time.Now().Add(-2 * time.Hour)
+	buf.states[1].time = old
+	buf.states[2].time = old
+	if u := buf.UndoUsage(); !u.Oldest.Equal(old) {
+		t.Errorf("UndoUsage().Oldest=%v, want %v", u.Oldest, old)
+	}
+	if err := buf.SetUndoLimits(UndoLimits{Age: time.Hour}); err != nil {
+		t.Fatalf("SetUndoLimits(…)=%v, want nil", err)
+	}
+	if ids, want := undoIDs(buf), []int{2, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("UndoStates() IDs=%v, want %v", ids, want)
+	}
+	doEdits(t, buf, Undo(100))
+	if s := buf.String(); s != "ab" {
+		t.Errorf("after Undo(100), text=%q, want %q", s, "ab")
+	}
+}
+
+func TestUndoLimitsSequence(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	doEdits(t, buf,
+		Append(End, "a"),
+		Append(End, "b"),
+		Print(All),
+		Append(End, "c"),
+	)
+	if err := buf.SetUndoLimits(UndoLimits{Depth: 1}); err != nil {
+		t.Fatalf("SetUndoLimits(…)=%v, want nil", err)
+	}
+	for _, seq := range []int{0, 1} {
+		if err := buf.UndoToSequence(seq); err != ErrNoUndoState {
+			t.Errorf("UndoToSequence(%d)=%v, want %v", seq, err, ErrNoUndoState)
+		}
+	}
+	// Print does not change the sequence number.
+	if err := buf.UndoToSequence(2); err != nil {
+		t.Fatalf("UndoToSequence(2)=%v, want nil", err)
+	}
+	if s := buf.String(); s != "ab" {
+		t.Errorf("after UndoToSequence(2), text=%q, want %q", s, "ab")
+	}
+}
+
+func TestUndoLimitsSelectiveUndo(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	doEdits(t, buf, Append(End, "a"), Append(End, "b"))
+	if err := buf.SetUndoLimits(UndoLimits{Depth: 1}); err != nil {
+		t.Fatalf("SetUndoLimits(…)=%v, want nil", err)
+	}
+	doEdits(t, buf, SelectiveUndo(2))
+	if s := buf.String(); s != "a" {
+		t.Errorf("after SelectiveUndo(2), text=%q, want %q", s, "a")
+	}
+}
+
+func TestWriteReadPrunedHistory(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	if err := buf.SetUndoLimits(UndoLimits{Depth: 2}); err != nil {
+		t.Fatalf("SetUndoLimits(…)=%v, want nil", err)
+	}
+	doEdits(t, buf,
+		Append(End, "a"),
+		Append(End, "b"),
+		Checkpoint("b"),
+		Append(End, "c"),
+		Append(End, "d"),
+	)
+	history := bytes.NewBuffer(nil)
+	if err := buf.WriteHistory(history); err != nil {
+		t.Fatalf("WriteHistory(…)=%v, want nil", err)
+	}
+
+	reopened := NewBuffer()
+	defer reopened.Close()
+	doEdits(t, reopened, Append(End, "abcd"))
+	if err := reopened.ReadHistory(history); err != nil {
+		t.Fatalf("ReadHistory(…)=%v, want nil", err)
+	}
+	if ids, want := undoIDs(reopened), []int{2, 3, 4}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("UndoStates() IDs=%v, want %v", ids, want)
+	}
+	if got, want := reopened.UndoUsage(), buf.UndoUsage(); got.Depth != want.Depth || got.Bytes != want.Bytes {
+		t.Errorf("UndoUsage()=%+v, want %+v", got, want)
+	}
+	doEdits(t, reopened, UndoToCheckpoint("b"), Undo(1))
+	if s := reopened.String(); s != "ab" {
+		t.Errorf("after undo, text=%q, want %q", s, "ab")
+	}
+	doEdits(t, reopened, Append(End, "x"))
+	if ids, want := undoIDs(reopened), []int{2, 3, 4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("UndoStates() IDs=%v, want %v", ids, want)
+	}
+}
@@ -81,3 +81,83 @@ func BenchmarkRune10kRand(b *testing.B)   { benchmarkRune(b, 1048576, true) }
 func BenchmarkRune10kScan(b *testing.B)   { benchmarkRune(b, 1048576, false) }
 func BenchmarkRuneCacheRand(b *testing.B) { benchmarkRune(b, benchBlockSize, true) }
 func BenchmarkRuneCacheScan(b *testing.B) { benchmarkRune(b, benchBlockSize, false) }
+
+// manyBlocksSize is the block size of benchmarks
+// that spread a buffer over many blocks.
+const manyBlocksSize = 64
+
+func benchmarkRuneManyBlocks(b *testing.B, n int) {
+	r := NewBuffer(manyBlocksSize)
+	defer r.Close()
+	r.Insert(randomRunes(n), 0)
+
+	inds := make([]int64, 4096)
+	for i := range inds {
+		inds[i] = rand.Int63n(int64(n))
+	}
+
+	b.SetBytes(runeBytes)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Rune(inds[i%len(inds)])
+	}
+}
+
+func BenchmarkRuneManyBlocks1k(b *testing.B)  { benchmarkRuneManyBlocks(b, 1024) }
+func BenchmarkRuneManyBlocks64k(b *testing.B) { benchmarkRuneManyBlocks(b, 65536) }
+func BenchmarkRuneManyBlocks1M(b *testing.B)  { benchmarkRuneManyBlocks(b, 1048576) }
+
+func benchmarkEditManyBlocks(b *testing.B, n int) {
+	r := NewBuffer(manyBlocksSize)
+	defer r.Close()
+	r.Insert(randomRunes(n), 0)
+
+	inds := make([]int64, 4096)
+	for i := range inds {
+		inds[i] = rand.Int63n(int64(n))
+	}
+	rs := randomRunes(manyBlocksSize / 2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		at := inds[i%len(inds)]
+		if err := r.Insert(rs, at); err != nil {
+			b.Fatal(err.Error())
+		}
+		if err := r.Delete(int64(len(rs)), at); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkEditManyBlocks1k(b *testing.B)  { benchmarkEditManyBlocks(b, 1024) }
+func BenchmarkEditManyBlocks64k(b *testing.B) { benchmarkEditManyBlocks(b, 65536) }
+func BenchmarkEditManyBlocks1M(b *testing.B)  { benchmarkEditManyBlocks(b, 1048576) }
+
+func benchmarkNewlines(b *testing.B, n int) {
+	r := NewBuffer(manyBlocksSize)
+	defer r.Close()
+	rs := randomRunes(n)
+	for i := range rs {
+		if rand.Intn(30) == 0 {
+			rs[i] = '\n'
+		}
+	}
+	r.Insert(rs, 0)
+
+	inds := make([]int64, 4096)
+	for i := range inds {
+		inds[i] = rand.Int63n(int64(n))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Newlines(inds[i%len(inds)]); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkNewlines1k(b *testing.B)  { benchmarkNewlines(b, 1024) }
+func BenchmarkNewlines64k(b *testing.B) { benchmarkNewlines(b, 65536) }
+func BenchmarkNewlines1M(b *testing.B)  { benchmarkNewlines(b, 1048576) }
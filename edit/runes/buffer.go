@@ -6,9 +6,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
-	"io/ioutil"
 	"os"
 	"strconv"
+	"sync"
+	"unicode/utf8"
 )
 
 // RuneBytes is the number of bytes in Go's rune type.
@@ -16,18 +17,22 @@ const runeBytes = 4
 
 // A Buffer is an unbounded rune buffer backed by a file.
 type Buffer struct {
-	// F is the file that backs the buffer. It is created lazily.
-	f ReaderWriterAt
+	// F is the file that backs the buffer.
+	// It is created lazily by storage.
+	f       ReaderWriterAt
+	storage Storage
 	// BlockSize is the maximum number of runes in a block.
 	blockSize int
 	// Blocks contains all blocks of the buffer in order.
+	blocks blockTree
 	// Free contains blocks that are free to be re-allocated.
-	blocks, free []block
+	free []block
 	// End is the byte offset of the end of the backing file.
 	end int64
 
-	// Cache is the index of the block whose data is currently cached.
-	cached int
+	// Cached is the block whose data is currently cached,
+	// or nil if no block is cached.
+	cached *block
 	// Cached0 is the address of the first rune in the cached block.
 	cached0 int64
 	// Cache is the cached data.
@@ -37,6 +42,25 @@ type Buffer struct {
 
 	// Size is the number of runes in the buffer.
 	size int64
+
+	// Retired contains blocks of the file
+	// that are no longer used by the buffer,
+	// but may still be used by its snapshots.
+	retired []retiredBlock
+
+	// Mu protects the fields below,
+	// which are shared with the buffer's snapshots.
+	mu sync.Mutex
+	// Snaps are the generations of the open snapshots,
+	// in increasing order.
+	snaps []int
+	// Released is whether a snapshot was closed
+	// since retired blocks were last reclaimed.
+	released bool
+	// Closed is whether the buffer is closed.
+	// The file of a closed buffer is closed
+	// when its last snapshot is closed.
+	closed bool
 }
 
 // A ReaderWriterAt implements the io.ReaderAt and io.WriterAt interfaces.
@@ -51,14 +75,25 @@ type block struct {
 	start int64
 	// N is the number of runes in the block.
 	n int
+	// NB is the number of bytes in the UTF-8 encoding of the block.
+	nb int
+	// NL is the number of newlines in the block.
+	nl int
+	// Gen is the generation of the block tree
+	// in which the block's location in the file was allocated.
+	gen int
 }
 
-// NewBuffer returns a new, empty buffer.
+// NewBuffer returns a new, empty buffer backed by a temporary file.
 // No more than blockSize runes are cached in memory.
-func NewBuffer(blockSize int) *Buffer {
+func NewBuffer(blockSize int) *Buffer { return NewBufferStorage(blockSize, FileStorage) }
+
+// NewBufferStorage is like NewBuffer
+// but uses the given Storage to create its backing store.
+func NewBufferStorage(blockSize int, s Storage) *Buffer {
 	return &Buffer{
 		blockSize: blockSize,
-		cached:    -1,
+		storage:   s,
 		cache:     make([]rune, blockSize),
 	}
 }
@@ -74,20 +109,17 @@ func NewBufferReaderWriterAt(blockSize int, f ReaderWriterAt) *Buffer {
 }
 
 // Close closes the buffer and removes it's backing file.
+// If the buffer has open snapshots,
+// the file is closed when the last of them is closed.
 func (b *Buffer) Close() error {
 	b.cache = nil
-	switch f := b.f.(type) {
-	case *os.File:
-		path := f.Name()
-		if err := f.Close(); err != nil {
-			return err
-		}
-		return os.Remove(path)
-	case io.Closer:
-		return f.Close()
-	default:
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if len(b.snaps) > 0 {
 		return nil
 	}
+	return closeFile(b.f)
 }
 
 // Size returns the number of runes in the buffer.
@@ -99,7 +131,7 @@ func (b *Buffer) Rune(offs int64) (rune, error) {
 	if offs < 0 || offs >= b.Size() {
 		panic("rune index out of bounds")
 	}
-	if q0 := b.cached0; b.cached >= 0 && q0 <= offs && offs < q0+int64(b.blocks[b.cached].n) {
+	if q0 := b.cached0; b.cached != nil && q0 <= offs && offs < q0+int64(b.cached.n) {
 		return b.cache[offs-q0], nil
 	}
 	i, q0 := b.blockAt(offs)
@@ -201,11 +233,14 @@ func (dst *readerFrom) ReadFrom(r Reader) (int64, error) {
 func fastReadFrom(dst *readerFrom, r Reader, sz int64) (int64, error) {
 	var tot int64
 	for tot < sz {
-		p, err := dst.makeSpace(sz-tot, dst.pos+tot)
+		i, p, err := dst.makeSpace(sz-tot, dst.pos+tot)
 		if err != nil {
 			return tot, err
 		}
 		n, err := readFull(r, p)
+		dst.cached.nb += countBytes(p[:n])
+		dst.cached.nl += countNewlines(p[:n])
+		dst.blocks.fix(i)
 		tot += int64(n)
 		if err != nil {
 			return tot, err
@@ -259,39 +294,40 @@ func readLen(r Reader) (int64, bool) {
 
 // MakeSpace makes space in the buffer
 // for up to n runes at the given address
-// and returns a slice of the cache
-// corresponding to the space.
-func (b *Buffer) makeSpace(n, at int64) ([]rune, error) {
+// and returns the index of the cached block containing the space
+// and a slice of the cache corresponding to the space.
+// The caller must update the byte and newline counts of the block
+// after filling the space.
+func (b *Buffer) makeSpace(n, at int64) (int, []rune, error) {
 	var i int
 	var blkStart int64
 	switch {
-	case len(b.blocks) == 0:
+	case b.blocks.len() == 0:
 		// Insert the initial block.
 		var err error
 		i, err = b.insertAt(at)
 		if err != nil {
-			return nil, err
+			return -1, nil, err
 		}
 	case at == b.Size():
 		// Try to extend the last block if we are inserting on the end.
-		for _, blk := range b.blocks[:len(b.blocks)-1] {
-			blkStart += int64(blk.n)
-		}
-		i = len(b.blocks) - 1
+		var before blockSum
+		i, _, before = b.blocks.last()
+		blkStart = before.runes
 	default:
 		i, blkStart = b.blockAt(at)
 	}
 	blk, err := b.get(i)
 	if err != nil {
-		return nil, err
+		return -1, nil, err
 	}
 	blkSpace := b.blockSize - blk.n
 	if blkSpace == 0 {
 		if i, err = b.insertAt(at); err != nil {
-			return nil, err
+			return -1, nil, err
 		}
 		if blk, err = b.get(i); err != nil {
-			return nil, err
+			return -1, nil, err
 		}
 		blkStart = at
 		blkSpace = b.blockSize
@@ -303,12 +339,14 @@ func (b *Buffer) makeSpace(n, at int64) ([]rune, error) {
 	copy(b.cache[cacheOffs+blkSpace:], b.cache[cacheOffs:blk.n])
 	b.dirty = true
 	blk.n += blkSpace
+	b.blocks.fix(i)
 	b.size += int64(blkSpace)
-	return b.cache[cacheOffs : cacheOffs+blkSpace], nil
+	return i, b.cache[cacheOffs : cacheOffs+blkSpace], nil
 }
 
 // Delete deletes runes from the buffer starting at the given offset.
 // It is an error to delete out of the range of the buffer.
+// The buffer may be compacted after the delete; see Compact.
 func (b *Buffer) Delete(n, offs int64) error {
 	if n < 0 {
 		panic("bad count: " + strconv.FormatInt(n, 10))
@@ -330,43 +368,54 @@ func (b *Buffer) Delete(n, offs int64) error {
 		if o == 0 && n >= int64(blk.n) {
 			// Remove the entire block.
 			b.freeBlock(*blk)
-			b.blocks = append(b.blocks[:i], b.blocks[i+1:]...)
+			b.blocks.remove(i)
 			b.cached0 = -1
-			b.cached = -1
+			b.cached = nil
 		} else {
 			// Remove a portion of the block.
+			blk.nb -= countBytes(b.cache[o : o+m])
+			blk.nl -= countNewlines(b.cache[o : o+m])
 			copy(b.cache[o:], b.cache[o+m:])
 			b.dirty = true
 			blk.n -= m
+			b.blocks.fix(i)
 		}
 		n -= int64(m)
 		b.size -= int64(m)
 	}
+	b.autoCompact()
 	return nil
 }
 
 // Reset resets the buffer to empty.
 func (b *Buffer) Reset() {
-	for _, blk := range b.blocks {
-		b.freeBlock(blk)
-	}
-	b.blocks = b.blocks[:0]
-	b.cached = -1
+	b.blocks.each(b.freeBlock)
+	b.blocks = blockTree{gen: b.blocks.gen}
+	b.cached = nil
 	b.size = 0
+	b.autoCompact()
 }
 
 func (b *Buffer) allocBlock() block {
+	b.reclaim()
 	if l := len(b.free); l > 0 {
 		blk := b.free[l-1]
 		b.free = b.free[:l-1]
+		blk.gen = b.blocks.gen
 		return blk
 	}
-	blk := block{start: b.end}
+	blk := block{start: b.end, gen: b.blocks.gen}
 	b.end += int64(b.blockSize * runeBytes)
 	return blk
 }
 
+// FreeBlock frees the block's location in the file,
+// or retires it if it may be used by a snapshot.
 func (b *Buffer) freeBlock(blk block) {
+	if b.shared(blk) {
+		b.retire(blk)
+		return
+	}
 	b.free = append(b.free, block{start: blk.start})
 }
 
@@ -376,70 +425,179 @@ func (b *Buffer) blockAt(at int64) (int, int64) {
 	if at < 0 || at >= b.Size() {
 		panic("invalid offset: " + strconv.FormatInt(at, 10))
 	}
-	var q0 int64
-	for i, blk := range b.blocks {
-		if q0 <= at && at < q0+int64(blk.n) {
-			return i, q0
+	i, _, before := b.blocks.search(func(s blockSum) bool { return s.runes > at })
+	if i < 0 {
+		panic("impossible")
+	}
+	return i, before.runes
+}
+
+// Newlines returns the number of newlines before the given offset.
+// If the offset is out of range it panics.
+func (b *Buffer) Newlines(offs int64) (int64, error) {
+	if offs < 0 || offs > b.Size() {
+		panic("invalid offset: " + strconv.FormatInt(offs, 10))
+	}
+	if offs == b.Size() {
+		return b.blocks.total().newlines, nil
+	}
+	i, _, before := b.blocks.search(func(s blockSum) bool { return s.runes > offs })
+	if _, err := b.get(i); err != nil {
+		return 0, err
+	}
+	return before.newlines + int64(countNewlines(b.cache[:offs-before.runes])), nil
+}
+
+// Newline returns the offset of the nth newline in the buffer,
+// where the first newline is n=0.
+// If there are n or fewer newlines in the buffer, Newline panics.
+func (b *Buffer) Newline(n int64) (int64, error) {
+	if n < 0 || n >= b.blocks.total().newlines {
+		panic("newline index out of bounds")
+	}
+	// The block containing the newline is the first block
+	// with more than n newlines up to its end.
+	i, _, before := b.blocks.search(func(s blockSum) bool { return s.newlines > n })
+	blk, err := b.get(i)
+	if err != nil {
+		return 0, err
+	}
+	n -= before.newlines
+	for j, r := range b.cache[:blk.n] {
+		if r != '\n' {
+			continue
 		}
-		q0 += int64(blk.n)
+		if n == 0 {
+			return before.runes + int64(j), nil
+		}
+		n--
 	}
 	panic("impossible")
 }
 
+// Bytes returns the number of bytes in the UTF-8 encoding
+// of the runes before the given offset.
+// Invalid runes are encoded as utf8.RuneError.
+// If the offset is out of range it panics.
+func (b *Buffer) Bytes(offs int64) (int64, error) {
+	if offs < 0 || offs > b.Size() {
+		panic("invalid offset: " + strconv.FormatInt(offs, 10))
+	}
+	if offs == b.Size() {
+		return b.blocks.total().bytes, nil
+	}
+	i, _, before := b.blocks.search(func(s blockSum) bool { return s.runes > offs })
+	if _, err := b.get(i); err != nil {
+		return 0, err
+	}
+	return before.bytes + int64(countBytes(b.cache[:offs-before.runes])), nil
+}
+
+// Byte returns the offset of the rune
+// whose UTF-8 encoding contains the nth byte of the buffer,
+// where the first byte is n=0.
+// If there are n or fewer bytes in the buffer, Byte panics.
+func (b *Buffer) Byte(n int64) (int64, error) {
+	if n < 0 || n >= b.blocks.total().bytes {
+		panic("byte index out of bounds")
+	}
+	i, _, before := b.blocks.search(func(s blockSum) bool { return s.bytes > n })
+	blk, err := b.get(i)
+	if err != nil {
+		return 0, err
+	}
+	n -= before.bytes
+	for j, r := range b.cache[:blk.n] {
+		if n -= int64(runeLen(r)); n < 0 {
+			return before.runes + int64(j), nil
+		}
+	}
+	panic("impossible")
+}
+
+func countBytes(rs []rune) int {
+	var n int
+	for _, r := range rs {
+		n += runeLen(r)
+	}
+	return n
+}
+
+// RuneLen returns the number of bytes in the UTF-8 encoding of r.
+// An invalid rune is encoded as utf8.RuneError.
+func runeLen(r rune) int {
+	if n := utf8.RuneLen(r); n > 0 {
+		return n
+	}
+	return utf8.RuneLen(utf8.RuneError)
+}
+
+func countNewlines(rs []rune) int {
+	var n int
+	for _, r := range rs {
+		if r == '\n' {
+			n++
+		}
+	}
+	return n
+}
+
 // insertAt inserts a block at the address and returns the new block's index.
 // If a block contains the address then it is split.
 func (b *Buffer) insertAt(at int64) (int, error) {
 	if at == b.Size() {
-		b.blocks = append(b.blocks, b.allocBlock())
-		return len(b.blocks) - 1, nil
+		i := b.blocks.len()
+		b.blocks.insert(i, b.allocBlock())
+		return i, nil
 	}
 
 	i, q0 := b.blockAt(at)
 	o := int(at - q0)
-	blk := b.blocks[i]
 	if at == q0 {
 		// Adding immediately before blk, no need to split.
-		nblk := b.allocBlock()
-		b.blocks = append(b.blocks[:i], append([]block{nblk}, b.blocks[i:]...)...)
-		if b.cached == i {
-			b.cached = i + 1
-		}
+		b.blocks.insert(i, b.allocBlock())
 		return i, nil
 	}
 
 	// Splitting blk.
 	// Make sure it's both on disk and in the cache.
-	if b.cached == i && b.dirty {
-		if err := b.put(); err != nil {
-			return -1, err
-		}
-	} else if _, err := b.get(i); err != nil {
+	blk, err := b.get(i)
+	if err != nil {
+		return -1, err
+	}
+	if err := b.put(); err != nil {
 		return -1, err
 	}
+	n, nb, nl := blk.n, blk.nb, blk.nl
 
 	// Resize blk.
-	b.blocks[i].n = int(o)
+	blk.n = o
+	blk.nb = countBytes(b.cache[:o])
+	blk.nl = countNewlines(b.cache[:o])
+	b.blocks.fix(i)
 
 	// Insert the new, empty block.
-	nblk := b.allocBlock()
-	b.blocks = append(b.blocks[:i+1], append([]block{nblk}, b.blocks[i+1:]...)...)
+	b.blocks.insert(i+1, b.allocBlock())
 
 	// Allocate a block for the second half of blk and set it as the cache.
 	// The next put will write it out.
-	nblk = b.allocBlock()
-	b.blocks = append(b.blocks[:i+2], append([]block{nblk}, b.blocks[i+2:]...)...)
-	b.blocks[i+2].n = blk.n - o
+	second := b.allocBlock()
+	second.n = n - o
+	second.nb = nb - blk.nb
+	second.nl = nl - blk.nl
+	b.blocks.insert(i+2, second)
 	copy(b.cache, b.cache[o:])
-	b.cached = i + 2
+	b.cached, _ = b.blocks.mut(i + 2)
+	b.cached0 = at
 	b.dirty = true
 
 	return i + 1, nil
 }
 
-// File returns an *os.File, creating a new file if one is not created yet.
+// File returns the backing store, creating it if it is not created yet.
 func (b *Buffer) file() (ReaderWriterAt, error) {
 	if b.f == nil {
-		f, err := ioutil.TempFile(os.TempDir(), "edit")
+		f, err := b.storage()
 		if err != nil {
 			return nil, err
 		}
@@ -450,10 +608,17 @@ func (b *Buffer) file() (ReaderWriterAt, error) {
 
 // Put writes the cached block back to the file.
 func (b *Buffer) put() error {
-	if b.cached < 0 || !b.dirty || len(b.cache) == 0 {
+	if b.cached == nil || !b.dirty || len(b.cache) == 0 {
 		return nil
 	}
-	blk := b.blocks[b.cached]
+	if b.shared(*b.cached) {
+		// Write the changed block to a new location,
+		// leaving the old one to the snapshots.
+		b.retire(*b.cached)
+		b.cached.start = b.allocBlock().start
+		b.cached.gen = b.blocks.gen
+	}
+	blk := *b.cached
 	f, err := b.file()
 	if err != nil {
 		return err
@@ -471,15 +636,16 @@ func (b *Buffer) put() error {
 
 // Get loads the cache with the data from the block at the given index,
 // returning a pointer to it.
+// The block may be modified, after which blocks.fix must be called.
 func (b *Buffer) get(i int) (*block, error) {
-	if b.cached == i {
-		return &b.blocks[i], nil
+	blk, before := b.blocks.mut(i)
+	if b.cached == blk {
+		return blk, nil
 	}
 	if err := b.put(); err != nil {
 		return nil, err
 	}
 
-	blk := b.blocks[i]
 	f, err := b.file()
 	if err != nil {
 		return nil, err
@@ -497,11 +663,8 @@ func (b *Buffer) get(i int) (*block, error) {
 		bs = bs[runeBytes:]
 		j++
 	}
-	b.cached = i
+	b.cached = blk
 	b.dirty = false
-	b.cached0 = 0
-	for j := 0; j < i; j++ {
-		b.cached0 += int64(b.blocks[j].n)
-	}
-	return &b.blocks[i], nil
+	b.cached0 = before.runes
+	return blk, nil
 }
@@ -5,6 +5,7 @@ package runes
 import (
 	"errors"
 	"io"
+	"math/rand"
 	"reflect"
 	"regexp"
 	"testing"
@@ -343,15 +344,15 @@ func TestBlockAlloc(t *testing.T) {
 	if err := b.Insert(rs, 0); err != nil {
 		t.Fatalf(`Initial insert(%v, 0)%v, wantnil`, rs, err)
 	}
-	if len(b.blocks) != 2 {
-		t.Fatalf("After initial insert: len(b.blocks)=%v, want 2", len(b.blocks))
+	if b.blocks.len() != 2 {
+		t.Fatalf("After initial insert: b.blocks.len()=%v, want 2", b.blocks.len())
 	}
 
 	if err := b.Delete(int64(l), 0); err != nil {
 		t.Fatalf(`Delete(%v, 0)=%v, want nil`, l, err)
 	}
-	if len(b.blocks) != 0 {
-		t.Fatalf("After delete: len(b.blocks)=%v, want 0", len(b.blocks))
+	if b.blocks.len() != 0 {
+		t.Fatalf("After delete: b.blocks.len()=%v, want 0", b.blocks.len())
 	}
 	if len(b.free) != 2 {
 		t.Fatalf("After delete: len(b.free)=%v, want 2", len(b.free))
@@ -363,8 +364,8 @@ func TestBlockAlloc(t *testing.T) {
 	if err := b.Insert(rs, 0); err != nil {
 		t.Fatalf(`Second insert(%v, 7)=%v, want nil`, rs, err)
 	}
-	if len(b.blocks) != 1 {
-		t.Fatalf("After second insert: len(b.blocks)=%d, want 1", len(b.blocks))
+	if b.blocks.len() != 1 {
+		t.Fatalf("After second insert: b.blocks.len()=%d, want 1", b.blocks.len())
 	}
 	if len(b.free) != 1 {
 		t.Fatalf("After second insert: len(b.free)=%d, want 1", len(b.free))
@@ -400,6 +401,108 @@ func TestInsertDeleteAndRead(t *testing.T) {
 	}
 }
 
+func TestNewlines(t *testing.T) {
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	rand.Seed(0) // For reproducibility.
+	var want []rune
+	for i := 0; i < 200; i++ {
+		if len(want) > 0 && rand.Intn(3) == 0 {
+			at := rand.Int63n(int64(len(want)))
+			n := rand.Int63n(int64(len(want))-at) + 1
+			if err := b.Delete(n, at); err != nil {
+				t.Fatalf("b.Delete(%d, %d)=%v, want nil", n, at, err)
+			}
+			want = append(want[:at], want[at+n:]...)
+		} else {
+			at := rand.Int63n(int64(len(want)) + 1)
+			rs := []rune(randLines(rand.Intn(2 * testBlockSize)))
+			if err := b.Insert(rs, at); err != nil {
+				t.Fatalf("b.Insert(%q, %d)=%v, want nil", string(rs), at, err)
+			}
+			want = append(want[:at], append(rs, want[at:]...)...)
+		}
+		checkNewlines(t, b, want)
+	}
+}
+
+func checkNewlines(t *testing.T, b *Buffer, rs []rune) {
+	var nl int64
+	for i := 0; i <= len(rs); i++ {
+		if got, err := b.Newlines(int64(i)); err != nil || got != nl {
+			t.Fatalf("%q: b.Newlines(%d)=%d,%v, want %d,nil", string(rs), i, got, err, nl)
+		}
+		if i == len(rs) || rs[i] != '\n' {
+			continue
+		}
+		if got, err := b.Newline(nl); err != nil || got != int64(i) {
+			t.Fatalf("%q: b.Newline(%d)=%d,%v, want %d,nil", string(rs), nl, got, err, i)
+		}
+		nl++
+	}
+}
+
+func TestBytes(t *testing.T) {
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	rand.Seed(0) // For reproducibility.
+	widths := []rune{'a', 'α', '世', '😀', 0xD800, -1}
+	var want []rune
+	for i := 0; i < 200; i++ {
+		if len(want) > 0 && rand.Intn(3) == 0 {
+			at := rand.Int63n(int64(len(want)))
+			n := rand.Int63n(int64(len(want))-at) + 1
+			if err := b.Delete(n, at); err != nil {
+				t.Fatalf("b.Delete(%d, %d)=%v, want nil", n, at, err)
+			}
+			want = append(want[:at], want[at+n:]...)
+		} else {
+			at := rand.Int63n(int64(len(want)) + 1)
+			rs := make([]rune, rand.Intn(2*testBlockSize))
+			for j := range rs {
+				rs[j] = widths[rand.Intn(len(widths))]
+			}
+			if err := b.Insert(rs, at); err != nil {
+				t.Fatalf("b.Insert(%q, %d)=%v, want nil", string(rs), at, err)
+			}
+			want = append(want[:at], append(rs, want[at:]...)...)
+		}
+		checkBytes(t, b, want)
+	}
+}
+
+func checkBytes(t *testing.T, b *Buffer, rs []rune) {
+	var n int64
+	for i := 0; i <= len(rs); i++ {
+		if got, err := b.Bytes(int64(i)); err != nil || got != n {
+			t.Fatalf("%q: b.Bytes(%d)=%d,%v, want %d,nil", string(rs), i, got, err, n)
+		}
+		if i == len(rs) {
+			break
+		}
+		w := int64(len(string(rs[i])))
+		for j := n; j < n+w; j++ {
+			if got, err := b.Byte(j); err != nil || got != int64(i) {
+				t.Fatalf("%q: b.Byte(%d)=%d,%v, want %d,nil", string(rs), j, got, err, i)
+			}
+		}
+		n += w
+	}
+}
+
+// RandLines returns a string of n runes, about half of which are newlines.
+func randLines(n int) string {
+	rs := make([]rune, n)
+	for i := range rs {
+		if rand.Intn(2) == 0 {
+			rs[i] = '\n'
+		} else {
+			rs[i] = 'a' + rune(rand.Intn(26))
+		}
+	}
+	return string(rs)
+}
+
 func errMatch(re string, err error) bool {
 	if err == nil {
 		return re == ""
@@ -431,10 +534,8 @@ func makeTestBytes(t *testing.T) *Buffer {
 		b.Close()
 		t.Fatalf(`insert("!@#", 12)=%v, want nil`, err)
 	}
-	ns := make([]int, len(b.blocks))
-	for i, blk := range b.blocks {
-		ns[i] = blk.n
-	}
+	var ns []int
+	b.blocks.each(func(blk block) { ns = append(ns, blk.n) })
 	if !reflect.DeepEqual(ns, []int{8, 4, 3, 4, 8}) {
 		b.Close()
 		t.Fatalf("blocks have sizes %v, want 8, 4, 3, 4, 8", ns)
@@ -0,0 +1,108 @@
+// Copyright © 2016, The T Authors.
+
+package runes
+
+import "sort"
+
+// MinCompactFree is the minimum number of free blocks
+// at which a Buffer is compacted automatically.
+const minCompactFree = 16
+
+// A truncater is a backing file that can be truncated, such as an *os.File.
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// Compact moves the blocks of the buffer
+// to the beginning of its backing file, without gaps,
+// and, if the file has a Truncate method, as does *os.File,
+// truncates the file to the end of the last block.
+//
+// Deleted blocks are reused by later inserts,
+// but the file does not otherwise shrink.
+// Delete and Reset compact the buffer automatically
+// when more than half of the blocks in the file are free.
+//
+// Compact does nothing while the buffer has open snapshots,
+// since they may be reading any block of the file.
+func (b *Buffer) Compact() error {
+	if b.snapshotted() {
+		return nil
+	}
+	if err := b.put(); err != nil {
+		return err
+	}
+	b.retired = nil
+	blks := make(blocksByStart, b.blocks.len())
+	for i := range blks {
+		blks[i], _ = b.blocks.mut(i)
+	}
+	sort.Sort(blks)
+
+	// Moving the blocks in order of their file offset
+	// only ever moves a block to a lower offset,
+	// to a free slot or one vacated by a block already moved.
+	slot := int64(b.blockSize * runeBytes)
+	var data []byte
+	for k, blk := range blks {
+		to := int64(k) * slot
+		if blk.start == to {
+			continue
+		}
+		if n := blk.n * runeBytes; n > 0 {
+			if cap(data) < n {
+				data = make([]byte, n)
+			}
+			data = data[:n]
+			if _, err := b.f.ReadAt(data, blk.start); err != nil {
+				b.freeUnused(blks)
+				return err
+			}
+			if _, err := b.f.WriteAt(data, to); err != nil {
+				b.freeUnused(blks)
+				return err
+			}
+		}
+		blk.start = to
+	}
+	b.free = nil
+	b.end = int64(len(blks)) * slot
+	if t, ok := b.f.(truncater); ok {
+		return t.Truncate(b.end)
+	}
+	return nil
+}
+
+// FreeUnused rebuilds the free list
+// from the slots of the file not used by the blocks.
+func (b *Buffer) freeUnused(blks blocksByStart) {
+	used := make(map[int64]bool, len(blks))
+	for _, blk := range blks {
+		used[blk.start] = true
+	}
+	b.free = b.free[:0]
+	slot := int64(b.blockSize * runeBytes)
+	for start := int64(0); start < b.end; start += slot {
+		if !used[start] {
+			b.free = append(b.free, block{start: start})
+		}
+	}
+}
+
+// AutoCompact compacts the buffer
+// if more than half of the blocks in the file are free.
+// A failed compaction leaves the runes of the buffer intact,
+// and the file merely larger than necessary,
+// so its error is ignored.
+func (b *Buffer) autoCompact() {
+	if len(b.free) < minCompactFree || len(b.free) <= b.blocks.len() {
+		return
+	}
+	b.Compact()
+}
+
+type blocksByStart []*block
+
+func (bs blocksByStart) Len() int           { return len(bs) }
+func (bs blocksByStart) Less(i, j int) bool { return bs[i].start < bs[j].start }
+func (bs blocksByStart) Swap(i, j int)      { bs[i], bs[j] = bs[j], bs[i] }
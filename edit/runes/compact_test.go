@@ -0,0 +1,221 @@
+// Copyright © 2016, The T Authors.
+
+package runes
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const testSlot = testBlockSize * runeBytes
+
+func fileSize(t *testing.T, b *Buffer) int64 {
+	switch f := b.f.(type) {
+	case nil:
+		return 0
+	case *os.File:
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Stat()=_,%v, want _,nil", err)
+		}
+		return fi.Size()
+	case *memFile:
+		return int64(len(f.data))
+	case *failFile:
+		return int64(len(f.data))
+	default:
+		t.Fatalf("unexpected file type %T", b.f)
+		return 0
+	}
+}
+
+// FragmentedBuffer returns a Buffer with the runes of rs
+// in blocks that are interleaved in the file with free blocks.
+func fragmentedBuffer(t *testing.T, rs []rune, f ReaderWriterAt) *Buffer {
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	for i := 0; i < len(rs); i += testBlockSize {
+		if err := b.Insert(rs[i:i+testBlockSize], b.Size()); err != nil {
+			t.Fatalf("Insert(…)=%v, want nil", err)
+		}
+		// A block that is later deleted.
+		if err := b.Insert(rs[:testBlockSize], b.Size()); err != nil {
+			t.Fatalf("Insert(…)=%v, want nil", err)
+		}
+	}
+	for i := int64(len(rs) / testBlockSize); i > 0; i-- {
+		if err := b.Delete(testBlockSize, (2*i-1)*testBlockSize); err != nil {
+			t.Fatalf("Delete(…)=%v, want nil", err)
+		}
+	}
+	if s := b.String(); s != string(rs) {
+		t.Fatalf("String()=%q, want %q", s, string(rs))
+	}
+	return b
+}
+
+func TestCompact(t *testing.T) {
+	rs := []rune(randLines(10 * testBlockSize))[:10*testBlockSize]
+	f, err := ioutil.TempFile("", "compact_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := fragmentedBuffer(t, rs, f)
+	defer b.Close()
+	if len(b.free) != 10 {
+		t.Fatalf("len(free)=%d, want 10", len(b.free))
+	}
+	// The last block may not be written yet.
+	if sz, want := fileSize(t, b), int64(19*testSlot); sz < want {
+		t.Errorf("before Compact, file size=%d, want ≥ %d", sz, want)
+	}
+
+	if err := b.Compact(); err != nil {
+		t.Fatalf("Compact()=%v, want nil", err)
+	}
+	if sz, want := fileSize(t, b), int64(10*testSlot); sz != want {
+		t.Errorf("after Compact, file size=%d, want %d", sz, want)
+	}
+	if len(b.free) != 0 {
+		t.Errorf("after Compact, len(free)=%d, want 0", len(b.free))
+	}
+	if s := b.String(); s != string(rs) {
+		t.Errorf("after Compact, String()=%q, want %q", s, string(rs))
+	}
+	checkNewlines(t, b, rs)
+
+	more := []rune("Hello, 世界")
+	if err := b.Insert(more, 5); err != nil {
+		t.Fatalf("Insert(%q, 5)=%v, want nil", string(more), err)
+	}
+	want := string(rs[:5]) + string(more) + string(rs[5:])
+	if s := b.String(); s != want {
+		t.Errorf("after Insert, String()=%q, want %q", s, want)
+	}
+}
+
+func TestAutoCompact(t *testing.T) {
+	const n = 4 * minCompactFree
+	rs := []rune(randLines(n * testBlockSize))[:n*testBlockSize]
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	if err := b.Insert(rs, 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	if sz, want := fileSize(t, b), int64(n*testSlot); sz < want-testSlot {
+		t.Errorf("before Delete, file size=%d, want ≥ %d", sz, want-testSlot)
+	}
+
+	// Fewer than half the blocks are free.
+	const del = n / 2 * testBlockSize
+	if err := b.Delete(del-testBlockSize, 0); err != nil {
+		t.Fatalf("Delete(…)=%v, want nil", err)
+	}
+	if sz := fileSize(t, b); sz < n*testSlot-testSlot {
+		t.Errorf("after Delete, file size=%d, want ≥ %d", sz, n*testSlot-testSlot)
+	}
+
+	// More than half the blocks are free.
+	if err := b.Delete(2*testBlockSize, 0); err != nil {
+		t.Fatalf("Delete(…)=%v, want nil", err)
+	}
+	if sz, want := fileSize(t, b), int64(b.blocks.len()*testSlot); sz != want {
+		t.Errorf("after Delete, file size=%d, want %d", sz, want)
+	}
+	if s, want := b.String(), string(rs[del+testBlockSize:]); s != want {
+		t.Errorf("after Delete, String()=%q, want %q", s, want)
+	}
+
+	if err := b.Insert(rs, 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	b.Reset()
+	if sz := fileSize(t, b); sz != 0 {
+		t.Errorf("after Reset, file size=%d, want 0", sz)
+	}
+	if err := b.Insert(rs, 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	if s := b.String(); s != string(rs) {
+		t.Errorf("after Reset and Insert, String()=%q, want %q", s, string(rs))
+	}
+}
+
+func TestAutoCompactError(t *testing.T) {
+	const n = 4 * minCompactFree
+	rs := []rune(randLines(n * testBlockSize))[:n*testBlockSize]
+	f := &failFile{}
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	defer b.Close()
+	if err := b.Insert(rs, 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	const del = n / 2 * testBlockSize
+	if err := b.Delete(del-testBlockSize, 0); err != nil {
+		t.Fatalf("Delete(…)=%v, want nil", err)
+	}
+
+	// The Delete succeeds even though its compaction fails.
+	f.failWrite = 1
+	if err := b.Delete(2*testBlockSize, 0); err != nil {
+		t.Fatalf("Delete(…)=%v, want nil", err)
+	}
+	if f.failWrite != 0 {
+		t.Fatalf("failWrite=%d, want 0", f.failWrite)
+	}
+	if s, want := b.String(), string(rs[del+testBlockSize:]); s != want {
+		t.Errorf("after Delete, String()=%q, want %q", s, want)
+	}
+}
+
+func TestCompactError(t *testing.T) {
+	rs := []rune(randLines(10 * testBlockSize))[:10*testBlockSize]
+	f := &failFile{}
+	b := fragmentedBuffer(t, rs, f)
+	defer b.Close()
+
+	f.failWrite = 3
+	if err := b.Compact(); err != errTestIO {
+		t.Fatalf("Compact()=%v, want %v", err, errTestIO)
+	}
+	f.failWrite = 0
+	if s := b.String(); s != string(rs) {
+		t.Errorf("after failed Compact, String()=%q, want %q", s, string(rs))
+	}
+
+	// Inserts must not overwrite the blocks that were moved.
+	if err := b.Insert(rs, b.Size()); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	if s, want := b.String(), string(rs)+string(rs); s != want {
+		t.Errorf("after Insert, String()=%q, want %q", s, want)
+	}
+	if err := b.Compact(); err != nil {
+		t.Fatalf("Compact()=%v, want nil", err)
+	}
+	if sz, want := fileSize(t, b), int64(b.blocks.len()*testSlot); sz != want {
+		t.Errorf("after Compact, file size=%d, want %d", sz, want)
+	}
+	if s, want := b.String(), string(rs)+string(rs); s != want {
+		t.Errorf("after Compact, String()=%q, want %q", s, want)
+	}
+}
+
+var errTestIO = errors.New("test IO error")
+
+// A failFile is a memFile with write errors.
+type failFile struct {
+	memFile
+	// If failWrite is positive, the failWrite-th WriteAt from now fails.
+	failWrite int
+}
+
+func (f *failFile) WriteAt(p []byte, offs int64) (int, error) {
+	if f.failWrite > 0 {
+		if f.failWrite--; f.failWrite == 0 {
+			return 0, errTestIO
+		}
+	}
+	return f.memFile.WriteAt(p, offs)
+}
@@ -0,0 +1,8 @@
+// Copyright © 2016, The T Authors.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package runes
+
+func newMmapFile() (ReaderWriterAt, error) { return FileStorage() }
@@ -0,0 +1,139 @@
+// Copyright © 2016, The T Authors.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package runes
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// MinMmap is the minimum size of a memory mapping.
+const minMmap = 1 << 20
+
+// An mmapFile is a backing store in a temporary file mapped into memory.
+// The file and its mapping grow by doubling,
+// so the file may be larger than the data written to it.
+type mmapFile struct {
+	// Mu protects the mapping from being remapped during a read.
+	mu sync.RWMutex
+	f  *os.File
+	// Data is the mapping of the file.
+	data []byte
+	// Size is the size of the data written to the file.
+	size int64
+}
+
+func newMmapFile() (ReaderWriterAt, error) {
+	f, err := ioutil.TempFile(os.TempDir(), "edit")
+	if err != nil {
+		return nil, err
+	}
+	return &mmapFile{f: f}, nil
+}
+
+func (f *mmapFile) ReadAt(p []byte, offs int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if offs >= f.size {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[offs:f.size])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *mmapFile) WriteAt(p []byte, offs int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := offs + int64(len(p))
+	if end > int64(len(f.data)) {
+		n := 2 * int64(len(f.data))
+		if n < minMmap {
+			n = minMmap
+		}
+		for n < end {
+			n *= 2
+		}
+		if err := f.remap(n); err != nil {
+			return 0, err
+		}
+	}
+	copy(f.data[offs:], p)
+	if end > f.size {
+		f.size = end
+	}
+	return len(p), nil
+}
+
+func (f *mmapFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size < f.size {
+		// Clear the truncated data,
+		// so that it reads as zeros if the file grows again.
+		b := f.data[size:f.size]
+		for i := range b {
+			b[i] = 0
+		}
+	}
+	if size > int64(len(f.data)) || size < int64(len(f.data))/4 {
+		n := size
+		if n < minMmap {
+			n = minMmap
+		}
+		if err := f.remap(n); err != nil {
+			return err
+		}
+	}
+	f.size = size
+	return nil
+}
+
+// Remap resizes the file and its mapping to n bytes.
+func (f *mmapFile) remap(n int64) error {
+	if err := f.unmap(); err != nil {
+		return err
+	}
+	if err := f.f.Truncate(n); err != nil {
+		return err
+	}
+	data, err := syscall.Mmap(int(f.f.Fd()), 0, int(n), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	f.data = data
+	return nil
+}
+
+func (f *mmapFile) unmap() error {
+	if f.data == nil {
+		return nil
+	}
+	if err := syscall.Munmap(f.data); err != nil {
+		return err
+	}
+	f.data = nil
+	return nil
+}
+
+func (f *mmapFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.unmap(); err != nil {
+		f.f.Close()
+		os.Remove(f.f.Name())
+		return err
+	}
+	return closeFile(f.f)
+}
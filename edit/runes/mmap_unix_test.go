@@ -0,0 +1,36 @@
+// Copyright © 2016, The T Authors.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package runes
+
+import "testing"
+
+func TestMmapStorageGrow(t *testing.T) {
+	const blockSize = 1 << 12
+	b := NewBufferStorage(blockSize, MmapStorage)
+	defer b.Close()
+	// Enough data to grow the mapping a few times.
+	rs := []rune(randLines(4 * minMmap / runeBytes))
+	if err := b.Insert(rs, 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	if s := b.String(); s != string(rs) {
+		t.Errorf("String() is wrong")
+	}
+	if err := b.Delete(int64(len(rs)-10), 10); err != nil {
+		t.Fatalf("Delete(…)=%v, want nil", err)
+	}
+	if s := b.String(); s != string(rs[:10]) {
+		t.Errorf("after Delete, String()=%q, want %q", s, string(rs[:10]))
+	}
+	f, ok := b.f.(*mmapFile)
+	if !ok {
+		t.Fatalf("backing store is %T, want *mmapFile", b.f)
+	}
+	if f.size > blockSize*runeBytes || len(f.data) > minMmap {
+		t.Errorf("after Delete, size=%d, mapped=%d, want ≤ %d, ≤ %d",
+			f.size, len(f.data), blockSize*runeBytes, minMmap)
+	}
+}
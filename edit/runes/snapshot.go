@@ -0,0 +1,173 @@
+// Copyright © 2016, The T Authors.
+
+package runes
+
+import "sort"
+
+// A Snapshot is a read-only view of the runes of a Buffer
+// at the time that the Snapshot was taken.
+//
+// A Snapshot may be read while its Buffer is changed,
+// including from a different goroutine than the one changing the Buffer.
+// However, like a Buffer, a Snapshot itself
+// is not safe for concurrent use by multiple goroutines.
+//
+// While a Buffer has open Snapshots,
+// the blocks of its file that are used by the Snapshots are not reused,
+// and the Buffer is not compacted.
+// Snapshots should be closed when they are no longer needed.
+type Snapshot struct {
+	buf  *Buffer
+	view *Buffer
+	gen  int
+}
+
+// A retiredBlock is a block of the file that is no longer used by the Buffer,
+// but may be used by the snapshots of generations [lo, hi).
+type retiredBlock struct {
+	start  int64
+	lo, hi int
+}
+
+// Snapshot returns a new Snapshot of the Buffer.
+//
+// Taking a snapshot writes any cached changes to the file,
+// but does not otherwise copy the runes of the Buffer.
+// Instead, the blocks of the Buffer are copied as they are next changed.
+func (b *Buffer) Snapshot() (*Snapshot, error) {
+	if err := b.put(); err != nil {
+		return nil, err
+	}
+	if b.blocks.len() > 0 {
+		if _, err := b.file(); err != nil {
+			return nil, err
+		}
+	}
+	// Blocks cached from here on must be owned by the new generation.
+	b.cached = nil
+	b.cached0 = -1
+
+	blocks := b.blocks.freeze()
+	b.mu.Lock()
+	b.snaps = append(b.snaps, blocks.gen)
+	b.mu.Unlock()
+	return &Snapshot{
+		buf: b,
+		view: &Buffer{
+			f:         b.f,
+			blockSize: b.blockSize,
+			blocks:    blocks,
+			cache:     make([]rune, b.blockSize),
+			size:      b.size,
+		},
+		gen: blocks.gen,
+	}, nil
+}
+
+// Close closes the Snapshot,
+// allowing the Buffer to reuse the blocks of its file
+// that were used only by the Snapshot.
+// If the Buffer is closed and this is its last open Snapshot,
+// the Buffer's file is closed.
+func (s *Snapshot) Close() error {
+	if s.view == nil {
+		return nil
+	}
+	s.view = nil
+	b := s.buf
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := sort.SearchInts(b.snaps, s.gen)
+	b.snaps = append(b.snaps[:i], b.snaps[i+1:]...)
+	b.released = true
+	if b.closed && len(b.snaps) == 0 {
+		return closeFile(b.f)
+	}
+	return nil
+}
+
+// Size returns the number of runes in the Snapshot.
+func (s *Snapshot) Size() int64 { return s.view.Size() }
+
+// Rune returns the rune at the given offset.
+// If the rune is out of range it panics.
+func (s *Snapshot) Rune(offs int64) (rune, error) { return s.view.Rune(offs) }
+
+// Read reads runes from the Snapshot beginning at a given offset.
+// It is an error to read out of the range of the Snapshot.
+func (s *Snapshot) Read(n int, offs int64) ([]rune, error) { return s.view.Read(n, offs) }
+
+// Reader returns a Reader that reads from the Snapshot
+// beginning at the given offset.
+// The returned Reader need not be closed,
+// but it must not be used after the Snapshot is closed.
+func (s *Snapshot) Reader(offs int64) Reader { return s.view.Reader(offs) }
+
+// Newlines returns the number of newlines before the given offset.
+// If the offset is out of range it panics.
+func (s *Snapshot) Newlines(offs int64) (int64, error) { return s.view.Newlines(offs) }
+
+// Newline returns the offset of the nth newline in the Snapshot,
+// where the first newline is n=0.
+// If there are n or fewer newlines in the Snapshot, Newline panics.
+func (s *Snapshot) Newline(n int64) (int64, error) { return s.view.Newline(n) }
+
+// Bytes returns the number of bytes in the UTF-8 encoding
+// of the runes before the given offset.
+// If the offset is out of range it panics.
+func (s *Snapshot) Bytes(offs int64) (int64, error) { return s.view.Bytes(offs) }
+
+// Byte returns the offset of the rune
+// whose UTF-8 encoding contains the nth byte of the Snapshot,
+// where the first byte is n=0.
+// If there are n or fewer bytes in the Snapshot, Byte panics.
+func (s *Snapshot) Byte(n int64) (int64, error) { return s.view.Byte(n) }
+
+// Snapshotted returns whether the buffer has open snapshots.
+func (b *Buffer) snapshotted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.snaps) > 0
+}
+
+// Shared returns whether the block's location in the file
+// may be used by an open snapshot:
+// whether a snapshot was taken since the location was allocated.
+func (b *Buffer) shared(blk block) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.snaps)
+	return n > 0 && b.snaps[n-1] >= blk.gen
+}
+
+// Retire records that the block's location in the file
+// is no longer used by the buffer.
+// It is reused once the snapshots that may use it are closed.
+func (b *Buffer) retire(blk block) {
+	b.retired = append(b.retired, retiredBlock{
+		start: blk.start,
+		lo:    blk.gen,
+		hi:    b.blocks.gen,
+	})
+}
+
+// Reclaim frees the retired blocks
+// that are no longer used by any open snapshot.
+func (b *Buffer) reclaim() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.released {
+		return
+	}
+	b.released = false
+	retired := b.retired[:0]
+	for _, r := range b.retired {
+		i := sort.SearchInts(b.snaps, r.lo)
+		if i < len(b.snaps) && b.snaps[i] < r.hi {
+			retired = append(retired, r)
+			continue
+		}
+		b.free = append(b.free, block{start: r.start})
+	}
+	b.retired = retired
+}
@@ -0,0 +1,175 @@
+// Copyright © 2016, The T Authors.
+
+package runes
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// RandEdit makes a random change to the buffer and to want,
+// returning the new want.
+func randEdit(t *testing.T, b *Buffer, want []rune) []rune {
+	if len(want) > 0 && rand.Intn(3) == 0 {
+		offs := rand.Intn(len(want))
+		n := rand.Intn(len(want)-offs) + 1
+		if err := b.Delete(int64(n), int64(offs)); err != nil {
+			t.Fatalf("Delete(%d, %d)=%v, want nil", n, offs, err)
+		}
+		return append(want[:offs:offs], want[offs+n:]...)
+	}
+	offs := rand.Intn(len(want) + 1)
+	rs := []rune(randLines(rand.Intn(4 * testBlockSize)))
+	if err := b.Insert(rs, int64(offs)); err != nil {
+		t.Fatalf("Insert(…, %d)=%v, want nil", offs, err)
+	}
+	return append(want[:offs:offs], append(rs, want[offs:]...)...)
+}
+
+func TestSnapshot(t *testing.T) {
+	rand.Seed(0)
+	type snap struct {
+		*Snapshot
+		want []rune
+	}
+	b := NewBufferStorage(testBlockSize, MemoryStorage)
+	defer b.Close()
+	var want []rune
+	var snaps []snap
+	for i := 0; i < 500; i++ {
+		want = randEdit(t, b, want)
+		if rand.Intn(10) == 0 {
+			s, err := b.Snapshot()
+			if err != nil {
+				t.Fatalf("Snapshot()=_,%v, want _,nil", err)
+			}
+			snaps = append(snaps, snap{s, want})
+		}
+		if len(snaps) > 0 && rand.Intn(20) == 0 {
+			j := rand.Intn(len(snaps))
+			if err := snaps[j].Close(); err != nil {
+				t.Fatalf("Close()=%v, want nil", err)
+			}
+			snaps = append(snaps[:j], snaps[j+1:]...)
+		}
+		for _, s := range snaps {
+			if sz := s.Size(); sz != int64(len(s.want)) {
+				t.Fatalf("snapshot Size()=%d, want %d", sz, len(s.want))
+			}
+			if str := s.view.String(); str != string(s.want) {
+				t.Fatalf("snapshot String()=%q, want %q", str, string(s.want))
+			}
+		}
+	}
+	if s := b.String(); s != string(want) {
+		t.Errorf("String()=%q, want %q", s, string(want))
+	}
+	for _, s := range snaps {
+		checkNewlines(t, s.view, s.want)
+		checkBytes(t, s.view, s.want)
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close()=%v, want nil", err)
+		}
+	}
+}
+
+func TestSnapshotReclaim(t *testing.T) {
+	rs := []rune(randLines(10 * testBlockSize))[:10*testBlockSize]
+	b := NewBufferStorage(testBlockSize, MemoryStorage)
+	defer b.Close()
+	if err := b.Insert(rs, 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	for i := 0; i < 100; i++ {
+		s, err := b.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot()=_,%v, want _,nil", err)
+		}
+		if err := b.Delete(int64(len(rs)), 0); err != nil {
+			t.Fatalf("Delete(…)=%v, want nil", err)
+		}
+		if err := b.Insert(rs, 0); err != nil {
+			t.Fatalf("Insert(…)=%v, want nil", err)
+		}
+		if str := s.view.String(); str != string(rs) {
+			t.Fatalf("snapshot String()=%q, want %q", str, string(rs))
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close()=%v, want nil", err)
+		}
+	}
+	// The blocks of closed snapshots are reused:
+	// the file has the buffer's blocks and those of at most one snapshot.
+	if sz, max := fileSize(t, b), int64(2*10*testSlot); sz > max {
+		t.Errorf("file size=%d, want ≤ %d", sz, max)
+	}
+
+	if err := b.Compact(); err != nil {
+		t.Fatalf("Compact()=%v, want nil", err)
+	}
+	if sz, want := fileSize(t, b), int64(b.blocks.len()*testSlot); sz != want {
+		t.Errorf("after Compact, file size=%d, want %d", sz, want)
+	}
+	if s := b.String(); s != string(rs) {
+		t.Errorf("after Compact, String()=%q, want %q", s, string(rs))
+	}
+}
+
+func TestSnapshotConcurrentRead(t *testing.T) {
+	rs := []rune(randLines(100 * testBlockSize))
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	if err := b.Insert(rs, 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	s, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot()=_,%v, want _,nil", err)
+	}
+	defer s.Close()
+
+	done := make(chan string)
+	go func() {
+		got, err := ReadAll(s.Reader(0))
+		if err != nil {
+			t.Errorf("ReadAll(snapshot)=_,%v, want _,nil", err)
+		}
+		done <- string(got)
+	}()
+	want := rs
+	for i := 0; i < 200; i++ {
+		want = randEdit(t, b, want)
+	}
+	if got := <-done; got != string(rs) {
+		t.Errorf("ReadAll(snapshot)=%q, want %q", got, string(rs))
+	}
+	if got := b.String(); got != string(want) {
+		t.Errorf("String()=%q, want %q", got, string(want))
+	}
+}
+
+func TestSnapshotBufferClosed(t *testing.T) {
+	rs := []rune(randLines(10 * testBlockSize))
+	b := NewBuffer(testBlockSize)
+	if err := b.Insert(rs, 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	s, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot()=_,%v, want _,nil", err)
+	}
+	path := b.f.(*os.File).Name()
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close()=%v, want nil", err)
+	}
+	if str := s.view.String(); str != string(rs) {
+		t.Errorf("after buffer Close, snapshot String()=%q, want %q", str, string(rs))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("snapshot Close()=%v, want nil", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("after snapshot Close, os.Stat(%q)=_,%v, want not exist", path, err)
+	}
+}
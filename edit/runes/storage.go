@@ -0,0 +1,160 @@
+// Copyright © 2016, The T Authors.
+
+package runes
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// A Storage creates the backing store of a Buffer.
+// The backing store is created lazily, when the Buffer is first written.
+// The snapshots of a Buffer read its backing store
+// concurrently with writes by the Buffer,
+// so ReadAt must be safe to call concurrently with WriteAt and Truncate.
+// If the backing store implements io.Closer,
+// it is closed when the Buffer is closed.
+// If it is an *os.File, the file is also removed.
+type Storage func() (ReaderWriterAt, error)
+
+// FileStorage stores a Buffer in a new temporary file.
+// It is the Storage of Buffers created with NewBuffer.
+func FileStorage() (ReaderWriterAt, error) {
+	return ioutil.TempFile(os.TempDir(), "edit")
+}
+
+// MemoryStorage stores a Buffer in memory.
+func MemoryStorage() (ReaderWriterAt, error) { return &memFile{}, nil }
+
+// MmapStorage stores a Buffer in a new temporary file
+// that is mapped into memory.
+// On systems that do not support mmap,
+// it is the same as FileStorage.
+func MmapStorage() (ReaderWriterAt, error) { return newMmapFile() }
+
+// AutoStorage returns a Storage that stores a Buffer in memory
+// until its backing store grows beyond threshold bytes,
+// and then moves it to a backing store created by the disk Storage.
+func AutoStorage(threshold int64, disk Storage) Storage {
+	return func() (ReaderWriterAt, error) {
+		return &autoFile{rw: &memFile{}, mem: true, threshold: threshold, disk: disk}, nil
+	}
+}
+
+// CloseFile closes a backing store.
+// If it is an *os.File, the file is also removed.
+func closeFile(f ReaderWriterAt) error {
+	switch f := f.(type) {
+	case *os.File:
+		path := f.Name()
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	case io.Closer:
+		return f.Close()
+	default:
+		return nil
+	}
+}
+
+// A memFile is an in-memory backing store.
+type memFile struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func (f *memFile) ReadAt(p []byte, offs int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if offs >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[offs:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, offs int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := offs + int64(len(p)); end > int64(len(f.data)) {
+		f.truncate(end)
+	}
+	return copy(f.data[offs:], p), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.truncate(size)
+	return nil
+}
+
+func (f *memFile) truncate(size int64) {
+	if size <= int64(len(f.data)) {
+		// Drop the reference to the truncated memory
+		// if a significant amount is freed.
+		if size < int64(cap(f.data)/2) {
+			f.data = append([]byte{}, f.data[:size]...)
+		} else {
+			f.data = f.data[:size]
+		}
+		return
+	}
+	f.data = append(f.data, make([]byte, size-int64(len(f.data)))...)
+}
+
+// An autoFile is a backing store that is in memory
+// until it grows beyond a threshold,
+// and then moves to a store created by a disk Storage.
+type autoFile struct {
+	// Mu protects the switch of rw from memory to disk.
+	mu        sync.RWMutex
+	rw        ReaderWriterAt
+	mem       bool
+	threshold int64
+	disk      Storage
+}
+
+func (f *autoFile) ReadAt(p []byte, offs int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rw.ReadAt(p, offs)
+}
+
+func (f *autoFile) WriteAt(p []byte, offs int64) (int, error) {
+	if f.mem && offs+int64(len(p)) > f.threshold {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	if f.mem && offs+int64(len(p)) > f.threshold {
+		disk, err := f.disk()
+		if err != nil {
+			return 0, err
+		}
+		if _, err := disk.WriteAt(f.rw.(*memFile).data, 0); err != nil {
+			closeFile(disk)
+			return 0, err
+		}
+		f.rw = disk
+		f.mem = false
+	}
+	return f.rw.WriteAt(p, offs)
+}
+
+func (f *autoFile) Truncate(size int64) error {
+	if t, ok := f.rw.(truncater); ok {
+		return t.Truncate(size)
+	}
+	return nil
+}
+
+func (f *autoFile) Close() error { return closeFile(f.rw) }
@@ -0,0 +1,96 @@
+// Copyright © 2016, The T Authors.
+
+package runes
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+)
+
+var storageTests = []struct {
+	name    string
+	storage Storage
+}{
+	{"file", FileStorage},
+	{"memory", MemoryStorage},
+	{"mmap", MmapStorage},
+	{"auto file", AutoStorage(4*testSlot, FileStorage)},
+	{"auto mmap", AutoStorage(4*testSlot, MmapStorage)},
+}
+
+func TestStorage(t *testing.T) {
+	for _, test := range storageTests {
+		rand.Seed(0)
+		b := NewBufferStorage(testBlockSize, test.storage)
+		var want []rune
+		for i := 0; i < 200; i++ {
+			if len(want) > 0 && rand.Intn(3) == 0 {
+				offs := rand.Intn(len(want))
+				n := rand.Intn(len(want)-offs) + 1
+				if err := b.Delete(int64(n), int64(offs)); err != nil {
+					t.Fatalf("%s: Delete(%d, %d)=%v, want nil", test.name, n, offs, err)
+				}
+				want = append(want[:offs], want[offs+n:]...)
+				continue
+			}
+			offs := rand.Intn(len(want) + 1)
+			rs := []rune(randLines(rand.Intn(4 * testBlockSize)))
+			if err := b.Insert(rs, int64(offs)); err != nil {
+				t.Fatalf("%s: Insert(…, %d)=%v, want nil", test.name, offs, err)
+			}
+			want = append(want[:offs], append(rs, want[offs:]...)...)
+		}
+		if s := b.String(); s != string(want) {
+			t.Errorf("%s: String()=%q, want %q", test.name, s, string(want))
+		}
+		checkNewlines(t, b, want)
+		if err := b.Compact(); err != nil {
+			t.Fatalf("%s: Compact()=%v, want nil", test.name, err)
+		}
+		if s := b.String(); s != string(want) {
+			t.Errorf("%s: after Compact, String()=%q, want %q", test.name, s, string(want))
+		}
+		if err := b.Close(); err != nil {
+			t.Errorf("%s: Close()=%v, want nil", test.name, err)
+		}
+	}
+}
+
+func TestAutoStorage(t *testing.T) {
+	b := NewBufferStorage(testBlockSize, AutoStorage(4*testSlot, FileStorage))
+	defer b.Close()
+	rs := []rune(randLines(10 * testBlockSize))
+	if err := b.Insert(rs[:2*testBlockSize], 0); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	if _, err := b.Rune(0); err != nil {
+		t.Fatalf("Rune(0)=_,%v, want _,nil", err)
+	}
+	f, ok := b.f.(*autoFile)
+	if !ok {
+		t.Fatalf("backing store is %T, want *autoFile", b.f)
+	}
+	if _, ok := f.rw.(*memFile); !ok || !f.mem {
+		t.Errorf("below the threshold, backing store is %T, want *memFile", f.rw)
+	}
+
+	if err := b.Insert(rs, b.Size()); err != nil {
+		t.Fatalf("Insert(…)=%v, want nil", err)
+	}
+	osFile, ok := f.rw.(*os.File)
+	if !ok || f.mem {
+		t.Fatalf("above the threshold, backing store is %T, want *os.File", f.rw)
+	}
+	if s, want := b.String(), string(rs[:2*testBlockSize])+string(rs); s != want {
+		t.Errorf("String()=%q, want %q", s, want)
+	}
+
+	path := osFile.Name()
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close()=%v, want nil", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("after Close, os.Stat(%q)=_,%v, want not exist", path, err)
+	}
+}
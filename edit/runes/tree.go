@@ -0,0 +1,252 @@
+// Copyright © 2016, The T Authors.
+
+package runes
+
+import "math/rand"
+
+// A blockTree is a sequence of blocks.
+// It is a treap: a binary tree ordered by block index
+// and heap-ordered by random node priorities,
+// which keeps the tree balanced with high probability.
+// Each node records the cumulative sum of its subtree,
+// so finding a block by index, rune offset, byte offset, or newline number,
+// inserting a block, and removing a block are O(log n).
+//
+// The tree is persistent: a frozen copy shares the nodes of the tree,
+// and the tree copies a shared node before modifying it.
+type blockTree struct {
+	root *node
+	// Gen is the generation of the tree.
+	// Nodes of earlier generations may be shared with frozen trees.
+	gen int
+	// Frozen is whether the tree is a read-only copy.
+	frozen bool
+}
+
+type node struct {
+	blk         block
+	pri         int64
+	gen         int
+	left, right *node
+	// Sum is the sum of the blocks in the subtree rooted at the node.
+	sum blockSum
+}
+
+// A blockSum is the sum of a sequence of blocks.
+type blockSum struct {
+	// Blocks is the number of blocks.
+	blocks int
+	// Runes is the number of runes in the blocks.
+	runes int64
+	// Bytes is the number of bytes in the UTF-8 encoding of the blocks.
+	bytes int64
+	// Newlines is the number of newlines in the blocks.
+	newlines int64
+}
+
+func (s blockSum) plus(t blockSum) blockSum {
+	return blockSum{
+		blocks:   s.blocks + t.blocks,
+		runes:    s.runes + t.runes,
+		bytes:    s.bytes + t.bytes,
+		newlines: s.newlines + t.newlines,
+	}
+}
+
+func (blk block) sum() blockSum {
+	return blockSum{blocks: 1, runes: int64(blk.n), bytes: int64(blk.nb), newlines: int64(blk.nl)}
+}
+
+// Total returns the sum of the subtree rooted at the node.
+// The sum of a nil node is zero.
+func (n *node) total() blockSum {
+	if n == nil {
+		return blockSum{}
+	}
+	return n.sum
+}
+
+// Update recomputes the sum of the node from its block and children.
+func (n *node) update() {
+	n.sum = n.left.total().plus(n.blk.sum()).plus(n.right.total())
+}
+
+// Len returns the number of blocks in the tree.
+func (t *blockTree) len() int { return t.root.total().blocks }
+
+// Total returns the sum of all blocks in the tree.
+func (t *blockTree) total() blockSum { return t.root.total() }
+
+// At returns the ith block and the sum of the blocks before it.
+// At panics if i is out of range.
+// The block must not be modified; see mut.
+func (t *blockTree) at(i int) (*block, blockSum) {
+	j, blk, before := t.search(func(s blockSum) bool { return s.blocks > i })
+	if j < 0 {
+		panic("block index out of bounds")
+	}
+	return blk, before
+}
+
+// Mut is like at, but the returned block may be modified,
+// after which fix must be called.
+// If the tree is frozen, mut is the same as at.
+func (t *blockTree) mut(i int) (*block, blockSum) {
+	if t.frozen {
+		return t.at(i)
+	}
+	if i < 0 || i >= t.len() {
+		panic("block index out of bounds")
+	}
+	var before blockSum
+	t.root = t.own(t.root)
+	n := t.root
+	for {
+		switch l := n.left.total().blocks; {
+		case i < l:
+			n.left = t.own(n.left)
+			n = n.left
+		case i > l:
+			before = before.plus(n.left.total()).plus(n.blk.sum())
+			n.right = t.own(n.right)
+			n = n.right
+			i -= l + 1
+		default:
+			return &n.blk, before.plus(n.left.total())
+		}
+	}
+}
+
+// Last returns the index of the last block,
+// the block, and the sum of the blocks before it.
+// Last panics if the tree is empty.
+func (t *blockTree) last() (int, *block, blockSum) {
+	i := t.len() - 1
+	blk, before := t.at(i)
+	return i, blk, before
+}
+
+// Search returns the index of the first block for which f returns true,
+// the block, and the sum of the blocks before it.
+// The argument of f is the sum of the blocks up to and including the block,
+// and f must be monotonic: false and then true.
+// If f is false for all blocks, search returns -1.
+func (t *blockTree) search(f func(blockSum) bool) (int, *block, blockSum) {
+	var before blockSum
+	n := t.root
+	for n != nil {
+		left := before.plus(n.left.total())
+		switch through := left.plus(n.blk.sum()); {
+		case n.left != nil && f(left):
+			n = n.left
+		case f(through):
+			return left.blocks, &n.blk, left
+		default:
+			before = through
+			n = n.right
+		}
+	}
+	return -1, nil, before
+}
+
+// Insert inserts a block at index i.
+func (t *blockTree) insert(i int, blk block) {
+	n := &node{blk: blk, pri: rand.Int63(), gen: t.gen}
+	n.update()
+	l, r := t.split(t.root, i)
+	t.root = t.merge(t.merge(l, n), r)
+}
+
+// Remove removes the block at index i.
+func (t *blockTree) remove(i int) {
+	l, r := t.split(t.root, i)
+	_, r = t.split(r, 1)
+	t.root = t.merge(l, r)
+}
+
+// Fix updates the sums of the tree
+// after the size or newline count of the ith block changed.
+func (t *blockTree) fix(i int) { t.root = t.fixNode(t.root, i) }
+
+func (t *blockTree) fixNode(n *node, i int) *node {
+	n = t.own(n)
+	switch l := n.left.total().blocks; {
+	case i < l:
+		n.left = t.fixNode(n.left, i)
+	case i > l:
+		n.right = t.fixNode(n.right, i-l-1)
+	}
+	n.update()
+	return n
+}
+
+// Freeze returns a frozen copy of the tree.
+// The copy shares the nodes of the tree,
+// which are copied before they are next modified by the tree.
+func (t *blockTree) freeze() blockTree {
+	f := blockTree{root: t.root, gen: t.gen, frozen: true}
+	t.gen++
+	return f
+}
+
+// Own returns the node if it is of the tree's generation,
+// and otherwise a copy of the node of the tree's generation.
+func (t *blockTree) own(n *node) *node {
+	if n == nil || n.gen == t.gen {
+		return n
+	}
+	c := *n
+	c.gen = t.gen
+	return &c
+}
+
+// Each calls f for each block in order.
+func (t *blockTree) each(f func(block)) { each(t.root, f) }
+
+func each(n *node, f func(block)) {
+	if n == nil {
+		return
+	}
+	each(n.left, f)
+	f(n.blk)
+	each(n.right, f)
+}
+
+// Split splits the tree rooted at n into
+// a tree of its first i blocks and a tree of the rest.
+func (t *blockTree) split(n *node, i int) (*node, *node) {
+	if n == nil {
+		return nil, nil
+	}
+	n = t.own(n)
+	if l := n.left.total().blocks; i <= l {
+		a, b := t.split(n.left, i)
+		n.left = b
+		n.update()
+		return a, n
+	}
+	a, b := t.split(n.right, i-n.left.total().blocks-1)
+	n.right = a
+	n.update()
+	return n, b
+}
+
+// Merge returns the tree of the blocks of a followed by the blocks of b.
+func (t *blockTree) merge(a, b *node) *node {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.pri > b.pri:
+		a = t.own(a)
+		a.right = t.merge(a.right, b)
+		a.update()
+		return a
+	default:
+		b = t.own(b)
+		b.left = t.merge(a, b.left)
+		b.update()
+		return b
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2016, The T Authors.
+
+package runes
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBlockTree(t *testing.T) {
+	rand.Seed(0) // For reproducibility.
+	var tree blockTree
+	var want []block
+	for i := 0; i < 1000; i++ {
+		if len(want) > 0 && rand.Intn(3) == 0 {
+			j := rand.Intn(len(want))
+			tree.remove(j)
+			want = append(want[:j], want[j+1:]...)
+		} else {
+			j := rand.Intn(len(want) + 1)
+			blk := block{start: int64(i), n: rand.Intn(10), nl: rand.Intn(3)}
+			tree.insert(j, blk)
+			want = append(want[:j], append([]block{blk}, want[j:]...)...)
+		}
+		if len(want) > 0 && rand.Intn(2) == 0 {
+			j := rand.Intn(len(want))
+			blk, _ := tree.at(j)
+			blk.n++
+			tree.fix(j)
+			want[j].n++
+		}
+		checkBlockTree(t, &tree, want)
+	}
+}
+
+func TestBlockTreeFreeze(t *testing.T) {
+	rand.Seed(0) // For reproducibility.
+	type frozen struct {
+		tree blockTree
+		want []block
+	}
+	var tree blockTree
+	var want []block
+	var frozens []frozen
+	for i := 0; i < 1000; i++ {
+		switch j := rand.Intn(len(want) + 1); {
+		case len(want) > 0 && rand.Intn(3) == 0:
+			j = rand.Intn(len(want))
+			tree.remove(j)
+			want = append(want[:j:j], want[j+1:]...)
+		case len(want) > 0 && rand.Intn(2) == 0:
+			j = rand.Intn(len(want))
+			blk, _ := tree.mut(j)
+			blk.n++
+			tree.fix(j)
+			want = append([]block{}, want...)
+			want[j].n++
+		default:
+			blk := block{start: int64(i), n: rand.Intn(10), nl: rand.Intn(3)}
+			tree.insert(j, blk)
+			want = append(want[:j:j], append([]block{blk}, want[j:]...)...)
+		}
+		if rand.Intn(10) == 0 {
+			frozens = append(frozens, frozen{tree.freeze(), want})
+		}
+		for k := range frozens {
+			f := &frozens[k]
+			checkBlockTree(t, &f.tree, f.want)
+		}
+		checkBlockTree(t, &tree, want)
+	}
+}
+
+func checkBlockTree(t *testing.T, tree *blockTree, want []block) {
+	if n := tree.len(); n != len(want) {
+		t.Fatalf("tree.len()=%d, want %d", n, len(want))
+	}
+	var got []block
+	tree.each(func(blk block) { got = append(got, blk) })
+	var sum blockSum
+	for i, blk := range want {
+		if got[i] != blk {
+			t.Fatalf("block %d=%v, want %v", i, got[i], blk)
+		}
+		if b, before := tree.at(i); *b != blk || before != sum {
+			t.Fatalf("tree.at(%d)=%v,%v, want %v,%v", i, *b, before, blk, sum)
+		}
+		if blk.n > 0 {
+			j, _, before := tree.search(func(s blockSum) bool { return s.runes > sum.runes })
+			if j != i || before != sum {
+				t.Fatalf("tree.search(runes > %d)=%d,%v, want %d,%v", sum.runes, j, before, i, sum)
+			}
+		}
+		sum = sum.plus(blk.sum())
+	}
+	if total := tree.total(); total != sum {
+		t.Fatalf("tree.total()=%v, want %v", total, sum)
+	}
+	if j, _, _ := tree.search(func(s blockSum) bool { return s.blocks > len(want) }); j != -1 {
+		t.Fatalf("tree.search(blocks > %d)=%d, want -1", len(want), j)
+	}
+}
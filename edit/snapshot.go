@@ -0,0 +1,81 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"io"
+
+	"github.com/eaburns/T/edit/runes"
+)
+
+// A Snapshot is a read-only Text
+// with the contents and marks of a Buffer
+// at the time that the Snapshot was taken.
+//
+// Taking a Snapshot is cheap: it does not copy the text of the Buffer.
+// A Snapshot may be read, and addresses evaluated on it,
+// while the Buffer is changed,
+// including from a different goroutine than the one changing the Buffer.
+// However, a Snapshot itself
+// is not safe for concurrent use by multiple goroutines.
+//
+// A Snapshot must be closed when it is no longer needed.
+type Snapshot struct {
+	runes *runes.Snapshot
+	marks map[rune]Span
+}
+
+// Snapshot returns a new Snapshot of the Buffer.
+func (buf *Buffer) Snapshot() (*Snapshot, error) {
+	rs, err := buf.runes.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	marks := make(map[rune]Span, len(buf.marks))
+	for m, s := range buf.marks {
+		marks[m] = s
+	}
+	return &Snapshot{runes: rs, marks: marks}, nil
+}
+
+// Close closes the Snapshot and releases its resources.
+func (snap *Snapshot) Close() error { return snap.runes.Close() }
+
+// Size implements the Size method of the Text interface.
+//
+// It returns the number of Runes in the Snapshot.
+func (snap *Snapshot) Size() int64 { return snap.runes.Size() }
+
+// Newlines returns the number of newlines before an offset.
+func (snap *Snapshot) newlines(at int64) (int64, error) { return snap.runes.Newlines(at) }
+
+// Newline returns the offset of the nth newline.
+func (snap *Snapshot) newline(n int64) (int64, error) { return snap.runes.Newline(n) }
+
+// Utf8Bytes returns the number of UTF-8 bytes before an offset.
+func (snap *Snapshot) utf8Bytes(at int64) (int64, error) { return snap.runes.Bytes(at) }
+
+// Utf8Byte returns the offset of the rune containing the nth UTF-8 byte.
+func (snap *Snapshot) utf8Byte(n int64) (int64, error) { return snap.runes.Byte(n) }
+
+// Mark implements the Mark method of the Text interface.
+func (snap *Snapshot) Mark(m rune) Span { return snap.marks[m] }
+
+// RuneReader implements the Runes method of the Text interface.
+//
+// Each non-error ReadRune operation returns a width of 1.
+func (snap *Snapshot) RuneReader(s Span) io.RuneReader {
+	if size := snap.Size(); s[0] < 0 || s[1] < 0 || s[0] > size || s[1] > size {
+		return badRange{}
+	}
+	return &runeReader{span: s, runes: snap.runes}
+}
+
+// Reader implements the Reader method of the Text interface.
+func (snap *Snapshot) Reader(s Span) io.Reader {
+	if size := snap.Size(); s[0] < 0 || s[1] < 0 || s[0] > size || s[1] > size {
+		return badRange{}
+	}
+	rr := runes.LimitReader(snap.runes.Reader(s[0]), s.Size())
+	return runes.UTF8Reader(rr)
+}
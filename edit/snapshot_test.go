@@ -0,0 +1,68 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	doEdits(t, buf,
+		Append(All, "Hello\nWorld\n"),
+		Set(Line(2), 'm'),
+	)
+	snap, err := buf.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot()=_,%v, want _,nil", err)
+	}
+	defer snap.Close()
+
+	doEdits(t, buf,
+		Insert(Rune(0), "Oh, "),
+		Change(Line(2), "世界\n"),
+		Delete(Line(1)),
+	)
+	if s, want := buf.String(), "世界\n"; s != want {
+		t.Errorf("buf.String()=%q, want %q", s, want)
+	}
+
+	const want = "Hello\nWorld\n"
+	if sz := snap.Size(); sz != int64(len(want)) {
+		t.Errorf("snap.Size()=%d, want %d", sz, len(want))
+	}
+	if s, want := snap.Mark('m'), (Span{6, 12}); s != want {
+		t.Errorf("snap.Mark('m')=%v, want %v", s, want)
+	}
+	data, err := ioutil.ReadAll(snap.Reader(Span{0, snap.Size()}))
+	if err != nil || string(data) != want {
+		t.Errorf("ReadAll(snap.Reader(…))=%q,%v, want %q,nil", data, err, want)
+	}
+	r, _, err := snap.RuneReader(Span{6, 0}).ReadRune()
+	if err != nil || r != '\n' {
+		t.Errorf("snap.RuneReader({6, 0}).ReadRune()=%q,_,%v, want '\\n',_,nil", r, err)
+	}
+	if _, err := ioutil.ReadAll(snap.Reader(Span{0, 100})); err != ErrInvalidArgument {
+		t.Errorf("ReadAll(snap.Reader({0, 100}))=_,%v, want _,%v", err, ErrInvalidArgument)
+	}
+	if _, _, err := snap.RuneReader(Span{-1, 0}).ReadRune(); err != ErrInvalidArgument {
+		t.Errorf("snap.RuneReader({-1, 0}).ReadRune()=_,_,%v, want _,_,%v", err, ErrInvalidArgument)
+	}
+
+	for _, test := range []struct {
+		addr Address
+		want Span
+	}{
+		{addr: Line(2), want: Span{6, 12}},
+		{addr: Line(1).Plus(Line(1)), want: Span{6, 12}},
+		{addr: Mark('m').Minus(Line(1)), want: Span{0, 6}},
+		{addr: Regexp("World"), want: Span{6, 11}},
+	} {
+		s, err := test.addr.Where(snap)
+		if err != nil || s != test.want {
+			t.Errorf("%q.Where(snap)=%v,%v, want %v,nil", test.addr, s, err, test.want)
+		}
+	}
+}
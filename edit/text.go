@@ -5,15 +5,31 @@ package edit
 import (
 	"errors"
 	"io"
+	"os"
 )
 
 var (
 	// ErrInvalidArgument indicates an invalid, out-of-range Span.
 	ErrInvalidArgument = errors.New("invalid argument")
 
+	// ErrSplitRune indicates a byte offset
+	// within the UTF-8 encoding of a rune.
+	ErrSplitRune = errors.New("byte offset splits a rune")
+
 	// ErrOutOfSequence indicates that a change modifies text
 	// overlapping or preceeding the previous, staged change.
 	ErrOutOfSequence = errors.New("out of sequence")
+
+	// ErrNoUndoState indicates an undo state ID
+	// that is not in the undo tree.
+	ErrNoUndoState = errors.New("no such undo state")
+
+	// ErrNoCheckpoint indicates an unknown checkpoint name.
+	ErrNoCheckpoint = errors.New("no such checkpoint")
+
+	// ErrUndoConflict indicates that changes cannot be selectively undone,
+	// because later changes modified the same text.
+	ErrUndoConflict = errors.New("undo conflicts with a later change")
 )
 
 // A Text provides a read-only view of a sequence of text.
@@ -60,10 +76,14 @@ type Text interface {
 // The Apply method applies the changes to the Text
 // in the order that they were added to the staging log.
 //
-// An Editor also has an Undo stack and a Redo stack.
-// The stacks hold batches of changes,
-// providing support for unlimited undoing and redoing
-// of changes made by calls to Apply, Undo, and Redo.
+// An Editor also has an undo tree.
+// The nodes of the tree are states of the text,
+// and the edges are batches of changes made by calls to Apply,
+// providing support for unlimited undoing and redoing of changes.
+// Undoing changes and then applying new changes
+// starts a new branch of the tree;
+// the undone changes can still be redone with UndoTo.
+// See UndoState.
 type Editor interface {
 	Text
 
@@ -90,19 +110,135 @@ type Editor interface {
 
 	// Apply applies all changes since the previous call to Apply,
 	// updates all marks to reflect the changes,
-	// logs the applied changes to the Undo stack,
-	// and clears the Redo stack.
+	// and adds a new state to the undo tree,
+	// a child of the current state, which becomes the current state.
 	Apply() error
 
-	// Undo undoes the changes at the top of the Undo stack.
-	// It updates all marks to reflect the changes,
-	// and logs the undone changes to the Redo stack.
+	// Undo undoes the changes that created the current state,
+	// moving to its parent state.
+	// It updates all marks to reflect the changes.
+	// If the current state is the root, Undo does nothing.
 	Undo() error
 
-	// Redo redoes the changes at the top of the Redo stack.
-	// It updates all marks to reflect the changes,
-	// and logs the redone changes to the Undo stack.
+	// Redo redoes the changes that created the child
+	// of the current state that was most recently created or visited,
+	// moving to the child.
+	// It updates all marks to reflect the changes.
+	// If the current state has no children, Redo does nothing.
 	Redo() error
+
+	// SelectiveUndo reverts the most recent changes
+	// made by calls to Apply of this Editor
+	// that are not already undone or reverted,
+	// keeping intact any changes made since,
+	// for example, by other Editors of the same text.
+	// Unlike Undo, the revert is a new change,
+	// which creates a new state of the undo tree.
+	// It updates all marks to reflect the changes.
+	//
+	// ErrUndoConflict is returned if a later change
+	// modified the text changed by the reverted changes.
+	// In that case, the text is unchanged,
+	// and subsequent calls skip the conflicting changes.
+	SelectiveUndo() error
+
+	// SetCheckpoint names the current state of the undo tree.
+	// If the name already names a state,
+	// it is moved to the current state.
+	SetCheckpoint(name string)
+
+	// UndoToSequence moves to the state of the undo tree
+	// that was current after the edit with the given sequence number,
+	// as if by UndoTo.
+	// Sequence numbers are assigned by the Editor;
+	// see, for example, Buffer.Sequence.
+	//
+	// ErrNoUndoState is returned if the sequence number is
+	// negative or greater than the current sequence number.
+	UndoToSequence(seq int) error
+
+	// UndoStates returns the states of the undo tree, ordered by ID.
+	UndoStates() []UndoState
+
+	// UndoTo moves to the state of the undo tree with the given ID,
+	// undoing changes up to the common ancestor of the current state
+	// and the given state, and then redoing changes down to the given state.
+	// It updates all marks to reflect the changes.
+	//
+	// ErrNoUndoState is returned if there is no state with the ID.
+	UndoTo(id int) error
+
+	// FileSystem returns the FileSystem
+	// used to read and write files.
+	FileSystem() FileSystem
+
+	// FileName returns the name of the file
+	// associated with the text.
+	// If there is no associated file, FileName returns "".
+	FileName() string
+
+	// SetFileName sets the name of the file
+	// associated with the text.
+	SetFileName(string)
+
+	// Encoding returns the Encoding
+	// of the file associated with the text.
+	Encoding() Encoding
+
+	// SetEncoding sets the Encoding
+	// of the file associated with the text.
+	SetEncoding(Encoding)
+
+	// LineEnding returns the LineEnding
+	// of the file associated with the text.
+	LineEnding() LineEnding
+
+	// SetLineEnding sets the LineEnding
+	// of the file associated with the text.
+	SetLineEnding(LineEnding)
+}
+
+// A FileSystem provides access to named files.
+type FileSystem interface {
+	// Open opens the named file for reading.
+	Open(string) (io.ReadCloser, error)
+
+	// Create creates or truncates the named file for writing.
+	Create(string) (io.WriteCloser, error)
+}
+
+// A RenameFileSystem is a FileSystem that can also rename and remove files.
+// It can write a file atomically,
+// by creating a temporary file and renaming it to the file.
+type RenameFileSystem interface {
+	FileSystem
+
+	// Rename renames a file from the first name to the second,
+	// replacing the file with the second name, if any.
+	Rename(string, string) error
+
+	// Remove removes the named file.
+	Remove(string) error
+}
+
+// OSFileSystem is a RenameFileSystem that accesses the files
+// of the host operating system, using the os package.
+// A file replaced by Rename keeps its permissions.
+var OSFileSystem FileSystem = osFileSystem{}
+
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (io.ReadCloser, error)    { return os.Open(name) }
+func (osFileSystem) Create(name string) (io.WriteCloser, error) { return os.Create(name) }
+func (osFileSystem) Remove(name string) error                   { return os.Remove(name) }
+
+func (osFileSystem) Rename(from, to string) error {
+	if fi, err := os.Stat(to); err == nil {
+		if err := os.Chmod(from, fi.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(from, to)
 }
 
 // A Span identifies a string within a Text.
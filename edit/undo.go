@@ -0,0 +1,457 @@
+// Copyright © 2016, The T Authors.
+
+package edit
+
+import (
+	"sort"
+	"time"
+
+	"github.com/eaburns/T/edit/runes"
+)
+
+// An UndoState describes a state of the text in the undo tree of an Editor.
+//
+// The initial, unchanged text is the root of the tree.
+// Each call to Apply creates a new state,
+// a child of the state in which the changes were applied.
+// Undo moves to the parent of the current state,
+// and Redo moves to the child of the current state
+// that was most recently created or visited.
+// Changes applied after an Undo begin a new branch,
+// but the undone states remain in the tree,
+// and can be reached with UndoTo.
+type UndoState struct {
+	// ID is the identifier of the state.
+	// The initial root of the tree has ID 0,
+	// and each new state has the next greater ID.
+	// IDs are not reused, even after their states are pruned.
+	ID int
+
+	// Parent is the ID of the parent state.
+	// The parent of the root is -1.
+	Parent int
+
+	// Changes is the number of changes
+	// made to the text of the parent to create the state.
+	Changes int
+
+	// Time is the time at which the state was created.
+	Time time.Time
+
+	// Current is whether the text is in this state.
+	Current bool
+
+	// Checkpoints are the names of the checkpoints
+	// set at this state, in sorted order.
+	Checkpoints []string
+}
+
+// A state is a node of a Buffer's undo tree.
+type state struct {
+	id     int
+	parent *state
+	// Next is the child to which Redo moves.
+	// It is the most recently created or visited child.
+	next *state
+	time time.Time
+	// First is the offset in the history log
+	// of the first entry of the changes that created the state,
+	// and last is the offset of the final entry.
+	// The entries alternate between undo and redo entries.
+	// Undo entries hold the text before each change,
+	// and redo entries hold the text after each change,
+	// both with Spans in the coordinates of the parent state.
+	first, last int64
+	// N is the number of changes, and
+	// the number of both undo and redo entries.
+	n int
+	// Size is the number of runes in the log
+	// used by the entries of the state.
+	size int64
+	// Depth is the number of ancestors of the state,
+	// including any pruned ancestors.
+	depth int
+	// Pruned is whether the state was pruned from the tree.
+	pruned bool
+	// Reverted is the state whose changes
+	// were reverted by Revert to create this state, if any.
+	// It is not saved with the history.
+	reverted *state
+}
+
+// newState adds a new state to the tree as a child of the current state,
+// and moves to it.
+func (buf *Buffer) newState(first, last int64, n int) {
+	s := &state{
+		id:     buf.nextID,
+		parent: buf.state,
+		time:   time.Now(),
+		first:  first,
+		last:   last,
+		n:      n,
+		size:   buf.history.buf.Size() - first,
+		depth:  buf.state.depth + 1,
+	}
+	buf.nextID++
+	buf.undoRunes += s.size
+	buf.state.next = s
+	buf.states = append(buf.states, s)
+	buf.state = s
+}
+
+// A seqState is the current state after a sequence number.
+type seqState struct {
+	seq   int32
+	state *state
+}
+
+// Sequence returns the sequence number of the Buffer's text.
+// It is 0 for a new Buffer,
+// and it is incremented by each call to Apply,
+// and by each move to a parent or child state of the undo tree
+// made by Undo, Redo, and UndoTo.
+func (buf *Buffer) Sequence() int { return int(buf.seq) }
+
+// SetCheckpoint implements the SetCheckpoint method of the Editor interface.
+func (buf *Buffer) SetCheckpoint(name string) { buf.checkpoints[name] = buf.state }
+
+// UndoToSequence implements the UndoToSequence method of the Editor interface.
+//
+// The sequence numbers are those returned by Sequence.
+func (buf *Buffer) UndoToSequence(seq int) error {
+	if seq < 0 || seq > buf.Sequence() {
+		return ErrNoUndoState
+	}
+	i := sort.Search(len(buf.seqs), func(i int) bool { return int(buf.seqs[i].seq) > seq })
+	if i == 0 || buf.seqs[i-1].state == nil {
+		// The state was pruned.
+		return ErrNoUndoState
+	}
+	return buf.UndoTo(buf.seqs[i-1].state.id)
+}
+
+// StateByID returns the state with the given ID,
+// or nil if there is no such state.
+func (buf *Buffer) stateByID(id int) *state {
+	i := sort.Search(len(buf.states), func(i int) bool { return buf.states[i].id >= id })
+	if i == len(buf.states) || buf.states[i].id != id {
+		return nil
+	}
+	return buf.states[i]
+}
+
+// UndoStates implements the UndoStates method of the Editor interface.
+func (buf *Buffer) UndoStates() []UndoState {
+	states := make([]UndoState, len(buf.states))
+	for i, s := range buf.states {
+		states[i] = UndoState{
+			ID:      s.id,
+			Parent:  -1,
+			Changes: s.n,
+			Time:    s.time,
+			Current: s == buf.state,
+		}
+		if s.parent != nil {
+			states[i].Parent = s.parent.id
+		}
+	}
+	for name, s := range buf.checkpoints {
+		i := sort.Search(len(states), func(i int) bool { return states[i].ID >= s.id })
+		states[i].Checkpoints = append(states[i].Checkpoints, name)
+	}
+	for _, s := range states {
+		sort.Strings(s.Checkpoints)
+	}
+	return states
+}
+
+// Undo implements the Undo method of the Editor interface.
+func (buf *Buffer) Undo() error {
+	if buf.state.parent == nil {
+		return nil
+	}
+	return buf.undoState()
+}
+
+// Redo implements the Redo method of the Editor interface.
+func (buf *Buffer) Redo() error {
+	if buf.state.next == nil {
+		return nil
+	}
+	return buf.redoState(buf.state.next)
+}
+
+// UndoTo implements the UndoTo method of the Editor interface.
+func (buf *Buffer) UndoTo(id int) error {
+	to := buf.stateByID(id)
+	if to == nil {
+		return ErrNoUndoState
+	}
+
+	// Undo to the common ancestor,
+	// then redo along the path down to the state.
+	var path []*state
+	a, b := buf.state, to
+	da, db := a.depth, b.depth
+	for ; da > db; da-- {
+		a = a.parent
+	}
+	for ; db > da; db-- {
+		path = append(path, b)
+		b = b.parent
+	}
+	for a != b {
+		a = a.parent
+		path = append(path, b)
+		b = b.parent
+	}
+	for buf.state != a {
+		if err := buf.undoState(); err != nil {
+			return err
+		}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		if err := buf.redoState(path[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UndoState changes the text from the current state to its parent,
+// and sets dot to the address covering the undone changes.
+func (buf *Buffer) undoState() error {
+	marks0 := make(map[rune]Span, len(buf.marks))
+	for r, s := range buf.marks {
+		marks0[r] = s
+	}
+	defer func() { buf.marks = marks0 }()
+
+	s := buf.state
+	all := Span{-1, 0}
+	e := logAt(buf.history, s.first)
+	for i := 0; i < s.n; i++ {
+		if e.err != nil {
+			return e.err
+		}
+		if all[0] < 0 {
+			all[0] = e.span[0]
+		}
+		all[1] = e.span[0] + e.size
+		if err := buf.change(e.span, e.data()); err != nil {
+			return err
+		}
+		// Skip the redo entry.
+		e = e.next().next()
+	}
+	buf.marks['.'] = all
+	marks0 = buf.marks
+	buf.seq++
+	// Redo returns to the undone branch.
+	s.parent.next = s
+	buf.state = s.parent
+	buf.seqs = append(buf.seqs, seqState{seq: buf.seq, state: buf.state})
+	return nil
+}
+
+// RedoState changes the text from the current state to its child, s,
+// and sets dot to the address covering the redone changes.
+func (buf *Buffer) redoState(s *state) error {
+	marks0 := make(map[rune]Span, len(buf.marks))
+	for r, s := range buf.marks {
+		marks0[r] = s
+	}
+	defer func() { buf.marks = marks0 }()
+
+	// The redo entries are applied in reverse,
+	// so that the Spans of the changes not yet applied
+	// are unaffected by the changes that are.
+	all := Span{0, -1}
+	e := logAt(buf.history, s.last)
+	for i := 0; i < s.n; i++ {
+		if e.err != nil {
+			return e.err
+		}
+		all[0] = e.span[0]
+		if all[1] < 0 {
+			all[1] = e.span[0] + e.size
+		} else {
+			all[1] += e.size - e.span.Size()
+		}
+		if err := buf.change(e.span, e.data()); err != nil {
+			return err
+		}
+		// Skip the undo entry.
+		e = e.prev().prev()
+	}
+	buf.marks['.'] = all
+	marks0 = buf.marks
+	buf.seq++
+	buf.state.next = s
+	buf.state = s
+	buf.seqs = append(buf.seqs, seqState{seq: buf.seq, state: buf.state})
+	return nil
+}
+
+// CurrentUndoState returns the ID of the current state of the undo tree.
+func (buf *Buffer) CurrentUndoState() int { return buf.state.id }
+
+// SelectiveUndo implements the SelectiveUndo method of the Editor interface.
+//
+// Every change applied to the Buffer with Apply is its own change.
+func (buf *Buffer) SelectiveUndo() error {
+	for len(buf.own) > 0 {
+		id := buf.own[len(buf.own)-1]
+		buf.own = buf.own[:len(buf.own)-1]
+		buf.reverting = true
+		err := buf.Revert(buf, id)
+		buf.reverting = false
+		if err == ErrUndoConflict {
+			buf.own = append(buf.own, id)
+		}
+		if err != ErrNoUndoState {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revert reverts the changes that created the undo state with the given ID,
+// keeping intact all changes made since the state.
+// The reverting changes are staged and applied
+// using the Change and Apply methods of an Editor,
+// which must edit the Buffer, and may be the Buffer itself.
+// So, like any other change, the revert creates a new undo state.
+// Dot of the Editor is set to the address covering the reverted changes.
+//
+// ErrNoUndoState is returned if the state is not
+// the current state or an ancestor of the current state,
+// since its changes are not in the text.
+// ErrUndoConflict is returned if a change made since the state
+// modified text that was changed to create the state.
+// In either case, the text is unchanged.
+func (buf *Buffer) Revert(ed Editor, id int) error {
+	s := buf.stateByID(id)
+	if s == nil || s.parent == nil {
+		return ErrNoUndoState
+	}
+	reverts, err := buf.reverts(s)
+	if err != nil {
+		return err
+	}
+	cur := buf.state
+	for _, r := range reverts {
+		e := logAt(buf.history, r.offs)
+		if e.err != nil {
+			return e.err
+		}
+		if _, err := ed.Change(r.span, runes.UTF8Reader(e.data())); err != nil {
+			return err
+		}
+	}
+	if err := ed.Apply(); err != nil {
+		return err
+	}
+	if buf.state != cur && buf.state.parent == cur {
+		buf.state.reverted = s
+	}
+	if len(reverts) == 0 {
+		return nil
+	}
+	var d int64
+	for _, r := range reverts[:len(reverts)-1] {
+		d += r.size - r.span.Size()
+	}
+	last := reverts[len(reverts)-1]
+	return ed.SetMark('.', Span{reverts[0].span[0], last.span[0] + d + last.size})
+}
+
+// A revert is a change that reverts a change of an undo state.
+type revert struct {
+	// Span is the Span of the change in the text of the current state.
+	span Span
+	// Size is the size of the original text,
+	// held by the undo entry at offs in the history log.
+	size, offs int64
+}
+
+// Reverts returns the changes that revert the changes that created s,
+// in the coordinates of the current state.
+// The changes are ordered and do not overlap,
+// in the manner of the changes staged by an Editor.
+func (buf *Buffer) reverts(s *state) ([]revert, error) {
+	var path []*state
+	for t := buf.state; t != s; t = t.parent {
+		if t.parent == nil {
+			return nil, ErrNoUndoState
+		}
+		path = append(path, t)
+	}
+
+	// The Spans of the undo entries are in the coordinates
+	// of the text with each earlier entry already undone.
+	// Shift them to the coordinates of the text of s.
+	var reverts []revert
+	var d int64
+	e := logAt(buf.history, s.first)
+	for i := 0; i < s.n; i++ {
+		if e.err != nil {
+			return nil, e.err
+		}
+		r := revert{span: Span{e.span[0] + d, e.span[1] + d}, size: e.size, offs: e.offs}
+		d += e.span.Size() - e.size
+		if r.span.Size() > 0 || r.size > 0 {
+			reverts = append(reverts, r)
+		}
+		e = e.next().next()
+	}
+
+	// A later state followed by the state that reverts it,
+	// with any such pairs between them already skipped,
+	// leaves the text unchanged, so both are skipped.
+	var later []*state
+	for i := len(path) - 1; i >= 0; i-- {
+		if n := len(later); n > 0 && path[i].reverted == later[n-1] {
+			later = later[:n-1]
+		} else {
+			later = append(later, path[i])
+		}
+	}
+
+	// Transform the reverts by the changes of each later state.
+	// The changes of a state are in the coordinates of its parent,
+	// so they are applied in reverse,
+	// as the redo entries are applied by redoState.
+	for _, t := range later {
+		e := logAt(buf.history, t.last)
+		for j := 0; j < t.n; j++ {
+			if e.err != nil {
+				return nil, e.err
+			}
+			for k := range reverts {
+				var ok bool
+				if reverts[k].span, ok = transform(reverts[k].span, e.span, e.size); !ok {
+					return nil, ErrUndoConflict
+				}
+			}
+			e = e.prev().prev()
+		}
+	}
+	return reverts, nil
+}
+
+// Transform returns s updated to account for t changing to size n.
+// Unlike Span.Update, text inserted at either end of s is kept outside of s.
+// If t overlaps s, then t changes the text of s,
+// and transform returns false.
+func transform(s, t Span, n int64) (Span, bool) {
+	switch {
+	case t[1] <= s[0]:
+		d := n - t.Size()
+		return Span{s[0] + d, s[1] + d}, true
+	case t[0] >= s[1]:
+		return s, true
+	default:
+		return s, false
+	}
+}
@@ -66,6 +66,62 @@ func NewBuffer(URL *url.URL) (Buffer, error) {
 	return buf, nil
 }
 
+// OpenBuffer does a PUT with the file URL parameter set to a file path
+// and returns a Buffer from the response body.
+// The buffer's text is read from the file,
+// and the file becomes the buffer's file.
+// If the path is empty, OpenBuffer is the same as NewBuffer.
+// The URL is expected to point at an editor server's buffers list.
+func OpenBuffer(URL *url.URL, file string) (Buffer, error) {
+	var buf Buffer
+	if err := request(withFile(URL, file), http.MethodPut, nil, &buf); err != nil {
+		return Buffer{}, err
+	}
+	return buf, nil
+}
+
+// Save does a POST and returns a Buffer from the response body.
+// The buffer's text is written to its file.
+// The URL is expected to point at the save path of a buffer.
+func Save(URL *url.URL) (Buffer, error) { return SaveAs(URL, "") }
+
+// SaveAs does a POST with the file URL parameter set to a file path
+// and returns a Buffer from the response body.
+// The buffer's text is written to the file,
+// and the file becomes the buffer's file.
+// If the path is empty, SaveAs is the same as Save.
+// The URL is expected to point at the save path of a buffer.
+func SaveAs(URL *url.URL, file string) (Buffer, error) {
+	var buf Buffer
+	if err := request(withFile(URL, file), http.MethodPost, nil, &buf); err != nil {
+		return Buffer{}, err
+	}
+	return buf, nil
+}
+
+// Revert does a POST and returns a Buffer from the response body.
+// The buffer's text is replaced with the contents of its file.
+// The URL is expected to point at the revert path of a buffer.
+func Revert(URL *url.URL) (Buffer, error) {
+	var buf Buffer
+	if err := request(URL, http.MethodPost, nil, &buf); err != nil {
+		return Buffer{}, err
+	}
+	return buf, nil
+}
+
+// WithFile returns a copy of the URL
+// with the file URL parameter set to a non-empty file path.
+func withFile(URL *url.URL, file string) *url.URL {
+	urlCopy := *URL
+	if file != "" {
+		vals := make(url.Values)
+		vals["file"] = []string{file}
+		urlCopy.RawQuery += "&" + vals.Encode()
+	}
+	return &urlCopy
+}
+
 // BufferInfo does a GET and returns a Buffer from the response body.
 // The URL is expected to point at a buffer path.
 func BufferInfo(URL *url.URL) (Buffer, error) {
@@ -157,6 +213,7 @@ func Reader(URL *url.URL, addr edit.Address) (io.ReadCloser, error) {
 
 // Do POSTs a sequence of edits and returns a list of the EditResults
 // from the response body.
+// An edit.SubstituteFunc cannot be sent, and results in an error.
 // The URL is expected to point at an editor path.
 func Do(URL *url.URL, edits ...edit.Edit) ([]EditResult, error) {
 	var eds []editRequest
@@ -174,12 +231,111 @@ func Do(URL *url.URL, edits ...edit.Edit) ([]EditResult, error) {
 	return results, nil
 }
 
+// DoDryRun POSTs a sequence of edits as a dry run
+// and returns a list of the EditResults from the response body.
+// The edits are not applied to the buffer.
+// The mode must be either DryRunChanges or DryRunDiff; see DryRun.
+// The URL is expected to point at an editor path.
+func DoDryRun(URL *url.URL, mode string, edits ...edit.Edit) ([]EditResult, error) {
+	var eds []editRequest
+	for _, ed := range edits {
+		eds = append(eds, editRequest{ed})
+	}
+	body := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(body).Encode(eds); err != nil {
+		return nil, err
+	}
+	urlCopy := *URL
+	vals := make(url.Values)
+	vals["dryRun"] = []string{mode}
+	urlCopy.RawQuery += "&" + vals.Encode()
+	var results []EditResult
+	if err := request(&urlCopy, http.MethodPost, body, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DoBuffers POSTs an X or Y edit and returns a list of the BufferEditResults
+// from the response body.
+// The URL is expected to point at an editor server's buffers list.
+func DoBuffers(URL *url.URL, e edit.Edit) ([]BufferEditResult, error) {
+	body := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(body).Encode(&editRequest{e}); err != nil {
+		return nil, err
+	}
+	var results []BufferEditResult
+	if err := request(URL, http.MethodPost, body, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// UndoStates does a GET and returns a list of UndoStates from the response body.
+// The URL is expected to point at the undo path of a buffer.
+func UndoStates(URL *url.URL) ([]UndoState, error) {
+	var list []UndoState
+	if err := request(URL, http.MethodGet, nil, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// UndoTo POSTs the ID of an undo tree state
+// and returns the EditResult from the response body.
+// The URL is expected to point at the undo path of an editor.
+func UndoTo(URL *url.URL, id int) (EditResult, error) {
+	return undo(URL, id)
+}
+
+// UndoToSequence POSTs the Sequence of an edit
+// and returns the EditResult from the response body.
+// The buffer moves to the undo tree state
+// that was current after the edit.
+// The URL is expected to point at the undo path of an editor.
+func UndoToSequence(URL *url.URL, seq int) (EditResult, error) {
+	return undo(URL, undoRequest{Sequence: &seq})
+}
+
+// UndoToCheckpoint POSTs the name of a checkpoint
+// and returns the EditResult from the response body.
+// The URL is expected to point at the undo path of an editor.
+func UndoToCheckpoint(URL *url.URL, name string) (EditResult, error) {
+	return undo(URL, undoRequest{Checkpoint: name})
+}
+
+func undo(URL *url.URL, req interface{}) (EditResult, error) {
+	body := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(body).Encode(req); err != nil {
+		return EditResult{}, err
+	}
+	var result EditResult
+	if err := request(URL, http.MethodPost, body, &result); err != nil {
+		return EditResult{}, err
+	}
+	return result, nil
+}
+
 func responseError(resp *http.Response) error {
 	switch resp.StatusCode {
 	case http.StatusNotFound:
 		return ErrNotFound
 	case http.StatusRequestedRangeNotSatisfiable:
 		return ErrRange
+	case http.StatusBadRequest:
+		data, _ := ioutil.ReadAll(resp.Body)
+		var errResp ErrorResponse
+		if resp.Header.Get("Content-Type") != "application/json" ||
+			json.Unmarshal(data, &errResp) != nil {
+			return errors.New(resp.Status + ": " + string(data))
+		}
+		if errResp.Parse != nil {
+			if errResp.Cause != "" {
+				errResp.Parse.Err = errors.New(errResp.Cause)
+			}
+			return errResp.Parse
+		}
+		return errors.New(resp.Status + ": " + errResp.Error)
 	default:
 		data, _ := ioutil.ReadAll(resp.Body)
 		return errors.New(resp.Status + ": " + string(data))
@@ -0,0 +1,199 @@
+// Copyright © 2016, The T Authors.
+
+package editor
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// diffContext is the number of lines of context in a unified diff hunk.
+const diffContext = 3
+
+type diffLine struct {
+	// op is one of ' ', '-', or '+'.
+	op   byte
+	text string
+}
+
+// UnifiedDiff returns a unified diff of the lines of a and b.
+// Both files in the diff header are given the same name.
+// If a and b are equal, the empty string is returned.
+func unifiedDiff(name, a, b string) string {
+	if a == b {
+		return ""
+	}
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("--- " + name + "\n")
+	buf.WriteString("+++ " + name + "\n")
+	// al and bl are the 0-based line numbers of lines[i] in a and b.
+	var al, bl int
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			al++
+			bl++
+			i++
+			continue
+		}
+		// Back up to include the leading context.
+		start := i
+		for start > 0 && i-start < diffContext {
+			start--
+		}
+		a0, b0 := al-(i-start), bl-(i-start)
+
+		// Extend the hunk until there are more than
+		// 2*diffContext unchanged lines before the next change,
+		// or the end of the lines.
+		end, same := i, 0
+		for ; end < len(lines) && same <= 2*diffContext; end++ {
+			if lines[end].op == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+		}
+		if same > diffContext {
+			end -= same - diffContext
+		}
+
+		var an, bn int
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				an++
+			}
+			if l.op != '-' {
+				bn++
+			}
+		}
+		buf.WriteString("@@ -" + hunkRange(a0, an) + " +" + hunkRange(b0, bn) + " @@\n")
+		for _, l := range lines[start:end] {
+			buf.WriteByte(l.op)
+			buf.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		for _, l := range lines[i:end] {
+			if l.op != '+' {
+				al++
+			}
+			if l.op != '-' {
+				bl++
+			}
+		}
+		i = end
+	}
+	return buf.String()
+}
+
+func hunkRange(l, n int) string {
+	if n == 0 {
+		return strconv.Itoa(l) + ",0"
+	}
+	if n == 1 {
+		return strconv.Itoa(l + 1)
+	}
+	return strconv.Itoa(l+1) + "," + strconv.Itoa(n)
+}
+
+// SplitLines returns the lines of s, each including its terminating newline.
+// The final line has no newline if s does not end with a newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// DiffLines returns a shortest edit script from a to b,
+// computed with Myers' algorithm.
+func diffLines(a, b []string) []diffLine {
+	var prefix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	var suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append(suffix, diffLine{' ', a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	lines := append(prefix, myers(a, b)...)
+	for i := len(suffix) - 1; i >= 0; i-- {
+		lines = append(lines, suffix[i])
+	}
+	return lines
+}
+
+func myers(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	// v[max+1+k] is the furthest x reached on diagonal k.
+	v := make([]int, 2*max+3)
+	// trace[d] is the window of v, diagonals -d-1 through d+1,
+	// before step d.
+	var trace [][]int
+	var d int
+search:
+	for d = 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[max-d:max+d+3]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[max+k] < v[max+k+2] {
+				x = v[max+k+2]
+			} else {
+				x = v[max+k] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k+1] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var rev []diffLine
+	x, y := n, m
+	for ; d > 0; d-- {
+		// w(k) is the furthest x on diagonal k before step d.
+		w := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || k != d && w(k-1) < w(k+1) {
+			prevK = k + 1
+		}
+		prevX := w(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			rev = append(rev, diffLine{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			rev = append(rev, diffLine{'+', b[y-1]})
+			y--
+		} else {
+			rev = append(rev, diffLine{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		rev = append(rev, diffLine{' ', a[x-1]})
+		x--
+		y--
+	}
+	lines := make([]diffLine, len(rev))
+	for i, l := range rev {
+		lines[len(rev)-1-i] = l
+	}
+	return lines
+}
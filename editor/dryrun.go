@@ -0,0 +1,248 @@
+// Copyright © 2016, The T Authors.
+
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os/exec"
+
+	"github.com/eaburns/T/edit"
+)
+
+const (
+	// DryRunChanges is the dry run mode
+	// that reports the Changes that edits would make.
+	DryRunChanges = "changes"
+
+	// DryRunDiff is the dry run mode
+	// that reports a unified diff of the changes that edits would make.
+	DryRunDiff = "diff"
+)
+
+// DryRun performs a sequence of edits on a copy of an Editor's text,
+// leaving the Editor's text and marks unchanged,
+// and returns an EditResult for each edit.
+// Each edit is performed on the text as changed by the edits before it.
+//
+// The mode must be either DryRunChanges or DryRunDiff.
+// With DryRunChanges, the Changes field of each EditResult
+// is set to the changes that the edit would make.
+// With DryRunDiff, the Diff field of each EditResult
+// is set to a unified diff of the text before and after the edit.
+// The Sequence field of each EditResult is 0.
+//
+// Files written by the edits are discarded, not written.
+// Pipe edits result in an error, and their commands are not run,
+// since the commands could have effects beyond the text.
+// There is no undo history in a dry run,
+// so undo, redo, undo-to, and selective undo edits result in an error.
+//
+// The Changes are in RuneUnits.
+func DryRun(ed edit.Editor, mode string, edits ...edit.Edit) ([]EditResult, error) {
+	return dryRunUnits(ed, mode, RuneUnits, edits...)
+}
+
+// DryRunUnits is like DryRun,
+// but the Changes are in the given units,
+// either RuneUnits or ByteUnits.
+func dryRunUnits(ed edit.Editor, mode, units string, edits ...edit.Edit) ([]EditResult, error) {
+	if mode != DryRunChanges && mode != DryRunDiff {
+		return nil, errors.New("bad dry run mode: " + mode)
+	}
+	d, err := newDryEditor(ed)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Buffer.Close()
+
+	name := ed.FileName()
+	if name == "" {
+		name = "buffer"
+	}
+	var results []EditResult
+	print := bytes.NewBuffer(nil)
+	for _, e := range edits {
+		var before []byte
+		if mode == DryRunDiff {
+			if before, err = d.text(); err != nil {
+				return nil, err
+			}
+		}
+		d.changes = nil
+		d.byteChanges = nil
+		print.Reset()
+		var result EditResult
+		if err := e.Do(d, print); err != nil {
+			result.Error = err.Error()
+		}
+		result.Print = print.String()
+		switch {
+		case mode == DryRunChanges && units == ByteUnits:
+			result.Changes = d.byteChanges
+		case mode == DryRunChanges:
+			result.Changes = d.changes
+		default:
+			after, err := d.text()
+			if err != nil {
+				return nil, err
+			}
+			result.Diff = unifiedDiff(name, string(before), string(after))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// A dryEditor is an edit.Editor that edits a copy of another Editor's text.
+type dryEditor struct {
+	*edit.Buffer
+	orig edit.Editor
+
+	// marks is the set of marks that have been copied from orig.
+	marks map[rune]bool
+
+	// applied is the sequence of changes applied to the copy.
+	// Marks of orig are updated by applied when they are copied.
+	applied []change
+
+	// pending are the Changes staged since the last Apply,
+	// and changes are the Changes applied since they were last reset.
+	pending, changes []Change
+
+	// PendingBytes and byteChanges are pending and changes
+	// in ByteUnits.
+	pendingBytes, byteChanges []Change
+}
+
+func newDryEditor(ed edit.Editor) (*dryEditor, error) {
+	buf := edit.NewBuffer()
+	if _, err := buf.Change(edit.Span{}, ed.Reader(edit.Span{0, ed.Size()})); err != nil {
+		buf.Close()
+		return nil, err
+	}
+	if err := buf.Apply(); err != nil {
+		buf.Close()
+		return nil, err
+	}
+	buf.SetFileSystem(dryFileSystem{ed.FileSystem()})
+	buf.SetFileName(ed.FileName())
+	buf.SetEncoding(ed.Encoding())
+	buf.SetLineEnding(ed.LineEnding())
+	return &dryEditor{Buffer: buf, orig: ed, marks: make(map[rune]bool)}, nil
+}
+
+func (d *dryEditor) text() ([]byte, error) {
+	return ioutil.ReadAll(d.Reader(edit.Span{0, d.Size()}))
+}
+
+// copyMark copies a mark from orig, if it was not already copied.
+func (d *dryEditor) copyMark(m rune) {
+	if d.marks[m] {
+		return
+	}
+	d.marks[m] = true
+	s := d.orig.Mark(m)
+	for _, c := range d.applied {
+		if m == '.' && c.span[0] == s[0] {
+			s[1] = s.Update(c.span, c.size)[1]
+		} else {
+			s = s.Update(c.span, c.size)
+		}
+	}
+	if err := d.Buffer.SetMark(m, s); err != nil {
+		panic(err)
+	}
+}
+
+func (d *dryEditor) Mark(m rune) edit.Span {
+	d.copyMark(m)
+	return d.Buffer.Mark(m)
+}
+
+func (d *dryEditor) SetMark(m rune, s edit.Span) error {
+	d.marks[m] = true
+	return d.Buffer.SetMark(m, s)
+}
+
+func (d *dryEditor) Change(s edit.Span, r io.Reader) (int64, error) {
+	// Copy dot before changing the text, since Apply updates it.
+	d.copyMark('.')
+	cr := changeReader{r: r}
+	n, err := d.Buffer.Change(s, &cr)
+	if err != nil {
+		d.pending = nil
+		d.pendingBytes = nil
+		return n, err
+	}
+	bs, err := edit.ByteSpan(d.Buffer, s)
+	if err != nil {
+		return n, err
+	}
+	c := Change{Span: s, NewSize: n}
+	if 0 < cr.nbytes && cr.nbytes <= MaxInline {
+		c.Text = cr.text
+	}
+	d.pending = append(d.pending, c)
+	// The NewSize in bytes is set by Apply.
+	c.Span = bs
+	d.pendingBytes = append(d.pendingBytes, c)
+	return n, nil
+}
+
+func (d *dryEditor) Apply() error {
+	if err := d.Buffer.Apply(); err != nil {
+		return err
+	}
+	if err := setByteSizes(d.Buffer, d.pending, d.pendingBytes); err != nil {
+		return err
+	}
+	// Like Buffer.Apply, update the span of each change
+	// by the changes preceding it.
+	for i, c := range d.pending {
+		s := c.Span
+		for _, p := range d.pending[:i] {
+			s = s.Update(p.Span, p.NewSize)
+		}
+		d.applied = append(d.applied, change{span: s, size: c.NewSize})
+	}
+	d.changes = append(d.changes, d.pending...)
+	d.byteChanges = append(d.byteChanges, d.pendingBytes...)
+	d.pending = nil
+	d.pendingBytes = nil
+	return nil
+}
+
+func (d *dryEditor) Undo() error { return errors.New("undo is not supported in a dry run") }
+func (d *dryEditor) Redo() error { return errors.New("redo is not supported in a dry run") }
+
+// UndoStates returns the undo states of the original Editor.
+func (d *dryEditor) UndoStates() []edit.UndoState { return d.orig.UndoStates() }
+
+func (d *dryEditor) UndoTo(int) error { return errors.New("undo is not supported in a dry run") }
+
+func (d *dryEditor) SelectiveUndo() error { return errors.New("undo is not supported in a dry run") }
+
+func (d *dryEditor) UndoToSequence(int) error {
+	return errors.New("undo is not supported in a dry run")
+}
+
+// SetCheckpoint does nothing, since there is no undo history in a dry run.
+func (d *dryEditor) SetCheckpoint(string) {}
+
+// Command returns an error, since pipe edits are not run in a dry run.
+func (d *dryEditor) Command(string) (*exec.Cmd, error) {
+	return nil, errors.New("pipe is not supported in a dry run")
+}
+
+// A dryFileSystem is an edit.FileSystem that discards writes.
+type dryFileSystem struct{ edit.FileSystem }
+
+func (dryFileSystem) Create(string) (io.WriteCloser, error) { return discard{}, nil }
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+func (discard) Close() error                { return nil }
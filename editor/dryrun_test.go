@@ -0,0 +1,211 @@
+// Copyright © 2016, The T Authors.
+
+package editor
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/T/edit"
+	"github.com/eaburns/T/editor/editortest"
+)
+
+func TestDryRun(t *testing.T) {
+	const text = "foo bar foo\nbaz\n"
+	buf := edit.NewBuffer()
+	defer buf.Close()
+	if err := edit.Change(edit.All, text).Do(buf, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	buf.SetFileSystem(noCreateFS{})
+	buf.SetFileName("file")
+	if err := buf.SetMark('a', edit.Span{12, 15}); err != nil {
+		t.Fatal(err)
+	}
+	if err := buf.SetMark('.', edit.Span{4, 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "dryrun_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	touched := filepath.Join(dir, "touched")
+
+	edits := []edit.Edit{
+		edit.Loop(edit.All, "foo", edit.Change(edit.Dot, "☺")),
+		edit.Print(edit.Dot),
+		edit.Print(edit.Mark('a')),
+		edit.Copy(edit.Mark('a'), edit.Line(0)),
+		edit.WriteFile(edit.All, ""),
+		edit.Undo(1),
+		edit.Pipe(edit.All, "touch "+touched),
+		edit.Loop(edit.All, "baz", edit.PipeTo(edit.Dot, "touch "+touched)),
+	}
+	want := []EditResult{
+		{Changes: []Change{
+			{Span: edit.Span{0, 3}, NewSize: 1, Text: []byte("☺")},
+			{Span: edit.Span{8, 11}, NewSize: 1, Text: []byte("☺")},
+		}},
+		{Print: "☺"},
+		{Print: "baz"},
+		{Changes: []Change{{Span: edit.Span{0, 0}, NewSize: 3, Text: []byte("baz")}}},
+		{},
+		{Error: "undo is not supported in a dry run"},
+		{Error: "pipe is not supported in a dry run"},
+		{Error: "pipe is not supported in a dry run"},
+	}
+	got, err := DryRun(buf, DryRunChanges, edits...)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DryRun(%q, %q, %v...)=%v,%v, want %v,nil", text, DryRunChanges, edits, got, err, want)
+	}
+
+	str, err := ioutil.ReadAll(buf.Reader(edit.Span{0, buf.Size()}))
+	if err != nil || string(str) != text {
+		t.Errorf("after DryRun, text=%q,%v, want %q,nil", str, err, text)
+	}
+	if _, err := os.Stat(touched); !os.IsNotExist(err) {
+		t.Errorf("after DryRun, os.Stat(%q)=_,%v, want not exist", touched, err)
+	}
+	if a := buf.Mark('a'); a != (edit.Span{12, 15}) {
+		t.Errorf("after DryRun, mark a=%v, want %v", a, edit.Span{12, 15})
+	}
+	if dot := buf.Mark('.'); dot != (edit.Span{4, 7}) {
+		t.Errorf("after DryRun, dot=%v, want %v", dot, edit.Span{4, 7})
+	}
+
+	e := edit.Sub(edit.Line(2), "baz", "qux")
+	wantDiff := `--- file
++++ file
+@@ -1,2 +1,2 @@
+ foo bar foo
+-baz
++qux
+`
+	got, err = DryRun(buf, DryRunDiff, e)
+	if err != nil || len(got) != 1 || got[0].Diff != wantDiff {
+		t.Errorf("DryRun(%q, %q, %q)=%v,%v, want diff %q", text, DryRunDiff, e, got, err, wantDiff)
+	}
+
+	if _, err := DryRun(buf, "unknown", e); err == nil {
+		t.Errorf("DryRun(%q, \"unknown\", %q)=_,nil, want error", text, e)
+	}
+}
+
+type noCreateFS struct{}
+
+func (noCreateFS) Open(string) (io.ReadCloser, error) { return nil, errors.New("no open") }
+
+func (noCreateFS) Create(string) (io.WriteCloser, error) { return nil, errors.New("no create") }
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{name: "equal", a: "a\nb\n", b: "a\nb\n", want: ""},
+		{
+			name: "empty to text",
+			a:    "",
+			b:    "a\nb\n",
+			want: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "text to empty",
+			a:    "a\n",
+			b:    "",
+			want: "@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "no newline at end",
+			a:    "a\nb",
+			b:    "a\nc",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name: "context",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "merged hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want: "@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\nnine\n",
+			want: "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -6,4 +6,4 @@\n 6\n 7\n 8\n-9\n+nine\n",
+		},
+		{
+			name: "interleaved",
+			a:    "a\nb\nc\nd\n",
+			b:    "b\nx\nd\ne\n",
+			want: "@@ -1,4 +1,4 @@\n-a\n b\n-c\n+x\n d\n+e\n",
+		},
+	}
+	for _, test := range tests {
+		want := test.want
+		if want != "" {
+			want = "--- name\n+++ name\n" + want
+		}
+		if got := unifiedDiff("name", test.a, test.b); got != want {
+			t.Errorf("%s: unifiedDiff(%q, %q)=\n%s\nwant\n%s", test.name, test.a, test.b, got, want)
+		}
+	}
+}
+
+func TestDoDryRun(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+	if _, err := Do(textURL, edit.Change(edit.All, "Hello, World\n")); err != nil {
+		t.Fatalf("Do(%q, …)=_,%v, want _,nil", textURL, err)
+	}
+
+	e := edit.Sub(edit.All, "World", "世界")
+	want := []EditResult{{Changes: []Change{{Span: edit.Span{7, 12}, NewSize: 2, Text: []byte("世界")}}}}
+	if got, err := DoDryRun(textURL, DryRunChanges, e); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DoDryRun(%q, %q, %q)=%v,%v, want %v,nil", textURL, DryRunChanges, e, got, err, want)
+	}
+	wantDiff := "--- buffer\n+++ buffer\n@@ -1 +1 @@\n-Hello, World\n+Hello, 世界\n"
+	if got, err := DoDryRun(textURL, DryRunDiff, e); err != nil || len(got) != 1 || got[0].Diff != wantDiff {
+		t.Errorf("DoDryRun(%q, %q, %q)=%v,%v, want diff %q", textURL, DryRunDiff, e, got, err, wantDiff)
+	}
+	if _, err := DoDryRun(textURL, "unknown", e); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Errorf("DoDryRun(%q, \"unknown\", %q)=_,%v, want Bad Request", textURL, e, err)
+	}
+
+	r, err := Reader(textURL, nil)
+	if err != nil {
+		t.Fatalf("Reader(%q, nil)=_,%v, want _,nil", textURL, err)
+	}
+	defer r.Close()
+	if str, err := ioutil.ReadAll(r); err != nil || string(str) != "Hello, World\n" {
+		t.Errorf("ReadAll(Reader(%q, nil))=%q,%v, want %q,nil", textURL, str, err, "Hello, World\n")
+	}
+	info, err := BufferInfo(bufferURL)
+	if err != nil || info.Sequence != 1 {
+		t.Errorf("BufferInfo(%q)=%v,%v, want sequence 1", bufferURL, info, err)
+	}
+}
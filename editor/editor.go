@@ -6,8 +6,11 @@ package editor
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/json"
 	"errors"
 	"time"
+	"unicode/utf8"
 
 	"github.com/eaburns/T/edit"
 )
@@ -25,6 +28,36 @@ type Buffer struct {
 	// Sequence is the sequence number of the last edit on the buffer.
 	Sequence int `json:"sequence"`
 
+	// FileName is the path of the file associated with the buffer.
+	// It is set by the e, w, and f edits,
+	// and when the buffer is opened from or saved as a file.
+	FileName string `json:"fileName,omitempty"`
+
+	// Encoding is the name of the encoding
+	// used to read and write the buffer's file;
+	// see edit.Encoding.
+	// It is set by the e and E edits.
+	Encoding string `json:"encoding"`
+
+	// LineEnding is the name of the line ending
+	// used to read and write the buffer's file;
+	// see edit.LineEnding.
+	// It is set by the e and N edits.
+	LineEnding string `json:"lineEnding"`
+
+	// Dirty is whether the buffer's text has changed
+	// since the buffer was last opened, saved, or reverted.
+	// Undoing the changes makes the buffer clean again.
+	Dirty bool `json:"dirty,omitempty"`
+
+	// SavedSequence is the sequence number of the last edit on the buffer
+	// when it was last opened, saved, or reverted.
+	SavedSequence int `json:"savedSequence"`
+
+	// Undo is the usage of the buffer's undo tree
+	// as of the last edit on the buffer.
+	Undo UndoUsage `json:"undo"`
+
 	// Editors containts the buffer's editors.
 	Editors []Editor `json:"editors"`
 }
@@ -39,11 +72,39 @@ type Editor struct {
 
 	// BufferPath is the path to the editor's buffer's resource.
 	BufferPath string `json:"bufferPath"`
+
+	// SelectiveUndo is whether the editor's undo edits
+	// only undo changes made by the editor,
+	// as the edit.SelectiveUndo edit.
+	SelectiveUndo bool `json:"selectiveUndo,omitempty"`
 }
 
 type editRequest struct{ edit.Edit }
 
-func (e *editRequest) MarshalText() ([]byte, error) { return []byte(e.String()), nil }
+// MarshalText returns the Edit in the edit language.
+// If the Edit has its own MarshalText method, that is used instead,
+// so an edit.SubstituteFunc, which cannot be marshaled, is an error.
+func (e *editRequest) MarshalText() ([]byte, error) {
+	if m, ok := e.Edit.(encoding.TextMarshaler); ok {
+		return m.MarshalText()
+	}
+	return []byte(e.String()), nil
+}
+
+// UnmarshalJSON accepts either a JSON string in the edit language
+// or a JSON-encoded edit.Edit AST.
+func (e *editRequest) UnmarshalJSON(data []byte) error {
+	if d := bytes.TrimSpace(data); len(d) > 0 && d[0] != '"' {
+		var err error
+		e.Edit, err = edit.UnmarshalEdit(data)
+		return err
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	return e.UnmarshalText([]byte(text))
+}
 
 func (e *editRequest) UnmarshalText(text []byte) error {
 	var err error
@@ -51,12 +112,49 @@ func (e *editRequest) UnmarshalText(text []byte) error {
 	if e.Edit, err = edit.Ed(r); err != nil {
 		return err
 	}
+	// Edits terminated by a newline, such as pipes and file edits,
+	// leave the newline unconsumed.
+	if r.Len() == 1 && text[len(text)-1] == '\n' {
+		return nil
+	}
 	if l := r.Len(); l != 0 {
-		return errors.New("unexpected trailing text: " + string(text[l:]))
+		rest := text[len(text)-l:]
+		c, _ := utf8.DecodeRune(rest)
+		return &edit.ParseError{
+			Offset:   int64(utf8.RuneCount(text[:len(text)-l])),
+			Rune:     c,
+			Expected: "end of input",
+			Err:      errors.New("unexpected trailing text: " + string(rest)),
+		}
 	}
 	return nil
 }
 
+// An ErrorResponse is the body of a Bad Request response
+// resulting from a malformed Edit or Address.
+type ErrorResponse struct {
+	// Error is the error message.
+	Error string `json:"error"`
+
+	// Parse describes the position of a syntax error.
+	// It is nil if the error is not a syntax error.
+	Parse *edit.ParseError `json:"parse,omitempty"`
+
+	// Cause is the message of the syntax error's underlying error, if any.
+	Cause string `json:"cause,omitempty"`
+}
+
+func newErrorResponse(err error) ErrorResponse {
+	resp := ErrorResponse{Error: err.Error()}
+	if perr, ok := err.(*edit.ParseError); ok {
+		resp.Parse = perr
+		if perr.Err != nil {
+			resp.Cause = perr.Err.Error()
+		}
+	}
+	return resp
+}
+
 // An EditResult is result of performing an edito on a buffer.
 type EditResult struct {
 	// Sequence is the sequence number unique to the edit.
@@ -67,6 +165,23 @@ type EditResult struct {
 
 	// Error is any error that occurred.
 	Error string `json:"error,omitempty"`
+
+	// Changes are the changes that the edit would make.
+	// It is only set by a DryRunChanges dry run.
+	Changes []Change `json:"changes,omitempty"`
+
+	// Diff is a unified diff of the changes that the edit would make.
+	// It is only set by a DryRunDiff dry run.
+	Diff string `json:"diff,omitempty"`
+}
+
+// A BufferEditResult is the result of performing an edit
+// on one of multiple buffers.
+type BufferEditResult struct {
+	// BufferPath is the path to the buffer's resource.
+	BufferPath string `json:"bufferPath"`
+
+	EditResult
 }
 
 // A ChangeList is an atomic sequence of changes
@@ -84,18 +199,30 @@ type ChangeList struct {
 // MaxInline is the maximum size, in bytes, for which Change.Text is set.
 const MaxInline = 8
 
+const (
+	// RuneUnits are the units of Changes
+	// that measure the text of a buffer in runes.
+	// They are the default units.
+	RuneUnits = "runes"
+
+	// ByteUnits are the units of Changes
+	// that measure the text of a buffer
+	// in bytes of its UTF-8 encoding.
+	ByteUnits = "bytes"
+)
+
 // A Change is a single change made to a string of a buffer.
 type Change struct {
 	// Span identifies the string of the buffer that was changed.
 	//
-	// The units of the Span are runes;
-	// the first is the inclusive starting rune index,
-	// and the second is the exclusive ending rune index.
-	//
-	// NOTE: in the future, we plan to change Span to use byte indices.
+	// The first is the inclusive starting index,
+	// and the second is the exclusive ending index.
+	// The units of the Span are requested by the receiver
+	// of the Change: either RuneUnits or ByteUnits.
 	edit.Span `json:"span"`
 
-	// NewSize is the size, in runes, to which the span changed.
+	// NewSize is the size, in the units of the Span,
+	// to which the span changed.
 	NewSize int64 `json:"newSize"`
 
 	// Text is the text to which the span changed.
@@ -103,3 +230,114 @@ type Change struct {
 	// or greater than MaxInline bytes.
 	Text []byte `json:"text"`
 }
+
+// An UndoState describes a state of a buffer's undo tree.
+// See edit.UndoState for details.
+type UndoState struct {
+	// ID is the identifier of the state.
+	ID int `json:"id"`
+
+	// Parent is the ID of the parent state.
+	// The parent of the root state is -1.
+	Parent int `json:"parent"`
+
+	// Changes is the number of changes
+	// made to the parent's text to create the state.
+	Changes int `json:"changes"`
+
+	// Time is the time at which the state was created.
+	Time time.Time `json:"time"`
+
+	// Current is whether the buffer's text is in this state.
+	Current bool `json:"current,omitempty"`
+
+	// Checkpoints are the names of the checkpoints
+	// set at this state, in sorted order.
+	Checkpoints []string `json:"checkpoints,omitempty"`
+}
+
+// An UndoUsage describes the resources used by a buffer's undo tree.
+// See edit.UndoUsage for details.
+type UndoUsage struct {
+	// States is the number of states in the tree.
+	States int `json:"states"`
+
+	// Root is the ID of the root state.
+	Root int `json:"root"`
+
+	// Depth is the depth of the current state.
+	Depth int `json:"depth"`
+
+	// Bytes is the number of bytes
+	// used by the changes of the states of the tree.
+	Bytes int64 `json:"bytes"`
+
+	// LogBytes is the number of bytes
+	// used by the log that records the changes.
+	LogBytes int64 `json:"logBytes"`
+
+	// Oldest is the time at which the oldest state
+	// other than the root was created.
+	Oldest time.Time `json:"oldest"`
+}
+
+func newUndoUsage(u edit.UndoUsage) UndoUsage {
+	return UndoUsage{
+		States:   u.States,
+		Root:     u.Root,
+		Depth:    u.Depth,
+		Bytes:    u.Bytes,
+		LogBytes: u.LogBytes,
+		Oldest:   u.Oldest,
+	}
+}
+
+// An undoRequest is the body of a POST to an editor's undo path
+// that names a state by its ID, a Sequence, or a checkpoint.
+// Exactly one of the fields is set.
+type undoRequest struct {
+	State      *int   `json:"state,omitempty"`
+	Sequence   *int   `json:"sequence,omitempty"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+}
+
+// Edit returns the edit that moves to the requested state.
+func (r undoRequest) edit() (edit.Edit, error) {
+	switch {
+	case r.State != nil && r.Sequence == nil && r.Checkpoint == "":
+		return edit.UndoTo(*r.State), nil
+	case r.State == nil && r.Sequence != nil && r.Checkpoint == "":
+		return edit.UndoToSequence(*r.Sequence), nil
+	case r.State == nil && r.Sequence == nil && r.Checkpoint != "":
+		return edit.UndoToCheckpoint(r.Checkpoint), nil
+	default:
+		return nil, errors.New("exactly one of state, sequence, or checkpoint must be set")
+	}
+}
+
+// UnmarshalJSON accepts either a JSON object with one of the fields set,
+// or a JSON number, which is the ID of the state.
+func (r *undoRequest) UnmarshalJSON(data []byte) error {
+	if d := bytes.TrimSpace(data); len(d) > 0 && d[0] != '{' {
+		r.State = new(int)
+		return json.Unmarshal(data, r.State)
+	}
+	type plain undoRequest
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+func newUndoStates(states []edit.UndoState) []UndoState {
+	list := make([]UndoState, len(states))
+	for i, s := range states {
+		list[i] = UndoState{
+			ID:      s.ID,
+			Parent:  s.Parent,
+			Changes: s.Changes,
+			Time:    s.Time,
+			Current: s.Current,
+
+			Checkpoints: s.Checkpoints,
+		}
+	}
+	return list
+}
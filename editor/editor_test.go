@@ -3,9 +3,13 @@
 package editor
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"math"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -309,6 +313,440 @@ func TestDo(t *testing.T) {
 	}
 }
 
+func TestDo_JSON(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+
+	body := `[
+		{"type": "append", "addr": {"type": "end"}, "text": "Hello, 世界"},
+		",s/世界/World/",
+		{"type": "print", "addr": {"type": "to", "left": {"type": "line"}, "right": {"type": "end"}}}
+	]`
+	resp, err := http.Post(textURL.String(), "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %q failed: %v", textURL, err)
+	}
+	defer resp.Body.Close()
+	var got []EditResult
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode []EditResult: %v", err)
+	}
+	want := []EditResult{{Sequence: 1}, {Sequence: 2}, {Sequence: 3, Print: "Hello, World"}}
+	if resp.StatusCode != http.StatusOK || !reflect.DeepEqual(got, want) {
+		t.Errorf("POST %q=%d %v, want %d %v", textURL, resp.StatusCode, got, http.StatusOK, want)
+	}
+}
+
+func TestDo_ParseError(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+
+	e := edit.Sub(edit.All, "(", "x")
+	if _, err := Do(textURL, e); !isParseError(err, 5, '(') {
+		t.Errorf("Do(%q, %q)=_,%v, want *edit.ParseError at 5", textURL, e, err)
+	}
+
+	a := edit.Regexp("[")
+	if _, err := Reader(textURL, a); !isParseError(err, 1, '[') {
+		t.Errorf("Reader(%q, %q)=_,%v, want *edit.ParseError at 1", textURL, a, err)
+	}
+
+	resp, err := http.Post(textURL.String(), "application/json", strings.NewReader(`["1dx"]`))
+	if err != nil {
+		t.Fatalf("POST %q failed: %v", textURL, err)
+	}
+	defer resp.Body.Close()
+	var errResp ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode ErrorResponse: %v", err)
+	}
+	want := &edit.ParseError{Offset: 2, Rune: 'x', Expected: "end of input"}
+	if resp.StatusCode != http.StatusBadRequest || !reflect.DeepEqual(errResp.Parse, want) {
+		t.Errorf("POST %q=%d %#v, want %d %#v", textURL, resp.StatusCode, errResp.Parse, http.StatusBadRequest, want)
+	}
+}
+
+func TestDo_Files(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "my file")
+
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+
+	const hi = "Hello, 世界"
+	edits := []edit.Edit{
+		edit.Append(edit.All, hi),      // 1
+		edit.WriteFile(edit.All, path), // 2
+		edit.Change(edit.All, ""),      // 3
+		edit.EditFile(""),              // 4
+		edit.Print(edit.All),           // 5
+		edit.File(""),                  // 6
+	}
+	want := []EditResult{
+		{Sequence: 1},
+		{Sequence: 2},
+		{Sequence: 3},
+		{Sequence: 4},
+		{Sequence: 5, Print: hi},
+		{Sequence: 6, Print: path + "\n"},
+	}
+	textURL := s.PathURL(ed.Path, "text")
+	got, err := Do(textURL, edits...)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Do(%q, %v...)=%v,%v, want %v,nil", textURL, edits, got, err, want)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != hi {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, hi)
+	}
+	if info, err := BufferInfo(bufferURL); err != nil || info.FileName != path {
+		t.Errorf("BufferInfo(%q)=%v,%v, want FileName=%q", bufferURL, info, err, path)
+	}
+}
+
+func TestDo_Encoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "latin1")
+	if err := ioutil.WriteFile(path, []byte("caf\xE9\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil || buf.Encoding != "auto" {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want Encoding=auto,nil", buffersURL, buf, err)
+	}
+
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+
+	textURL := s.PathURL(ed.Path, "text")
+	edits := []edit.Edit{
+		edit.EditFile(path),
+		edit.Append(edit.End, "naïve\n"),
+		edit.WriteFile(edit.All, ""),
+	}
+	if _, err := Do(textURL, edits...); err != nil {
+		t.Fatalf("Do(%q, %v...)=_,%v, want _,nil", textURL, edits, err)
+	}
+	if info, err := BufferInfo(bufferURL); err != nil || info.Encoding != "iso-8859-1" {
+		t.Errorf("BufferInfo(%q)=%v,%v, want Encoding=iso-8859-1", bufferURL, info, err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "caf\xE9\nna\xEFve\n" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "caf\xE9\nna\xEFve\n")
+	}
+}
+
+func TestDo_LineEnding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "crlf")
+	if err := ioutil.WriteFile(path, []byte("a\r\nb\r\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil || buf.LineEnding != "auto" {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want LineEnding=auto,nil", buffersURL, buf, err)
+	}
+
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+
+	textURL := s.PathURL(ed.Path, "text")
+	edits := []edit.Edit{
+		edit.EditFile(path),
+		edit.Append(edit.End, "c\n"),
+		edit.WriteFile(edit.All, ""),
+		edit.Print(edit.Line(2)),
+	}
+	res, err := Do(textURL, edits...)
+	if err != nil || len(res) != len(edits) || res[3].Print != "b\n" {
+		t.Fatalf("Do(%q, %v...)=%v,%v, want res[3].Print=%q,nil", textURL, edits, res, err, "b\n")
+	}
+	if info, err := BufferInfo(bufferURL); err != nil || info.LineEnding != "crlf" {
+		t.Errorf("BufferInfo(%q)=%v,%v, want LineEnding=crlf", bufferURL, info, err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "a\r\nb\r\nc\r\n" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "a\r\nb\r\nc\r\n")
+	}
+}
+
+func TestOpenSaveRevert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a")
+	if err := ioutil.WriteFile(path, []byte("Hello\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := OpenBuffer(buffersURL, path)
+	if err != nil || buf.FileName != path || buf.LineEnding != "crlf" || buf.Dirty || buf.SavedSequence != 1 {
+		t.Fatalf("OpenBuffer(%q, %q)=%v,%v, want FileName=%q,LineEnding=crlf,Dirty=false,SavedSequence=1",
+			buffersURL, path, buf, err, path)
+	}
+
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, ed, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+	if _, err := Do(textURL, edit.Append(edit.End, "World\n")); err != nil {
+		t.Fatalf("Do(%q, …)=_,%v, want _,nil", textURL, err)
+	}
+	if buf, err := BufferInfo(bufferURL); err != nil || !buf.Dirty || buf.SavedSequence != 1 {
+		t.Errorf("BufferInfo(%q)=%v,%v, want Dirty=true,SavedSequence=1", bufferURL, buf, err)
+	}
+
+	saveURL := s.PathURL(buf.Path, "save")
+	if buf, err := Save(saveURL); err != nil || buf.Dirty || buf.SavedSequence != 2 {
+		t.Errorf("Save(%q)=%v,%v, want Dirty=false,SavedSequence=2", saveURL, buf, err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "Hello\r\nWorld\r\n" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "Hello\r\nWorld\r\n")
+	}
+	// The saved file keeps its permissions,
+	// and no temporary file is left behind.
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("os.Stat(%q)=%v,%v, want mode 0600", path, fi, err)
+	}
+	if fis, err := ioutil.ReadDir(dir); err != nil || len(fis) != 1 {
+		t.Errorf("ioutil.ReadDir(%q)=%v,%v, want 1 file", dir, fis, err)
+	}
+
+	// Undoing to the saved state is clean; redoing is dirty.
+	if _, err := Do(textURL, edit.Delete(edit.All), edit.Undo(1)); err != nil {
+		t.Fatalf("Do(%q, …)=_,%v, want _,nil", textURL, err)
+	}
+	if buf, err := BufferInfo(bufferURL); err != nil || buf.Dirty {
+		t.Errorf("BufferInfo(%q)=%v,%v, want Dirty=false", bufferURL, buf, err)
+	}
+	if _, err := Do(textURL, edit.Redo(1)); err != nil {
+		t.Fatalf("Do(%q, …)=_,%v, want _,nil", textURL, err)
+	}
+	if buf, err := BufferInfo(bufferURL); err != nil || !buf.Dirty {
+		t.Errorf("BufferInfo(%q)=%v,%v, want Dirty=true", bufferURL, buf, err)
+	}
+
+	revertURL := s.PathURL(buf.Path, "revert")
+	if buf, err := Revert(revertURL); err != nil || buf.Dirty || buf.Sequence != 6 || buf.SavedSequence != 6 {
+		t.Errorf("Revert(%q)=%v,%v, want Dirty=false,Sequence=6,SavedSequence=6", revertURL, buf, err)
+	}
+	if res, err := Do(textURL, edit.Print(edit.All)); err != nil || len(res) != 1 || res[0].Print != "Hello\nWorld\n" {
+		t.Errorf("Do(%q, ,p)=%v,%v, want Print=%q", textURL, res, err, "Hello\nWorld\n")
+	}
+
+	path2 := filepath.Join(dir, "b")
+	if buf, err := SaveAs(saveURL, path2); err != nil || buf.FileName != path2 || buf.Dirty {
+		t.Errorf("SaveAs(%q, %q)=%v,%v, want FileName=%q,Dirty=false", saveURL, path2, buf, err, path2)
+	}
+	if data, err := ioutil.ReadFile(path2); err != nil || string(data) != "Hello\r\nWorld\r\n" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path2, data, err, "Hello\r\nWorld\r\n")
+	}
+}
+
+func TestSave_Error(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "latin1")
+	if err := ioutil.WriteFile(path, []byte("caf\xE9\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := OpenBuffer(buffersURL, path)
+	if err != nil {
+		t.Fatalf("OpenBuffer(%q, %q)=%v,%v, want _,nil", buffersURL, path, buf, err)
+	}
+	ed, err := NewEditor(s.PathURL(buf.Path))
+	if err != nil {
+		t.Fatalf("NewEditor(…)=%v,%v, want _,nil", ed, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+	if _, err := Do(textURL, edit.Append(edit.End, "世界\n")); err != nil {
+		t.Fatalf("Do(%q, …)=_,%v, want _,nil", textURL, err)
+	}
+
+	// 世界 cannot be encoded in ISO-8859-1,
+	// so the save fails and the file is not changed.
+	saveURL := s.PathURL(buf.Path, "save")
+	if buf, err := Save(saveURL); err == nil {
+		t.Errorf("Save(%q)=%v,nil, want _,non-nil", saveURL, buf)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "caf\xE9\n" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "caf\xE9\n")
+	}
+	if fis, err := ioutil.ReadDir(dir); err != nil || len(fis) != 1 {
+		t.Errorf("ioutil.ReadDir(%q)=%v,%v, want 1 file", dir, fis, err)
+	}
+	if buf, err := BufferInfo(s.PathURL(buf.Path)); err != nil || !buf.Dirty {
+		t.Errorf("BufferInfo(…)=%v,%v, want Dirty=true", buf, err)
+	}
+
+	notFound := filepath.Join(dir, "notfound")
+	if buf, err := OpenBuffer(buffersURL, notFound); err != ErrNotFound {
+		t.Errorf("OpenBuffer(%q, %q)=%v,%v, want _,%v", buffersURL, notFound, buf, err, ErrNotFound)
+	}
+
+	buf, err = NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	saveURL = s.PathURL(buf.Path, "save")
+	if buf, err := Save(saveURL); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Errorf("Save(%q)=%v,%v, want _,Bad Request", saveURL, buf, err)
+	}
+	revertURL := s.PathURL(buf.Path, "revert")
+	if buf, err := Revert(revertURL); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Errorf("Revert(%q)=%v,%v, want _,Bad Request", revertURL, buf, err)
+	}
+	if buf, err := Save(s.PathURL("buffer", "notfound", "save")); err != ErrNotFound {
+		t.Errorf("Save(notfound)=%v,%v, want _,%v", buf, err, ErrNotFound)
+	}
+}
+
+// Tests that e and w edits of the buffer's file mark the buffer saved.
+func TestEditWriteFileDirty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a")
+	if err := ioutil.WriteFile(path, []byte("Hello\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, ed, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+
+	if _, err := Do(textURL, edit.EditFile(path)); err != nil {
+		t.Fatalf("Do(%q, e)=_,%v, want _,nil", textURL, err)
+	}
+	if buf, err := BufferInfo(bufferURL); err != nil || buf.Dirty || buf.SavedSequence != buf.Sequence {
+		t.Errorf("BufferInfo(%q)=%v,%v, want Dirty=false,SavedSequence=Sequence", bufferURL, buf, err)
+	}
+
+	if _, err := Do(textURL, edit.Append(edit.End, "World\n")); err != nil {
+		t.Fatalf("Do(%q, …)=_,%v, want _,nil", textURL, err)
+	}
+	if buf, err := BufferInfo(bufferURL); err != nil || !buf.Dirty {
+		t.Errorf("BufferInfo(%q)=%v,%v, want Dirty=true", bufferURL, buf, err)
+	}
+
+	// Writing part of the text, or writing to a different file,
+	// does not save the buffer.
+	if _, err := Do(textURL, edit.WriteFile(edit.Line(1), "")); err != nil {
+		t.Fatalf("Do(%q, 1w)=_,%v, want _,nil", textURL, err)
+	}
+	path2 := filepath.Join(dir, "b")
+	if _, err := Do(textURL, edit.WriteFile(edit.All, path2)); err != nil {
+		t.Fatalf("Do(%q, ,w %s)=_,%v, want _,nil", textURL, path2, err)
+	}
+	if buf, err := BufferInfo(bufferURL); err != nil || !buf.Dirty || buf.FileName != path {
+		t.Errorf("BufferInfo(%q)=%v,%v, want Dirty=true,FileName=%q", bufferURL, buf, err, path)
+	}
+
+	if _, err := Do(textURL, edit.WriteFile(edit.All, "")); err != nil {
+		t.Fatalf("Do(%q, ,w)=_,%v, want _,nil", textURL, err)
+	}
+	if buf, err := BufferInfo(bufferURL); err != nil || buf.Dirty || buf.SavedSequence != buf.Sequence {
+		t.Errorf("BufferInfo(%q)=%v,%v, want Dirty=false,SavedSequence=Sequence", bufferURL, buf, err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "Hello\nWorld\n" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "Hello\nWorld\n")
+	}
+	// The write is atomic, leaving no temporary file behind.
+	if fis, err := ioutil.ReadDir(dir); err != nil || len(fis) != 2 {
+		t.Errorf("ioutil.ReadDir(%q)=%v,%v, want 2 files", dir, fis, err)
+	}
+}
+
 func TestDo_Nothing(t *testing.T) {
 	s := editortest.NewServer(NewServer())
 	defer s.Close()
@@ -371,6 +809,13 @@ func TestDo_BadRequest(t *testing.T) {
 				req.Method, req.URL, resp.StatusCode, err, http.StatusBadRequest)
 		}
 	}
+
+	// A SubstituteFunc cannot be sent to the server.
+	upper := func(s []string) string { return strings.ToUpper(s[0]) }
+	e := edit.SubFunc(edit.All, "a", upper)
+	if res, err := Do(textURL, e); err == nil || !strings.Contains(err.Error(), edit.ErrSubstituteFunc.Error()) {
+		t.Errorf("Do(%q, %q)=%v,%v, want _,%v", textURL, e, res, err, edit.ErrSubstituteFunc)
+	}
 }
 
 func TestEditorEdit_UpdateMarks(t *testing.T) {
@@ -478,6 +923,393 @@ func TestEditorEdit_MultipleEditors(t *testing.T) {
 	}
 }
 
+func TestDoBuffers(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	var bufs []Buffer
+	var textURLs []*url.URL
+	for _, name := range []string{"a.go", "b.c", "c.go", ""} {
+		buf, err := NewBuffer(buffersURL)
+		if err != nil {
+			t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+		}
+		bufferURL := s.PathURL(buf.Path)
+		ed, err := NewEditor(bufferURL)
+		if err != nil {
+			t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+		}
+		textURL := s.PathURL(ed.Path, "text")
+		edits := []edit.Edit{edit.Append(edit.All, "foo foo"), edit.File(name)}
+		if _, err := Do(textURL, edits...); err != nil {
+			t.Fatalf("Do(%q, %v...)=_,%v, want _,nil", textURL, edits, err)
+		}
+		bufs = append(bufs, buf)
+		textURLs = append(textURLs, textURL)
+	}
+
+	e := edit.LoopFiles(`\.go$`, edit.SubGlobal(edit.All, "foo", "bar"))
+	want := []BufferEditResult{
+		{BufferPath: bufs[0].Path, EditResult: EditResult{Sequence: 3}},
+		{BufferPath: bufs[2].Path, EditResult: EditResult{Sequence: 3}},
+	}
+	if got, err := DoBuffers(buffersURL, e); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DoBuffers(%q, %q)=%v,%v, want %v,nil", buffersURL, e, got, err, want)
+	}
+
+	e = edit.LoopFilesNot(`\.go$`, edit.Print(edit.All))
+	want = []BufferEditResult{
+		{BufferPath: bufs[1].Path, EditResult: EditResult{Sequence: 3, Print: "foo foo"}},
+		{BufferPath: bufs[3].Path, EditResult: EditResult{Sequence: 3, Print: "foo foo"}},
+	}
+	if got, err := DoBuffers(buffersURL, e); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DoBuffers(%q, %q)=%v,%v, want %v,nil", buffersURL, e, got, err, want)
+	}
+
+	for i, want := range []string{"bar bar", "foo foo", "bar bar", "foo foo"} {
+		r, err := Reader(textURLs[i], nil)
+		if err != nil {
+			t.Fatalf("Reader(%q, nil)=_,%v, want _,nil", textURLs[i], err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(got) != want {
+			t.Errorf("ReadAll(Reader(%q, nil))=%q,%v, want %q,nil", textURLs[i], got, err, want)
+		}
+	}
+
+	e = edit.Print(edit.All)
+	if _, err := DoBuffers(buffersURL, e); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Errorf("DoBuffers(%q, %q)=_,%v, want Bad Request", buffersURL, e, err)
+	}
+	resp, err := http.Post(buffersURL.String(), "application/json", strings.NewReader(`",p"`))
+	if err != nil {
+		t.Fatalf("POST %q failed: %v", buffersURL, err)
+	}
+	defer resp.Body.Close()
+	var errResp ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode ErrorResponse: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(errResp.Error, "not an X or Y edit") {
+		t.Errorf("POST %q=%d %q, want %d \"not an X or Y edit\"", buffersURL, resp.StatusCode, errResp.Error, http.StatusBadRequest)
+	}
+
+	e = edit.LoopFiles("*", edit.Print(edit.All))
+	if _, err := DoBuffers(buffersURL, e); !isParseError(err, 2, '*') {
+		t.Errorf("DoBuffers(%q, %q)=_,%v, want *edit.ParseError at 2", buffersURL, e, err)
+	}
+}
+
+func isParseError(err error, offs int64, r rune) bool {
+	perr, ok := err.(*edit.ParseError)
+	return ok && perr.Offset == offs && perr.Rune == r
+}
+
+func TestUndo(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+	edits := []edit.Edit{
+		edit.Append(edit.All, "abc"),
+		edit.Undo(1),
+		edit.Append(edit.All, "xyz"),
+	}
+	if _, err := Do(textURL, edits...); err != nil {
+		t.Fatalf("Do(%q, %v...)=_,%v, want _,nil", textURL, edits, err)
+	}
+
+	undoURL := s.PathURL(buf.Path, "undo")
+	states, err := UndoStates(undoURL)
+	if err != nil {
+		t.Fatalf("UndoStates(%q)=%v,%v, want _,nil", undoURL, states, err)
+	}
+	for i := range states {
+		if states[i].Time.IsZero() {
+			t.Errorf("UndoStates(%q)[%d].Time is zero", undoURL, i)
+		}
+		states[i].Time = time.Time{}
+	}
+	wantStates := []UndoState{
+		{ID: 0, Parent: -1},
+		{ID: 1, Parent: 0, Changes: 1},
+		{ID: 2, Parent: 0, Changes: 1, Current: true},
+	}
+	if !reflect.DeepEqual(states, wantStates) {
+		t.Errorf("UndoStates(%q)=%v, want %v", undoURL, states, wantStates)
+	}
+
+	edUndoURL := s.PathURL(ed.Path, "undo")
+	want := EditResult{Sequence: 4}
+	if result, err := UndoTo(edUndoURL, 1); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoTo(%q, 1)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+	r, err := Reader(textURL, nil)
+	if err != nil {
+		t.Fatalf("Reader(%q, nil)=_,%v, want _,nil", textURL, err)
+	}
+	defer r.Close()
+	if str, err := ioutil.ReadAll(r); err != nil || string(str) != "abc" {
+		t.Errorf("ReadAll(Reader(%q, nil))=%q,%v, want %q,nil", textURL, str, err, "abc")
+	}
+
+	want = EditResult{Sequence: 5, Error: edit.ErrNoUndoState.Error()}
+	if result, err := UndoTo(edUndoURL, 100); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoTo(%q, 100)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+
+	notFoundURL := s.PathURL("/", "buffer", "notfound", "undo")
+	if _, err := UndoStates(notFoundURL); err != ErrNotFound {
+		t.Errorf("UndoStates(%q)=_,%v, want %v", notFoundURL, err, ErrNotFound)
+	}
+	notFoundURL = s.PathURL("/", "editor", "notfound", "undo")
+	if _, err := UndoTo(notFoundURL, 0); err != ErrNotFound {
+		t.Errorf("UndoTo(%q, 0)=_,%v, want %v", notFoundURL, err, ErrNotFound)
+	}
+	resp, err := http.Post(edUndoURL.String(), "application/json", strings.NewReader("not json"))
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("http.Post(%q, …, \"not json\")=%v,%v, want %v,nil",
+			edUndoURL, resp.StatusCode, err, http.StatusBadRequest)
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+func TestUndoToSequenceAndCheckpoint(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+	edits := []edit.Edit{
+		edit.Append(edit.All, "abc"),
+		edit.Print(edit.All),
+		edit.Checkpoint("batch"),
+		edit.Append(edit.End, "xyz"),
+		edit.Append(edit.End, "123"),
+	}
+	if _, err := Do(textURL, edits...); err != nil {
+		t.Fatalf("Do(%q, %v...)=_,%v, want _,nil", textURL, edits, err)
+	}
+	text := func() string {
+		r, err := Reader(textURL, nil)
+		if err != nil {
+			t.Fatalf("Reader(%q, nil)=_,%v, want _,nil", textURL, err)
+		}
+		defer r.Close()
+		str, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll(Reader(%q, nil))=_,%v, want _,nil", textURL, err)
+		}
+		return string(str)
+	}
+
+	undoURL := s.PathURL(buf.Path, "undo")
+	states, err := UndoStates(undoURL)
+	if err != nil {
+		t.Fatalf("UndoStates(%q)=%v,%v, want _,nil", undoURL, states, err)
+	}
+	if len(states) != 4 || !reflect.DeepEqual(states[1].Checkpoints, []string{"batch"}) {
+		t.Errorf("UndoStates(%q)=%v, want checkpoint batch at state 1", undoURL, states)
+	}
+
+	edUndoURL := s.PathURL(ed.Path, "undo")
+	// Sequence 2 printed; the current state was that after sequence 1.
+	want := EditResult{Sequence: 6}
+	if result, err := UndoToSequence(edUndoURL, 2); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoToSequence(%q, 2)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+	if str := text(); str != "abc" {
+		t.Errorf("after UndoToSequence(%q, 2), text=%q, want %q", edUndoURL, str, "abc")
+	}
+
+	want = EditResult{Sequence: 7}
+	if result, err := UndoToSequence(edUndoURL, 5); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoToSequence(%q, 5)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+	if str := text(); str != "abcxyz123" {
+		t.Errorf("after UndoToSequence(%q, 5), text=%q, want %q", edUndoURL, str, "abcxyz123")
+	}
+
+	want = EditResult{Sequence: 8}
+	if result, err := UndoToCheckpoint(edUndoURL, "batch"); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoToCheckpoint(%q, batch)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+	if str := text(); str != "abc" {
+		t.Errorf("after UndoToCheckpoint(%q, batch), text=%q, want %q", edUndoURL, str, "abc")
+	}
+
+	want = EditResult{Sequence: 9, Error: edit.ErrNoUndoState.Error()}
+	if result, err := UndoToSequence(edUndoURL, 100); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoToSequence(%q, 100)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+	want = EditResult{Sequence: 10, Error: edit.ErrNoCheckpoint.Error()}
+	if result, err := UndoToCheckpoint(edUndoURL, "none"); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoToCheckpoint(%q, none)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+
+	for _, body := range []string{`{}`, `{"state": 1, "sequence": 1}`, `{"state": "x"}`} {
+		resp, err := http.Post(edUndoURL.String(), "application/json", strings.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("http.Post(%q, …, %q)=%v,%v, want %v,nil",
+				edUndoURL, body, resp.StatusCode, err, http.StatusBadRequest)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+func TestUndoLimits(t *testing.T) {
+	server := NewServer()
+	server.SetUndoLimits(edit.UndoLimits{Depth: 1})
+	s := editortest.NewServer(server)
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	if want := (UndoUsage{States: 1}); buf.Undo != want {
+		t.Errorf("NewBuffer(%q).Undo=%+v, want %+v", buffersURL, buf.Undo, want)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+	edits := []edit.Edit{
+		edit.Append(edit.End, "abc"),
+		edit.Append(edit.End, "xyz"),
+		edit.Append(edit.End, "123"),
+	}
+	if _, err := Do(textURL, edits...); err != nil {
+		t.Fatalf("Do(%q, %v...)=_,%v, want _,nil", textURL, edits, err)
+	}
+	info, err := BufferInfo(bufferURL)
+	if err != nil {
+		t.Fatalf("BufferInfo(%q)=%v,%v, want _,nil", bufferURL, info, err)
+	}
+	if u := info.Undo; u.States != 2 || u.Root != 2 || u.Depth != 1 || u.Bytes == 0 || u.Oldest.IsZero() {
+		t.Errorf("BufferInfo(%q).Undo=%+v, want States=2, Root=2, Depth=1, non-zero Bytes and Oldest",
+			bufferURL, u)
+	}
+
+	edUndoURL := s.PathURL(ed.Path, "undo")
+	want := EditResult{Sequence: 4, Error: edit.ErrNoUndoState.Error()}
+	if result, err := UndoToSequence(edUndoURL, 1); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoToSequence(%q, 1)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+	want = EditResult{Sequence: 5}
+	if result, err := UndoToSequence(edUndoURL, 2); err != nil || !reflect.DeepEqual(result, want) {
+		t.Errorf("UndoToSequence(%q, 2)=%v,%v, want %v,nil", edUndoURL, result, err, want)
+	}
+	if results, err := Do(textURL, edit.Print(edit.All)); err != nil || len(results) != 1 || results[0].Print != "abcxyz" {
+		t.Errorf("Do(%q, %q)=%v,%v, want print %q", textURL, edit.Print(edit.All), results, err, "abcxyz")
+	}
+}
+
+func TestSelectiveUndo(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	selectiveURL := *bufferURL
+	selectiveURL.RawQuery = "selectiveUndo=true"
+	ed0, err := NewEditor(&selectiveURL)
+	if err != nil || !ed0.SelectiveUndo {
+		t.Fatalf("NewEditor(%q)=%v,%v, want selective undo,nil", selectiveURL.String(), ed0, err)
+	}
+	ed1, err := NewEditor(bufferURL)
+	if err != nil || ed1.SelectiveUndo {
+		t.Fatalf("NewEditor(%q)=%v,%v, want non-selective undo,nil", bufferURL, ed1, err)
+	}
+	text0URL := s.PathURL(ed0.Path, "text")
+	text1URL := s.PathURL(ed1.Path, "text")
+
+	do := func(URL *url.URL, e edit.Edit) {
+		if res, err := Do(URL, e); err != nil || res[0].Error != "" {
+			t.Fatalf("Do(%q, %q)=%v,%v, want no error", URL, e, res, err)
+		}
+	}
+	text := func() string {
+		r, err := Reader(text0URL, nil)
+		if err != nil {
+			t.Fatalf("Reader(%q, nil)=_,%v, want _,nil", text0URL, err)
+		}
+		defer r.Close()
+		str, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll(Reader(%q, nil))=_,%v, want _,nil", text0URL, err)
+		}
+		return string(str)
+	}
+
+	do(text0URL, edit.Append(edit.End, "Hello, "))
+	do(text1URL, edit.Append(edit.End, "World"))
+	do(text0URL, edit.Undo(1))
+	if str := text(); str != "World" {
+		t.Errorf("after ed0 undo, text=%q, want %q", str, "World")
+	}
+	do(text0URL, edit.Append(edit.End, "!"))
+	do(text1URL, edit.SelectiveUndo(1))
+	if str := text(); str != "!" {
+		t.Errorf("after ed1 selective undo, text=%q, want %q", str, "!")
+	}
+	do(text1URL, edit.Undo(1))
+	if str := text(); str != "World!" {
+		t.Errorf("after ed1 undo, text=%q, want %q", str, "World!")
+	}
+
+	// A conflicting selective undo is not lost;
+	// it succeeds once the conflicting change is undone.
+	do(text0URL, edit.Change(edit.All, "abc"))
+	do(text1URL, edit.Change(edit.Rune(1).To(edit.Rune(2)), "Y"))
+	if res, err := Do(text0URL, edit.SelectiveUndo(1)); err != nil || !strings.Contains(res[0].Error, "undo conflicts") {
+		t.Errorf("Do(%q, o)=%v,%v, want undo conflicts", text0URL, res, err)
+	}
+	do(text1URL, edit.Undo(1))
+	do(text0URL, edit.SelectiveUndo(1))
+	if str := text(); str != "World!" {
+		t.Errorf("after ed0 selective undo, text=%q, want %q", str, "World!")
+	}
+
+	badURL := *bufferURL
+	badURL.RawQuery = "selectiveUndo=maybe"
+	if _, err := NewEditor(&badURL); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Errorf("NewEditor(%q)=_,%v, want Bad Request", badURL.String(), err)
+	}
+}
+
 func TestReader(t *testing.T) {
 	const line1 = "Hello, World\n"
 	const hi = line1 + "☺☹\n←→\n"
@@ -603,6 +1435,56 @@ func TestReader(t *testing.T) {
 	}
 }
 
+func TestReaderConcurrentEdit(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, ed, err)
+	}
+	textURL := s.PathURL(ed.Path, "text")
+
+	// Large enough that the server blocks
+	// writing the response until it is read.
+	text := strings.Repeat("Hello, 世界\n", 1<<20)
+	edits := []edit.Edit{edit.Append(edit.All, text)}
+	if resp, err := Do(textURL, edits...); err != nil {
+		t.Fatalf("Do(%q, %v...)=%v,%v, want _,nil", textURL, edits, resp, err)
+	}
+
+	r, err := Reader(textURL, nil)
+	if err != nil {
+		t.Fatalf("Reader(%v,nil)=_,%v, want _,nil", textURL, err)
+	}
+	defer r.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := Do(textURL, edit.Delete(edit.All))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Do(%q, %v)=_,%v, want _,nil", textURL, edit.Delete(edit.All), err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Do(%q, %v) blocked by an unfinished read", textURL, edit.Delete(edit.All))
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != text {
+		t.Errorf("ioutil.ReadAll(r)=%d bytes,%v, want %d bytes,nil", len(data), err, len(text))
+	}
+}
+
 func TestChangeStream(t *testing.T) {
 	s := editortest.NewServer(NewServer())
 	defer s.Close()
@@ -780,3 +1662,73 @@ func TestChangeStream_NotFound(t *testing.T) {
 		changes.Close()
 	}
 }
+
+func TestChangeStream_Bytes(t *testing.T) {
+	s := editortest.NewServer(NewServer())
+	defer s.Close()
+
+	buffersURL := s.PathURL("/", "buffers")
+	buf, err := NewBuffer(buffersURL)
+	if err != nil {
+		t.Fatalf("NewBuffer(%q)=%v,%v, want _,nil", buffersURL, buf, err)
+	}
+
+	bufferURL := s.PathURL(buf.Path)
+	ed, err := NewEditor(bufferURL)
+	if err != nil {
+		t.Fatalf("NewEditor(%q)=%v,%v, want _,nil", bufferURL, buf, err)
+	}
+
+	changesURL := s.PathURL(buf.Path, "changes")
+	changesURL.Scheme = "ws"
+	changesURL.RawQuery = "units=bytes"
+	changes, err := Changes(changesURL)
+	if err != nil {
+		t.Fatalf("Changes(%q)=_,%v, want _,nil", changesURL, err)
+	}
+	defer changes.Close()
+
+	badURL := *changesURL
+	badURL.RawQuery = "units=lines"
+	if bad, err := Changes(&badURL); err == nil {
+		bad.Close()
+		t.Errorf("Changes(%q)=_,nil, want _,non-nil", &badURL)
+	}
+
+	eds := []edit.Edit{
+		edit.Insert(edit.All, "Hello, 世界!"),
+		edit.Change(edit.Regexp("世界"), "World"),
+		edit.Change(edit.Regexp("World"), "世界"),
+	}
+	textURL := s.PathURL(ed.Path, "text")
+	if res, err := Do(textURL, eds...); err != nil {
+		t.Fatalf("ed.Do(%q, %v...)=%v,%v want _,nil", textURL, eds, res, err)
+	}
+
+	wants := []ChangeList{
+		{
+			Sequence: 1,
+			Changes: []Change{
+				{Span: edit.Span{0, 0}, NewSize: 14},
+			},
+		},
+		{
+			Sequence: 2,
+			Changes: []Change{
+				{Span: edit.Span{7, 13}, NewSize: 5, Text: []byte("World")},
+			},
+		},
+		{
+			Sequence: 3,
+			Changes: []Change{
+				{Span: edit.Span{7, 12}, NewSize: 6, Text: []byte("世界")},
+			},
+		},
+	}
+	for _, want := range wants {
+		got, err := changes.Next()
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("changes.Next()=%v,%v, want %v,nil", got, err, want)
+		}
+	}
+}
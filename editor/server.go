@@ -5,14 +5,19 @@ package editor
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/eaburns/T/edit"
 	"github.com/eaburns/T/websocket"
@@ -35,11 +40,34 @@ import (
 // https://godoc.org/github.com/eaburns/T/edit#Ed.
 // While multiple editors can edit the same buffer concurrently,
 // each editor maintains its own local state.
+//
+// Files
+//
+// Each buffer has an associated file name,
+// used by the file edits of the T edit language.
+// Files are accessed using the Server's edit.FileSystem,
+// which is edit.OSFileSystem unless set by SetFileSystem.
+//
+// A buffer can be opened from a file, saved, saved as another file,
+// and reverted to the contents of its file.
+// A buffer tracks whether it is dirty,
+// that is, whether its text has changed since it was last
+// opened, saved, or reverted.
+// If the edit.FileSystem is an edit.RenameFileSystem,
+// saving writes a temporary file and renames it to the buffer's file,
+// so the file is never left partially written.
+//
+// Undo
+//
+// Each buffer has an undo tree, limited by the Server's edit.UndoLimits,
+// which are no limits unless set by SetUndoLimits.
 type Server struct {
 	sync.RWMutex
-	buffers map[string]*buffer
-	editors map[string]*editor
-	nextID  int
+	buffers    map[string]*buffer
+	editors    map[string]*editor
+	nextID     int
+	fs         edit.FileSystem
+	undoLimits edit.UndoLimits
 }
 
 // NewServer returns a new Server.
@@ -50,6 +78,22 @@ func NewServer() *Server {
 	}
 }
 
+// SetFileSystem sets the edit.FileSystem
+// used by buffers that are subsequently created.
+func (s *Server) SetFileSystem(fs edit.FileSystem) {
+	s.Lock()
+	s.fs = fs
+	s.Unlock()
+}
+
+// SetUndoLimits sets the edit.UndoLimits
+// of the undo trees of buffers that are subsequently created.
+func (s *Server) SetUndoLimits(l edit.UndoLimits) {
+	s.Lock()
+	s.undoLimits = l
+	s.Unlock()
+}
+
 // Close closes the server and all of its buffers.
 func (s *Server) Close() error {
 	s.Lock()
@@ -75,10 +119,32 @@ func (s *Server) Close() error {
 // 	• OK on success.
 // 	• Internal Server Error on internal error.
 //
-// 	PUT creates a new, empty buffer and returns its Buffer.
+// 	PUT creates a new buffer and returns its Buffer.
+// 	Parameters:
+// 	• file can optionally be set to the path of a file.
+// 	  If it is set, the buffer is opened from the file:
+// 	  its text is read from the file, as by the edit.EditFile edit,
+// 	  and the file becomes the buffer's file.
+// 	  Otherwise, the buffer is empty.
+// 	Returns:
+// 	• OK on success.
+// 	• Internal Server Error on internal error.
+// 	• Not Found if the file is not found.
+// 	• Bad Request if the URL parameters are malformed.
+//
+// 	POST performs an edit on each buffer
+// 	with a file name matching a regular expression.
+// 	The body must be an X or Y Edit; see edit.FileLoop.
+// 	It may be in either form accepted by /editor/<ID>/text POST.
+// 	The body of the X or Y is performed on each matching buffer
+// 	by a temporary editor with all marks
+// 	set to the empty string at the beginning of the buffer.
+// 	The response is a list of BufferEditResult,
+// 	one for each matching buffer, ordered by buffer ID.
 // 	Returns:
 // 	• OK on success.
 // 	• Internal Server Error on internal error.
+// 	• Bad Request if the Edit is malformed or is not an X or Y Edit.
 //
 //  /buffer/<ID> is the buffer with the given ID.
 //
@@ -95,19 +161,70 @@ func (s *Server) Close() error {
 // 	• Not Found if the buffer is not found.
 //
 // 	PUT creates a new editor for the buffer and returns its Editor.
+// 	Parameters:
+// 	• selectiveUndo can optionally be set to true.
+// 	  If it is set, the editor's undo edits
+// 	  only undo changes made by the editor,
+// 	  keeping intact changes made since by other editors.
+// 	  See edit.SelectiveUndo.
 // 	Returns:
 // 	• OK on success.
 // 	• Internal Server Error on internal error.
 // 	• Not Found if the buffer is not found.
+// 	• Bad Request if the URL parameters are malformed.
 //
 //  /buffer/<ID>/changes is the buffer's change stream.
 //
 // 	GET upgrades the connection to a websocket.
 // 	A ChangeList is sent on the websocket
 // 	for each edit made to the buffer.
+// 	Parameters:
+// 	• units can optionally be set to "runes" or "bytes".
+// 	  It sets the units of the Spans and sizes of the Changes;
+// 	  see RuneUnits and ByteUnits.
+// 	  The default is "runes".
 // 	Returns:
 // 	• Internal Server Error on internal error.
 // 	• Not Found if the buffer is not found.
+// 	• Bad Request if the URL parameters are malformed.
+//
+//  /buffer/<ID>/save saves the buffer to a file.
+//
+// 	POST writes the buffer's text to its file
+// 	using its encoding and line ending,
+// 	and returns the buffer's Buffer.
+// 	Parameters:
+// 	• file can optionally be set to the path of a file.
+// 	  If it is set, the text is written to the file instead,
+// 	  and the file becomes the buffer's file.
+// 	Returns:
+// 	• OK on success.
+// 	• Internal Server Error on internal error.
+// 	• Not Found if the buffer is not found.
+// 	• Bad Request if the URL parameters are malformed
+// 	  or the buffer has no file.
+//
+//  /buffer/<ID>/revert reverts the buffer to its file.
+//
+// 	POST replaces the buffer's text with the contents of its file,
+// 	as by the edit.EditFile edit,
+// 	and returns the buffer's Buffer.
+// 	The change is an edit on the buffer, with its own Sequence,
+// 	and it can be undone.
+// 	Returns:
+// 	• OK on success.
+// 	• Internal Server Error on internal error.
+// 	• Not Found if the buffer or its file is not found.
+// 	• Bad Request if the buffer has no file.
+//
+//  /buffer/<ID>/undo is the buffer's undo tree.
+//
+// 	GET returns an UndoState list of the states of the undo tree,
+// 	ordered by ID.
+// 	Returns:
+// 	• OK on success.
+// 	• Internal Server Error on internal error.
+// 	• Not Found if the buffer is not found.
 //
 //  /editor/<ID> is the editor with the given ID.
 //
@@ -123,9 +240,31 @@ func (s *Server) Close() error {
 // 	• Internal Server Error on internal error.
 // 	• Not Found if the editor editor is not found.
 //
+//  /editor/<ID>/undo is the undo tree of the editor's buffer.
+//
+// 	POST moves the buffer to a state of its undo tree,
+// 	undoing and redoing changes along the path between the states,
+// 	as the edit.UndoTo edit performed by the editor.
+// 	The body must be either the JSON-encoded ID of the state,
+// 	or a JSON object with exactly one of the following fields:
+// 	• state is the ID of the state.
+// 	• sequence is the Sequence of an edit on the buffer;
+// 	  the buffer moves to the state that was current after the edit.
+// 	• checkpoint is the name of a checkpoint,
+// 	  set by the edit.Checkpoint edit;
+// 	  the buffer moves to the state of the checkpoint.
+// 	The response is an EditResult.
+// 	Returns:
+// 	• OK on success.
+// 	• Internal Server Error on internal error.
+// 	• Not Found if the editor is not found.
+// 	• Bad Request if the body is malformed.
+//
 //  /editor/<ID>/text is the text that the editor edits.
 //
 // 	GET returns the text of the editor's buffer.
+// 	The text is read from a snapshot of the buffer,
+// 	so edits may proceed while the text is returned.
 // 	Parameters:
 // 	• addr can optionally be set to an address string.
 // 	  It must not appear multiple times, there can only be one addr.
@@ -141,23 +280,42 @@ func (s *Server) Close() error {
 //
 // 	POST performs an atomic sequence of edits on the buffer.
 // 	The body must be an ordered list of Edits.
+// 	Each Edit is either a string in the edit language
+// 	or a JSON object encoding the Edit as described by the edit package.
 // 	The response is an ordered list of EditResult.
+// 	Parameters:
+// 	• dryRun can optionally be set to "changes" or "diff".
+// 	  If it is set, the edits are not applied to the buffer;
+// 	  instead they are evaluated on a copy of the buffer,
+// 	  and the changes that they would make are reported.
+// 	  See DryRun for details.
+// 	• units can optionally be set to "runes" or "bytes".
+// 	  It sets the units of the Changes of a "changes" dry run,
+// 	  as for the buffer's change stream.
 // 	Returns:
 // 	• OK on success.
 // 	• Internal Server Error on internal error.
 // 	• Not Found if the editor is not found.
-// 	• Bad Request if the Edit list is malformed.
+// 	• Bad Request if the Edit list or URL parameters are malformed.
 //
 // Unless otherwise stated, the body of all error responses is the error message.
+// However, a Bad Request response due to a malformed Edit or Address
+// has a JSON-encoded ErrorResponse body,
+// giving the position of the error when it is a syntax error.
 func (s *Server) RegisterHandlers(r *mux.Router) {
 	r.HandleFunc("/buffers", s.listBuffers).Methods(http.MethodGet)
 	r.HandleFunc("/buffers", s.newBuffer).Methods(http.MethodPut)
+	r.HandleFunc("/buffers", s.editBuffers).Methods(http.MethodPost)
 	r.HandleFunc("/buffer/{id}", s.bufferInfo).Methods(http.MethodGet)
 	r.HandleFunc("/buffer/{id}", s.closeBuffer).Methods(http.MethodDelete)
 	r.HandleFunc("/buffer/{id}", s.newEditor).Methods(http.MethodPut)
 	r.HandleFunc("/buffer/{id}/changes", s.changes).Methods(http.MethodGet)
+	r.HandleFunc("/buffer/{id}/save", s.save).Methods(http.MethodPost)
+	r.HandleFunc("/buffer/{id}/revert", s.revert).Methods(http.MethodPost)
+	r.HandleFunc("/buffer/{id}/undo", s.undoStates).Methods(http.MethodGet)
 	r.HandleFunc("/editor/{id}", s.editorInfo).Methods(http.MethodGet)
 	r.HandleFunc("/editor/{id}", s.closeEditor).Methods(http.MethodDelete)
+	r.HandleFunc("/editor/{id}/undo", s.undoTo).Methods(http.MethodPost)
 	r.HandleFunc("/editor/{id}/text", s.read).Methods(http.MethodGet)
 	r.HandleFunc("/editor/{id}/text", s.edit).Methods(http.MethodPost)
 }
@@ -175,6 +333,59 @@ func respond(w http.ResponseWriter, resp interface{}) {
 	}
 }
 
+// UnitsParam returns the value of the units URL parameter,
+// or RuneUnits if it is not set.
+func unitsParam(vars url.Values) (string, error) {
+	u, ok := vars["units"]
+	switch {
+	case !ok:
+		return RuneUnits, nil
+	case len(u) > 1 || u[0] != RuneUnits && u[0] != ByteUnits:
+		return "", errors.New("bad units")
+	}
+	return u[0], nil
+}
+
+// FileParam returns the value of the file URL parameter,
+// or the empty string if it is not set.
+func fileParam(vars url.Values) (string, error) {
+	f, ok := vars["file"]
+	switch {
+	case !ok:
+		return "", nil
+	case len(f) > 1:
+		return "", errors.New("file can only be given once")
+	case f[0] == "":
+		return "", errors.New("bad file")
+	}
+	return f[0], nil
+}
+
+// fileError sends the response for an error reading or writing a file.
+func fileError(w http.ResponseWriter, err error) {
+	switch {
+	case err == edit.ErrNoFileName:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case os.IsNotExist(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// badRequest sends a Bad Request with a JSON-encoded ErrorResponse body.
+func badRequest(w http.ResponseWriter, err error) {
+	body, err2 := json.Marshal(newErrorResponse(err))
+	if err2 != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(body)
+}
+
 func (s *Server) listBuffers(w http.ResponseWriter, req *http.Request) {
 	s.RLock()
 	var bufs []Buffer
@@ -187,24 +398,126 @@ func (s *Server) listBuffers(w http.ResponseWriter, req *http.Request) {
 }
 
 func (s *Server) newBuffer(w http.ResponseWriter, req *http.Request) {
+	vars, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, err := fileParam(vars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	s.Lock()
 	id := strconv.Itoa(s.nextID)
 	s.nextID++
 	buf := &buffer{
 		Buffer: Buffer{
-			ID:   id,
-			Path: path.Join("/", "buffer", id),
+			ID:         id,
+			Path:       path.Join("/", "buffer", id),
+			Encoding:   edit.AutoEncoding.String(),
+			LineEnding: edit.AutoLineEnding.String(),
 		},
-		buffer:  edit.NewBuffer(),
-		editors: make(map[string]*editor),
-		done:    make(chan struct{}),
+		buffer:    edit.NewBuffer(),
+		editors:   make(map[string]*editor),
+		sequences: []sequenceState{{}},
+		done:      make(chan struct{}),
+	}
+	if s.fs != nil {
+		buf.buffer.SetFileSystem(s.fs)
+	}
+	err = buf.buffer.SetUndoLimits(s.undoLimits)
+	s.Unlock()
+	if err != nil {
+		buf.close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf.Undo = newUndoUsage(buf.buffer.UndoUsage())
+	buf.saved = buf.buffer.CurrentUndoState()
+
+	// The buffer is opened before it is added to the server,
+	// so no other request can access it yet.
+	if file != "" {
+		if err := buf.revert(file); err != nil {
+			buf.close()
+			fileError(w, err)
+			return
+		}
 	}
+
+	s.Lock()
 	s.buffers[buf.ID] = buf
 	s.Unlock()
 
 	respond(w, buf.Buffer)
 }
 
+func (s *Server) editBuffers(w http.ResponseWriter, req *http.Request) {
+	var e editRequest
+	if err := json.NewDecoder(req.Body).Decode(&e); err != nil {
+		badRequest(w, err)
+		return
+	}
+	loop, ok := e.Edit.(edit.FileLoop)
+	if !ok {
+		badRequest(w, errors.New("not an X or Y edit: "+e.String()))
+		return
+	}
+	if _, err := loop.Match(""); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	s.RLock()
+	var bufs []*buffer
+	for _, b := range s.buffers {
+		bufs = append(bufs, b)
+	}
+	s.RUnlock()
+	sort.Sort(bufferIDSlice(bufs))
+
+	results := []BufferEditResult{}
+	print := bytes.NewBuffer(nil)
+	for _, buf := range bufs {
+		buf.Lock()
+		select {
+		case <-buf.done:
+			// The buffer was closed.
+			buf.Unlock()
+			continue
+		default:
+		}
+		if ok, _ := loop.Match(buf.buffer.FileName()); !ok {
+			buf.Unlock()
+			continue
+		}
+		var result EditResult
+		buf.withTempEditor(func(ed *editor) {
+			result = ed.do(loop.Body, print)
+		})
+		results = append(results, BufferEditResult{
+			BufferPath: buf.Path,
+			EditResult: result,
+		})
+		buf.Unlock()
+	}
+
+	respond(w, results)
+}
+
+type bufferIDSlice []*buffer
+
+func (s bufferIDSlice) Len() int      { return len(s) }
+func (s bufferIDSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s bufferIDSlice) Less(i, j int) bool {
+	// IDs are assigned in increasing numeric order.
+	a, b := s[i].ID, s[j].ID
+	return len(a) < len(b) || len(a) == len(b) && a < b
+}
+
 func (s *Server) bufferInfo(w http.ResponseWriter, req *http.Request) {
 	s.RLock()
 	buf, ok := s.buffers[mux.Vars(req)["id"]]
@@ -242,7 +555,70 @@ func (s *Server) closeBuffer(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func (s *Server) save(w http.ResponseWriter, req *http.Request) {
+	vars, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, err := fileParam(vars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Lock()
+	buf, ok := s.buffers[mux.Vars(req)["id"]]
+	if !ok {
+		s.Unlock()
+		http.NotFound(w, req)
+		return
+	}
+	buf.Lock()
+	s.Unlock()
+	err = buf.save(file)
+	info := buf.Buffer
+	buf.Unlock()
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	respond(w, info)
+}
+
+func (s *Server) revert(w http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	buf, ok := s.buffers[mux.Vars(req)["id"]]
+	if !ok {
+		s.Unlock()
+		http.NotFound(w, req)
+		return
+	}
+	buf.Lock()
+	s.Unlock()
+	err := buf.revert("")
+	info := buf.Buffer
+	buf.Unlock()
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	respond(w, info)
+}
+
 func (s *Server) changes(w http.ResponseWriter, req *http.Request) {
+	vars, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	units, err := unitsParam(vars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	s.Lock()
 	buf, ok := s.buffers[mux.Vars(req)["id"]]
 	if !ok {
@@ -253,13 +629,13 @@ func (s *Server) changes(w http.ResponseWriter, req *http.Request) {
 	buf.Lock()
 	s.Unlock()
 	changes := make(chan []ChangeList, 1)
-	buf.watchers = append(buf.watchers, changes)
+	buf.watchers = append(buf.watchers, watcher{units: units, changes: changes})
 	buf.Unlock()
 
 	defer func() {
 		buf.Lock()
 		for i := range buf.watchers {
-			if buf.watchers[i] == changes {
+			if buf.watchers[i].changes == changes {
 				buf.watchers = append(buf.watchers[:i], buf.watchers[i+1:]...)
 				if buf.watcherRemoved != nil {
 					buf.watcherRemoved <- struct{}{}
@@ -299,6 +675,22 @@ func (s *Server) changes(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func (s *Server) undoStates(w http.ResponseWriter, req *http.Request) {
+	s.RLock()
+	buf, ok := s.buffers[mux.Vars(req)["id"]]
+	if !ok {
+		s.RUnlock()
+		http.NotFound(w, req)
+		return
+	}
+	buf.RLock()
+	states := newUndoStates(buf.buffer.UndoStates())
+	buf.RUnlock()
+	s.RUnlock()
+
+	respond(w, states)
+}
+
 func recvUntilError(conn *websocket.Conn, done chan<- struct{}) {
 	defer close(done)
 	for {
@@ -312,6 +704,23 @@ func recvUntilError(conn *websocket.Conn, done chan<- struct{}) {
 }
 
 func (s *Server) newEditor(w http.ResponseWriter, req *http.Request) {
+	vars, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var selectiveUndo bool
+	if v, ok := vars["selectiveUndo"]; ok {
+		if len(v) > 1 {
+			http.Error(w, "selectiveUndo can only be given once", http.StatusBadRequest)
+			return
+		}
+		if selectiveUndo, err = strconv.ParseBool(v[0]); err != nil {
+			http.Error(w, "bad selectiveUndo: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	s.Lock()
 	buf, ok := s.buffers[mux.Vars(req)["id"]]
 	if !ok {
@@ -325,9 +734,10 @@ func (s *Server) newEditor(w http.ResponseWriter, req *http.Request) {
 	s.nextID++
 	ed := &editor{
 		Editor: Editor{
-			ID:         id,
-			Path:       path.Join("/", "editor", id),
-			BufferPath: buf.Path,
+			ID:            id,
+			Path:          path.Join("/", "editor", id),
+			BufferPath:    buf.Path,
+			SelectiveUndo: selectiveUndo,
 		},
 		buffer: buf,
 		Buffer: buf.buffer,
@@ -391,9 +801,17 @@ func (s *Server) read(w http.ResponseWriter, req *http.Request) {
 		http.NotFound(w, req)
 		return
 	}
+	// The text is read from a snapshot,
+	// so that slow reads do not hold the buffer's lock.
 	ed.buffer.Lock()
-	defer ed.buffer.Unlock()
 	s.Unlock()
+	snap, err := ed.Buffer.Snapshot()
+	ed.buffer.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer snap.Close()
 
 	addr := edit.All
 	vars, err := url.ParseQuery(req.URL.RawQuery)
@@ -409,30 +827,55 @@ func (s *Server) read(w http.ResponseWriter, req *http.Request) {
 		r := strings.NewReader(a[0])
 		addr, err = edit.Addr(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			badRequest(w, err)
 			return
 		}
-		if r.Len() != 0 {
-			http.Error(w, "bad address: "+a[0], http.StatusBadRequest)
+		if l := r.Len(); l != 0 {
+			rest := a[0][len(a[0])-l:]
+			c, _ := utf8.DecodeRuneInString(rest)
+			badRequest(w, &edit.ParseError{
+				Offset:   int64(utf8.RuneCountInString(a[0][:len(a[0])-l])),
+				Rune:     c,
+				Expected: "end of input",
+				Err:      errors.New("bad address: " + a[0]),
+			})
 			return
 		}
 	}
-	span, err := addr.Where(ed.Buffer)
+	span, err := addr.Where(snap)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain")
-	if _, err = io.Copy(w, ed.Buffer.Reader(span)); err != nil {
+	if _, err = io.Copy(w, snap.Reader(span)); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
 func (s *Server) edit(w http.ResponseWriter, req *http.Request) {
+	vars, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var dryRun string
+	if d, ok := vars["dryRun"]; ok {
+		if len(d) > 1 || d[0] != DryRunChanges && d[0] != DryRunDiff {
+			http.Error(w, "bad dryRun", http.StatusBadRequest)
+			return
+		}
+		dryRun = d[0]
+	}
+	units, err := unitsParam(vars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	var edits []editRequest
 	if err := json.NewDecoder(req.Body).Decode(&edits); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, err)
 		return
 	}
 
@@ -446,20 +889,25 @@ func (s *Server) edit(w http.ResponseWriter, req *http.Request) {
 	ed.buffer.Lock()
 	s.Unlock()
 
-	var results []EditResult
-	print := bytes.NewBuffer(nil)
-	for _, e := range edits {
-		print.Reset()
-		err := e.Do(ed, print)
-		ed.buffer.Sequence++
-		result := EditResult{
-			Sequence: ed.buffer.Sequence,
-			Print:    print.String(),
+	if dryRun != "" {
+		var eds []edit.Edit
+		for _, e := range edits {
+			eds = append(eds, e.Edit)
 		}
+		results, err := dryRunUnits(ed, dryRun, units, eds...)
+		ed.buffer.Unlock()
 		if err != nil {
-			result.Error = err.Error()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		results = append(results, result)
+		respond(w, results)
+		return
+	}
+
+	var results []EditResult
+	print := bytes.NewBuffer(nil)
+	for _, e := range edits {
+		results = append(results, ed.do(e.Edit, print))
 	}
 
 	ed.buffer.Unlock()
@@ -467,6 +915,34 @@ func (s *Server) edit(w http.ResponseWriter, req *http.Request) {
 	respond(w, results)
 }
 
+func (s *Server) undoTo(w http.ResponseWriter, req *http.Request) {
+	var undoReq undoRequest
+	if err := json.NewDecoder(req.Body).Decode(&undoReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e, err := undoReq.edit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Lock()
+	ed, ok := s.editors[mux.Vars(req)["id"]]
+	if !ok {
+		s.Unlock()
+		http.NotFound(w, req)
+		return
+	}
+	ed.buffer.Lock()
+	s.Unlock()
+
+	result := ed.do(e, bytes.NewBuffer(nil))
+	ed.buffer.Unlock()
+
+	respond(w, result)
+}
+
 type buffer struct {
 	sync.RWMutex
 	Buffer
@@ -474,13 +950,145 @@ type buffer struct {
 
 	editors map[string]*editor
 
-	watchers []chan []ChangeList
+	// Sequences records the current undo state after each Sequence
+	// at which the current undo state changed, in increasing order.
+	sequences []sequenceState
+
+	// Saved is the ID of the current undo state
+	// when the buffer was last opened, saved, or reverted.
+	// The buffer is dirty if its current undo state is different.
+	saved int
+	// Synced is whether the current edit
+	// read or wrote the buffer's text from or to its file.
+	synced bool
+
+	watchers []watcher
 	done     chan struct{}
 	// watcherRemoved is for testing purposes.
 	// If non-nil, an empty struct is sent when a watcher is removed.
 	watcherRemoved chan struct{}
 }
 
+// A watcher receives the ChangeLists of a buffer's change stream
+// in the units requested by the stream.
+type watcher struct {
+	units   string
+	changes chan []ChangeList
+}
+
+// A sequenceState is the current undo state after a Sequence.
+type sequenceState struct{ sequence, state int }
+
+// TrimUndo removes the IDs of undo states
+// that were pruned from the undo tree,
+// since they are older than the root,
+// from the buffer's sequences and its editors' own states.
+//
+// Must be called with the write Lock held.
+func (buf *buffer) trimUndo() {
+	root := buf.Undo.Root
+	var i int
+	for i < len(buf.sequences) && buf.sequences[i].state < root {
+		i++
+	}
+	buf.sequences = buf.sequences[i:]
+	for _, ed := range buf.editors {
+		var j int
+		for j < len(ed.own) && ed.own[j] < root {
+			j++
+		}
+		ed.own = ed.own[j:]
+	}
+}
+
+// Update updates the Buffer after an edit on the buffer.
+//
+// Must be called with the write Lock held.
+func (buf *buffer) update() {
+	buf.Sequence++
+	buf.FileName = buf.buffer.FileName()
+	buf.Encoding = buf.buffer.Encoding().String()
+	buf.LineEnding = buf.buffer.LineEnding().String()
+	buf.Undo = newUndoUsage(buf.buffer.UndoUsage())
+	buf.trimUndo()
+	id := buf.buffer.CurrentUndoState()
+	if seqs := buf.sequences; len(seqs) == 0 || seqs[len(seqs)-1].state != id {
+		buf.sequences = append(seqs, sequenceState{buf.Sequence, id})
+	}
+	if buf.synced {
+		buf.SavedSequence = buf.Sequence
+		buf.synced = false
+	}
+	buf.Dirty = id != buf.saved
+}
+
+// SetSaved records that the buffer's text is that of its file.
+//
+// Must be called with the write Lock held.
+func (buf *buffer) setSaved() {
+	buf.saved = buf.buffer.CurrentUndoState()
+	buf.SavedSequence = buf.Sequence
+	buf.Dirty = false
+	buf.synced = false
+}
+
+// WithTempEditor calls f with a temporary editor of the buffer,
+// which has all marks set to the empty string at the beginning of the buffer.
+// The temporary editor is added to the buffer's editors
+// so that its marks are updated by its changes.
+// It uses the empty ID, which is never assigned to an editor.
+//
+// Must be called with the write Lock held.
+func (buf *buffer) withTempEditor(f func(*editor)) {
+	ed := &editor{
+		buffer: buf,
+		Buffer: buf.buffer,
+		marks:  make(map[rune]edit.Span),
+	}
+	buf.editors[ed.ID] = ed
+	f(ed)
+	delete(buf.editors, ed.ID)
+}
+
+// Revert replaces the buffer's text with the contents of the named file,
+// or of the buffer's file if the name is empty,
+// as an edit on the buffer.
+// The file becomes the buffer's file, and the buffer is saved.
+//
+// Must be called with the write Lock held.
+func (buf *buffer) revert(name string) error {
+	var err error
+	buf.withTempEditor(func(ed *editor) {
+		err = edit.EditFile(name).Do(ed, ioutil.Discard)
+		buf.update()
+	})
+	return err
+}
+
+// Save writes the buffer's text to the named file,
+// or to the buffer's file if the name is empty,
+// as by the edit.WriteFile edit.
+// The file becomes the buffer's file, and the buffer is saved.
+//
+// Must be called with the write Lock held.
+func (buf *buffer) save(name string) error {
+	prev := buf.buffer.FileName()
+	if name != "" {
+		buf.buffer.SetFileName(name)
+	}
+	var err error
+	buf.withTempEditor(func(ed *editor) {
+		err = edit.WriteFile(edit.All, "").Do(ed, ioutil.Discard)
+	})
+	if err != nil {
+		buf.buffer.SetFileName(prev)
+		return err
+	}
+	buf.FileName = buf.buffer.FileName()
+	buf.setSaved()
+	return nil
+}
+
 // Must be called with the write Lock held.
 func (buf *buffer) close() error {
 	close(buf.done)
@@ -490,9 +1098,18 @@ func (buf *buffer) close() error {
 type editor struct {
 	Editor
 	*edit.Buffer
-	buffer  *buffer
-	marks   map[rune]edit.Span
-	pending []Change
+	buffer *buffer
+	marks  map[rune]edit.Span
+	// Pending are the Changes staged since the last Apply,
+	// and pendingBytes are the same Changes in ByteUnits.
+	pending, pendingBytes []Change
+
+	// Own are the IDs of the undo states created by the editor,
+	// to be reverted by SelectiveUndo,
+	// and reverting is whether SelectiveUndo is applying a revert,
+	// which is not added to own.
+	own       []int
+	reverting bool
 }
 
 type change struct {
@@ -500,8 +1117,33 @@ type change struct {
 	size int64
 }
 
+// Do performs an edit and returns its EditResult.
+// The print buffer is reset and used to collect printed text.
+//
+// Must be called with the buffer's write Lock held.
+func (ed *editor) do(e edit.Edit, print *bytes.Buffer) EditResult {
+	print.Reset()
+	err := e.Do(ed, print)
+	ed.buffer.update()
+	result := EditResult{
+		Sequence: ed.buffer.Sequence,
+		Print:    print.String(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
 func (ed *editor) Mark(m rune) edit.Span { return ed.marks[m] }
 
+// Synced implements the Synced method of the edit.SyncEditor interface,
+// recording that the buffer is saved as of its current undo state.
+func (ed *editor) Synced() {
+	ed.buffer.saved = ed.Buffer.CurrentUndoState()
+	ed.buffer.synced = true
+}
+
 func (ed *editor) SetMark(m rune, s edit.Span) error {
 	if size := ed.Size(); s[0] < 0 || s[1] < 0 || s[0] > size || s[1] > size {
 		return edit.ErrInvalidArgument
@@ -510,6 +1152,49 @@ func (ed *editor) SetMark(m rune, s edit.Span) error {
 	return nil
 }
 
+// Undo implements the Undo method of the edit.Editor interface.
+// If the editor uses selective undo, it calls SelectiveUndo.
+func (ed *editor) Undo() error {
+	if ed.Editor.SelectiveUndo {
+		return ed.SelectiveUndo()
+	}
+	return ed.Buffer.Undo()
+}
+
+// UndoToSequence implements the UndoToSequence method of the edit.Editor interface.
+// The sequence numbers are the Sequence numbers of edits on the editor's buffer.
+func (ed *editor) UndoToSequence(seq int) error {
+	if seq < 0 || seq > ed.buffer.Sequence {
+		return edit.ErrNoUndoState
+	}
+	seqs := ed.buffer.sequences
+	i := sort.Search(len(seqs), func(i int) bool { return seqs[i].sequence > seq })
+	if i == 0 {
+		// The state was pruned.
+		return edit.ErrNoUndoState
+	}
+	return ed.Buffer.UndoTo(seqs[i-1].state)
+}
+
+// SelectiveUndo implements the SelectiveUndo method of the edit.Editor interface,
+// reverting the most recent changes made by the editor.
+func (ed *editor) SelectiveUndo() error {
+	for len(ed.own) > 0 {
+		id := ed.own[len(ed.own)-1]
+		ed.own = ed.own[:len(ed.own)-1]
+		ed.reverting = true
+		err := ed.Buffer.Revert(ed, id)
+		ed.reverting = false
+		if err == edit.ErrUndoConflict {
+			ed.own = append(ed.own, id)
+		}
+		if err != edit.ErrNoUndoState {
+			return err
+		}
+	}
+	return nil
+}
+
 type changeReader struct {
 	r      io.Reader
 	nbytes int
@@ -530,20 +1215,49 @@ func (cr *changeReader) Read(d []byte) (int, error) {
 func (ed *editor) Change(s edit.Span, r io.Reader) (int64, error) {
 	cr := changeReader{r: r}
 	n, err := ed.Buffer.Change(s, &cr)
-	if err == nil {
-		c := Change{Span: s, NewSize: n}
-		if 0 < cr.nbytes && cr.nbytes <= MaxInline {
-			c.Text = cr.text
+	if err != nil {
+		return n, err
+	}
+	bs, err := edit.ByteSpan(ed.Buffer, s)
+	if err != nil {
+		return n, err
+	}
+	c := Change{Span: s, NewSize: n}
+	if 0 < cr.nbytes && cr.nbytes <= MaxInline {
+		c.Text = cr.text
+	}
+	ed.pending = append(ed.pending, c)
+	// The NewSize in bytes is set by Apply.
+	c.Span = bs
+	ed.pendingBytes = append(ed.pendingBytes, c)
+	return n, nil
+}
+
+// SetByteSizes sets the NewSize of each of the Changes in ByteUnits
+// to the size of the UTF-8 encoding of its new text,
+// given the same Changes in RuneUnits,
+// just applied to the text.
+func setByteSizes(text edit.Text, changes, byteChanges []Change) error {
+	var delta int64
+	for i, c := range changes {
+		at := c.Span[0] + delta
+		s, err := edit.ByteSpan(text, edit.Span{at, at + c.NewSize})
+		if err != nil {
+			return err
 		}
-		ed.pending = append(ed.pending, c)
+		byteChanges[i].NewSize = s.Size()
+		delta += c.NewSize - c.Span.Size()
 	}
-	return n, err
+	return nil
 }
 
 func (ed *editor) Apply() error {
 	if err := ed.Buffer.Apply(); err != nil {
 		return err
 	}
+	if err := setByteSizes(ed.Buffer, ed.pending, ed.pendingBytes); err != nil {
+		return err
+	}
 	for _, c := range ed.pending {
 		for _, e := range ed.buffer.editors {
 			for m, s := range e.marks {
@@ -564,17 +1278,29 @@ func (ed *editor) Apply() error {
 	if len(ed.pending) == 0 {
 		return nil
 	}
+	if !ed.reverting {
+		ed.own = append(ed.own, ed.Buffer.CurrentUndoState())
+	}
 	cl := ChangeList{
 		Sequence: ed.buffer.Sequence + 1,
 		Changes:  ed.pending,
 	}
-	for _, c := range ed.buffer.watchers {
+	clBytes := ChangeList{
+		Sequence: cl.Sequence,
+		Changes:  ed.pendingBytes,
+	}
+	for _, w := range ed.buffer.watchers {
+		cl := cl
+		if w.units == ByteUnits {
+			cl = clBytes
+		}
 		select {
-		case cls := <-c:
-			c <- append(cls, cl)
-		case c <- []ChangeList{cl}:
+		case cls := <-w.changes:
+			w.changes <- append(cls, cl)
+		case w.changes <- []ChangeList{cl}:
 		}
 	}
 	ed.pending = nil
+	ed.pendingBytes = nil
 	return nil
 }
@@ -80,7 +80,8 @@ type View struct {
 type Mark struct {
 	// Name is the mark rune.
 	Name rune
-	// Where is the address of the mark as rune offsets.
+	// Where is the address of the mark as byte offsets
+	// in the UTF-8 encoding of the buffer.
 	Where [2]int64
 }
 
@@ -278,7 +279,7 @@ func (v *View) edit(vd viewDo, Notify chan<- struct{}) error {
 		if m.Name == '.' {
 			n = TmpMark
 		}
-		prints = append(prints, edit.Where(edit.Mark(n)))
+		prints = append(prints, edit.WhereBytes(edit.Mark(n)))
 	}
 	start := edit.Mark(ViewMark).Minus(edit.Rune(0))
 	end := start.Plus(edit.Clamp(edit.Line(v.n)))
@@ -306,7 +307,7 @@ func (v *View) edit(vd viewDo, Notify chan<- struct{}) error {
 	}
 	for i := range v.marks {
 		m := &v.marks[i]
-		n, err := fmt.Sscanf(printed[i], "#%d,#%d", &m.Where[0], &m.Where[1])
+		n, err := fmt.Sscanf(printed[i], "#b%d,#b%d", &m.Where[0], &m.Where[1])
 		if n == 1 {
 			m.Where[1] = m.Where[0]
 		} else if n != 2 || err != nil {
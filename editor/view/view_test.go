@@ -346,6 +346,53 @@ func TestTrackMarks(t *testing.T) {
 	}
 }
 
+func TestTrackMarksBytes(t *testing.T) {
+	bufferURL, close := testBuffer()
+	defer close()
+
+	setText(bufferURL, "αβ\n世界\nxyz\n")
+
+	v, err := New(bufferURL, 'm', '.')
+	if err != nil {
+		t.Fatalf("New(%q, 'm', '.')=_,%v, want _,nil", bufferURL, err)
+	}
+	defer v.Close()
+
+	if v.Resize(2) {
+		wait(v)
+	}
+
+	v.Do(nil, edit.Set(edit.Line(2), 'm'), edit.Set(edit.Rune(1), '.'))
+	wait(v)
+
+	want := [2]int64{5, 12}
+	if got, ok := markAddr(v, 'm'); !ok || got != want {
+		t.Errorf("mark['m']=%v,%v, want %v,true", got, ok, want)
+	}
+	want = [2]int64{2, 2}
+	if got, ok := markAddr(v, '.'); !ok || got != want {
+		t.Errorf("mark['.']=%v,%v, want %v,true", got, ok, want)
+	}
+
+	// The View's text is indexed by the byte offsets of the marks.
+	v.Warp(edit.Line(2))
+	wait(v)
+	v.View(func(text []byte, marks []Mark) {
+		var start, m [2]int64
+		for _, mark := range marks {
+			switch mark.Name {
+			case ViewMark:
+				start = mark.Where
+			case 'm':
+				m = mark.Where
+			}
+		}
+		if got := string(text[m[0]-start[0] : m[1]-start[0]]); got != "世界\n" {
+			t.Errorf("text[m]=%q, want %q", got, "世界\n")
+		}
+	})
+}
+
 // TestMaintainDot checks that, whatever we do under the hood, we don't affect dot.
 func TestMaintainDot(t *testing.T) {
 	bufferURL, close := testBuffer()
@@ -6,9 +6,7 @@
 // It edits a single buffer, with the T edit language.
 // The T language is documented here:
 // https://godoc.org/github.com/eaburns/T/edit#Ed.
-// Ted adds a few additional commands:
-// 	e filename 	replaces the buffer with the contents of the file
-// 	w filename	saves the buffer to the named file
+// Ted adds an additional command:
 // 	q 		quits
 package main
 
@@ -46,7 +44,6 @@ func main() {
 
 	var nl bool
 	var prevAddr edit.Edit
-	var file string
 	for {
 		var e edit.Edit
 		r, _, err := in.ReadRune()
@@ -60,32 +57,6 @@ func main() {
 			if nl && prevAddr != nil {
 				e = prevAddr
 			}
-		case r == 'w':
-			line, err := readLine(in)
-			if err != nil {
-				fmt.Println("failed to read input:", err)
-				return
-			}
-			if line != "" {
-				file = strings.TrimSpace(line)
-			}
-			if file == "" {
-				fmt.Println("w requires an argument")
-				continue
-			}
-			e = edit.PipeTo(edit.All, "cat > "+file)
-		case r == 'e':
-			line, err := readLine(in)
-			if err != nil {
-				fmt.Println("failed to read input:", err)
-				return
-			}
-			if line == "" {
-				fmt.Println("e requires an argument")
-				continue
-			}
-			file = strings.TrimSpace(line)
-			e = edit.PipeFrom(edit.All, "cat < "+file)
 		default:
 			if err := in.UnreadRune(); err != nil {
 				panic(err) // Can't fail with bufio.Reader.
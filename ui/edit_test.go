@@ -829,6 +829,9 @@ func (h *testHandler) where(p image.Point) int64 {
 	if s[0] != s[1] {
 		panic("range address")
 	}
+	if s, err = edit.ByteSpan(h.buf, s); err != nil {
+		panic(err)
+	}
 	return s[0]
 }
 
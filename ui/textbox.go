@@ -248,7 +248,7 @@ type doer interface {
 
 type mouseHandler interface {
 	doer
-	// Where returns the rune address
+	// Where returns the byte address
 	// corresponding to the glyph at the given point.
 	where(image.Point) int64
 	// Exec executes a command.
@@ -266,12 +266,12 @@ func handleMouse(h mouseHandler, event mouse.Event) {
 	case mouse.DirPress:
 		switch event.Button {
 		case mouse.ButtonLeft:
-			h.do(nil, edit.Set(edit.Rune(h.where(p)), '.'))
+			h.do(nil, edit.Set(edit.Byte(h.where(p)), '.'))
 		case mouse.ButtonMiddle:
 			// TODO(eaburns): This makes a blocking RPC,
 			// but it's called from the mouse handler.
 			// We should find a way to avoid blocking in the mouse handler.
-			rune := edit.Rune(h.where(p))
+			rune := edit.Byte(h.where(p))
 			re := edit.Regexp("[a-zA-Z0-9_. -+/]*") // file name characters
 			res := make(chan []editor.EditResult)
 			h.do(res,